@@ -0,0 +1,590 @@
+// Package config holds the typed server configuration.
+//
+// Settings are resolved in increasing order of precedence:
+//
+//	built-in defaults < config file < VESTRA_* env vars < command-line flags
+//
+// The config file is YAML, or TOML when its name ends in .toml. Every
+// setting is reachable from all three sources. The key timeouts.browse, for
+// example, is also VESTRA_TIMEOUTS_BROWSE and -timeouts.browse.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvConfigFile names the config file when -config is not given.
+const EnvConfigFile = "VESTRA_CONFIG"
+
+// DefaultDSN is the zero-config SQLite database in the working directory.
+const DefaultDSN = "file:data.db?_foreign_keys=1"
+
+// Timeouts bounds how long each kind of request may take, including the
+// round trips to the storage provider.
+type Timeouts struct {
+	Test           time.Duration `yaml:"test" toml:"test"`
+	Browse         time.Duration `yaml:"browse" toml:"browse"`
+	List           time.Duration `yaml:"list" toml:"list"`
+	Download       time.Duration `yaml:"download" toml:"download"`
+	Delete         time.Duration `yaml:"delete" toml:"delete"`
+	Copy           time.Duration `yaml:"copy" toml:"copy"`
+	Upload         time.Duration `yaml:"upload" toml:"upload"`
+	Stats          time.Duration `yaml:"stats" toml:"stats"`
+	Metadata       time.Duration `yaml:"metadata" toml:"metadata"`
+	MetadataUpdate time.Duration `yaml:"metadata_update" toml:"metadata_update"`
+}
+
+// Config is the effective server configuration.
+type Config struct {
+	Listen        string   `yaml:"listen" toml:"listen"`
+	DBDSN         string   `yaml:"db_dsn" toml:"db_dsn"`
+	DocsDir       string   `yaml:"docs_dir" toml:"docs_dir"`
+	MasterKeyFile string   `yaml:"master_key_file" toml:"master_key_file"`
+	CORSOrigins   []string `yaml:"cors_origins" toml:"cors_origins"`
+
+	// LocalRoots lists the host directories "local" connections may expose.
+	// The local provider is disabled while it is empty.
+	LocalRoots []string `yaml:"local_roots" toml:"local_roots"`
+
+	// AmbientCredentials lets connections authenticate as the server itself:
+	// environment variables, shared config profiles, instance and workload
+	// identities. Off by default, since anyone who can add a connection
+	// would otherwise act with the server's cloud permissions.
+	AmbientCredentials bool `yaml:"ambient_credentials" toml:"ambient_credentials"`
+
+	// MemoryFixtures is a directory whose subdirectories seed the "memory"
+	// buckets of the same name; MemoryMaxSize caps what those buckets hold.
+	MemoryFixtures string   `yaml:"memory_fixtures" toml:"memory_fixtures"`
+	MemoryMaxSize  ByteSize `yaml:"memory_max_size" toml:"memory_max_size"`
+
+	// MaxUploadSize caps the request body of an upload. Uploads are streamed
+	// to the provider in parts of UploadPartSize, UploadConcurrency at a
+	// time, so each holds at most their product in memory.
+	MaxUploadSize     ByteSize `yaml:"max_upload_size" toml:"max_upload_size"`
+	UploadPartSize    ByteSize `yaml:"upload_part_size" toml:"upload_part_size"`
+	UploadConcurrency int      `yaml:"upload_concurrency" toml:"upload_concurrency"`
+
+	// UploadDir holds the not yet uploaded bytes of resumable uploads, which
+	// are abandoned after UploadExpiry without progress.
+	UploadDir    string        `yaml:"upload_dir" toml:"upload_dir"`
+	UploadExpiry time.Duration `yaml:"upload_expiry" toml:"upload_expiry"`
+
+	// ProxyDownloads streams every download through the server instead of
+	// handing out presigned URLs, for providers the browsers cannot reach.
+	ProxyDownloads bool `yaml:"proxy_downloads" toml:"proxy_downloads"`
+
+	// ArchiveMaxSize caps the total size of the objects in a folder or
+	// selection downloaded as one ZIP or tar.gz.
+	ArchiveMaxSize ByteSize `yaml:"archive_max_size" toml:"archive_max_size"`
+
+	// ExtractMaxSize caps the total size of the files expanded from one
+	// uploaded archive.
+	ExtractMaxSize ByteSize `yaml:"extract_max_size" toml:"extract_max_size"`
+
+	Timeouts Timeouts `yaml:"timeouts" toml:"timeouts"`
+
+	// ShutdownTimeout is how long in-flight requests and background jobs
+	// may run after SIGTERM before they are cancelled.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+
+	// sources records where each setting came from, for Print.
+	sources map[string]string
+}
+
+// Default returns the built-in configuration, which matches what the server
+// did before it was configurable.
+func Default() *Config {
+	return &Config{
+		Listen:            ":8080",
+		DBDSN:             DefaultDSN,
+		CORSOrigins:       []string{"*"},
+		MaxUploadSize:     512 << 20,
+		UploadPartSize:    16 << 20,
+		UploadConcurrency: 4,
+		UploadExpiry:      24 * time.Hour,
+		ArchiveMaxSize:    10 << 30,
+		ExtractMaxSize:    10 << 30,
+		MemoryMaxSize:     256 << 20,
+		Timeouts: Timeouts{
+			Test:           10 * time.Second,
+			Browse:         30 * time.Second,
+			List:           60 * time.Second,
+			Download:       10 * time.Second,
+			Delete:         15 * time.Second,
+			Copy:           30 * time.Second,
+			Upload:         5 * time.Minute,
+			Stats:          60 * time.Second,
+			Metadata:       10 * time.Second,
+			MetadataUpdate: 30 * time.Second,
+		},
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// ── settings table ────────────────────────────────────────────────
+
+// setting binds one config key to its env var, its flag and the field it
+// sets. Keys use the YAML spelling.
+type setting struct {
+	key    string
+	usage  string
+	secret bool
+	get    func(*Config) string
+	set    func(*Config, string) error
+}
+
+func (s setting) env() string {
+	return "VESTRA_" + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+func (s setting) flag() string { return strings.ReplaceAll(s.key, "_", "-") }
+
+func stringSetting(key, usage string, field func(*Config) *string) setting {
+	return setting{
+		key:   key,
+		usage: usage,
+		get:   func(c *Config) string { return *field(c) },
+		set:   func(c *Config, v string) error { *field(c) = v; return nil },
+	}
+}
+
+func durationSetting(key, usage string, field func(*Config) *time.Duration) setting {
+	return setting{
+		key:   key,
+		usage: usage,
+		get:   func(c *Config) string { return field(c).String() },
+		set: func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = d
+			return nil
+		},
+	}
+}
+
+func sizeSetting(key, usage string, field func(*Config) *ByteSize) setting {
+	return setting{
+		key:   key,
+		usage: usage,
+		get:   func(c *Config) string { return field(c).String() },
+		set: func(c *Config, v string) error {
+			n, err := ParseByteSize(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+func intSetting(key, usage string, field func(*Config) *int) setting {
+	return setting{
+		key:   key,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+func boolSetting(key, usage string, field func(*Config) *bool) setting {
+	return setting{
+		key:   key,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.FormatBool(*field(c)) },
+		set: func(c *Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = b
+			return nil
+		},
+	}
+}
+
+func timeoutSetting(name string, field func(*Timeouts) *time.Duration) setting {
+	return durationSetting("timeouts."+name, "timeout for "+strings.ReplaceAll(name, "_", " ")+" requests",
+		func(c *Config) *time.Duration { return field(&c.Timeouts) })
+}
+
+var settings = []setting{
+	stringSetting("listen", "address to listen on", func(c *Config) *string { return &c.Listen }),
+	{
+		key:    "db_dsn",
+		usage:  "SQLite DSN or postgres:// URL of the metadata database",
+		secret: true,
+		get:    func(c *Config) string { return c.DBDSN },
+		set:    func(c *Config, v string) error { c.DBDSN = v; return nil },
+	},
+	stringSetting("docs_dir", "directory served under /api/docs/ (default: auto-detect)", func(c *Config) *string { return &c.DocsDir }),
+	stringSetting("master_key_file", "file holding the credential master key", func(c *Config) *string { return &c.MasterKeyFile }),
+	{
+		key:   "cors_origins",
+		usage: "comma-separated list of allowed CORS origins, or *",
+		get:   func(c *Config) string { return strings.Join(c.CORSOrigins, ",") },
+		set: func(c *Config, v string) error {
+			c.CORSOrigins = splitList(v)
+			return nil
+		},
+	},
+	{
+		key:   "local_roots",
+		usage: "comma-separated host directories that local connections may expose",
+		get:   func(c *Config) string { return strings.Join(c.LocalRoots, ",") },
+		set: func(c *Config, v string) error {
+			c.LocalRoots = splitList(v)
+			return nil
+		},
+	},
+	boolSetting("ambient_credentials", "let connections use the server's own cloud credentials", func(c *Config) *bool { return &c.AmbientCredentials }),
+	stringSetting("memory_fixtures", "directory whose subdirectories seed memory buckets", func(c *Config) *string { return &c.MemoryFixtures }),
+	sizeSetting("memory_max_size", "total size of the objects memory buckets may hold", func(c *Config) *ByteSize { return &c.MemoryMaxSize }),
+	sizeSetting("max_upload_size", "maximum upload request size (e.g. 512MiB)", func(c *Config) *ByteSize { return &c.MaxUploadSize }),
+	sizeSetting("upload_part_size", "part size for streamed uploads (at least 5MiB)", func(c *Config) *ByteSize { return &c.UploadPartSize }),
+	intSetting("upload_concurrency", "parts of one upload sent in parallel", func(c *Config) *int { return &c.UploadConcurrency }),
+	stringSetting("upload_dir", "directory for resumable upload data (default: a temp directory)", func(c *Config) *string { return &c.UploadDir }),
+	durationSetting("upload_expiry", "how long an idle resumable upload is kept", func(c *Config) *time.Duration { return &c.UploadExpiry }),
+	sizeSetting("archive_max_size", "largest total size of a ZIP or tar.gz download (e.g. 10GiB)", func(c *Config) *ByteSize { return &c.ArchiveMaxSize }),
+	sizeSetting("extract_max_size", "largest total size of the files expanded from an uploaded archive (e.g. 10GiB)", func(c *Config) *ByteSize { return &c.ExtractMaxSize }),
+	boolSetting("proxy_downloads", "stream all downloads through the server instead of presigned URLs", func(c *Config) *bool { return &c.ProxyDownloads }),
+	timeoutSetting("test", func(t *Timeouts) *time.Duration { return &t.Test }),
+	timeoutSetting("browse", func(t *Timeouts) *time.Duration { return &t.Browse }),
+	timeoutSetting("list", func(t *Timeouts) *time.Duration { return &t.List }),
+	timeoutSetting("download", func(t *Timeouts) *time.Duration { return &t.Download }),
+	timeoutSetting("delete", func(t *Timeouts) *time.Duration { return &t.Delete }),
+	timeoutSetting("copy", func(t *Timeouts) *time.Duration { return &t.Copy }),
+	timeoutSetting("upload", func(t *Timeouts) *time.Duration { return &t.Upload }),
+	timeoutSetting("stats", func(t *Timeouts) *time.Duration { return &t.Stats }),
+	timeoutSetting("metadata", func(t *Timeouts) *time.Duration { return &t.Metadata }),
+	timeoutSetting("metadata_update", func(t *Timeouts) *time.Duration { return &t.MetadataUpdate }),
+	durationSetting("shutdown_timeout", "how long to drain in-flight requests and jobs on SIGTERM", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// ── loading ───────────────────────────────────────────────────────
+
+// Load resolves the configuration from a config file, the environment and
+// the command-line arguments (without the program name). It returns the
+// remaining non-flag arguments, which name a maintenance subcommand.
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(EnvConfigFile), "path to a YAML or TOML config file (env "+EnvConfigFile+")")
+	flagValues := map[string]*string{}
+	for _, s := range settings {
+		flagValues[s.key] = fs.String(s.flag(), "", s.usage+" (env "+s.env()+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+	cfg.sources = map[string]string{}
+	for _, s := range settings {
+		cfg.sources[s.key] = "default"
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env()); ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.env(), err)
+			}
+			cfg.sources[s.key] = "env"
+		}
+	}
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag() != f.Name || flagErr != nil {
+				continue
+			}
+			if err := s.set(cfg, *flagValues[s.key]); err != nil {
+				flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+			}
+			cfg.sources[s.key] = "flag"
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile overlays the settings present in a YAML or TOML file, told apart
+// by the extension. Unknown keys are rejected so typos don't go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	var keys []string
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		keys, err = c.decodeTOML(data)
+	} else {
+		keys, err = c.decodeYAML(data)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	for _, key := range keys {
+		c.sources[key] = "file"
+	}
+	return nil
+}
+
+// decodeYAML decodes a YAML config and returns the keys it set.
+func (c *Config) decodeYAML(data []byte) ([]string, error) {
+	// Decode twice: once into the struct for typed values, once into a
+	// generic map to learn which keys the file actually set.
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key, v := range raw {
+		if nested, ok := v.(map[string]any); ok {
+			for sub := range nested {
+				keys = append(keys, key+"."+sub)
+			}
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// decodeTOML decodes a TOML config and returns the keys it set.
+func (c *Config) decodeTOML(data []byte) ([]string, error) {
+	md, err := toml.Decode(string(data), c)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown key %s", undecoded[0])
+	}
+	var keys []string
+	for _, key := range md.Keys() {
+		// Tables such as [timeouts] are listed as keys of their own.
+		if md.Type(key...) != "Hash" {
+			keys = append(keys, key.String())
+		}
+	}
+	return keys, nil
+}
+
+// Validate checks that the configuration is usable.
+func (c *Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen: %w", err))
+	}
+	if c.DBDSN == "" {
+		errs = append(errs, errors.New("db_dsn must not be empty"))
+	}
+	if c.DocsDir != "" {
+		if info, err := os.Stat(c.DocsDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("docs_dir: %s is not a directory", c.DocsDir))
+		}
+	}
+	if len(c.CORSOrigins) == 0 {
+		errs = append(errs, errors.New("cors_origins must list at least one origin (use * to allow any)"))
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("cors_origins: %q is not an origin like https://example.com", origin))
+		}
+	}
+	for _, root := range c.LocalRoots {
+		if !filepath.IsAbs(root) {
+			errs = append(errs, fmt.Errorf("local_roots: %q is not an absolute path", root))
+		} else if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("local_roots: %s is not a directory", root))
+		}
+	}
+	if c.MemoryFixtures != "" {
+		if info, err := os.Stat(c.MemoryFixtures); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("memory_fixtures: %s is not a directory", c.MemoryFixtures))
+		}
+	}
+	if c.MemoryMaxSize <= 0 {
+		errs = append(errs, errors.New("memory_max_size must be positive"))
+	}
+	if c.MaxUploadSize <= 0 {
+		errs = append(errs, errors.New("max_upload_size must be positive"))
+	}
+	if c.UploadPartSize < 5<<20 {
+		errs = append(errs, errors.New("upload_part_size must be at least 5MiB"))
+	}
+	if c.UploadConcurrency < 1 {
+		errs = append(errs, errors.New("upload_concurrency must be at least 1"))
+	}
+	if c.UploadExpiry <= 0 {
+		errs = append(errs, errors.New("upload_expiry must be positive"))
+	}
+	if c.ArchiveMaxSize <= 0 {
+		errs = append(errs, errors.New("archive_max_size must be positive"))
+	}
+	if c.ExtractMaxSize <= 0 {
+		errs = append(errs, errors.New("extract_max_size must be positive"))
+	}
+	for _, s := range settings {
+		if !strings.HasPrefix(s.key, "timeouts.") && s.key != "shutdown_timeout" {
+			continue
+		}
+		if d, _ := time.ParseDuration(s.get(c)); d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", s.key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Print writes the effective configuration, one setting per line, with the
+// source of each value. Secrets such as database passwords are masked.
+func (c *Config) Print(w io.Writer) {
+	for _, s := range settings {
+		v := s.get(c)
+		if s.secret {
+			v = maskDSN(v)
+		}
+		if v == "" {
+			v = `""`
+		}
+		source := c.sources[s.key]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "  %-24s %-40s (%s)\n", s.key, v, source)
+	}
+}
+
+// maskDSN hides the password of a URL-style DSN.
+func maskDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil {
+		return dsn
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// ── byte sizes ────────────────────────────────────────────────────
+
+// ByteSize is a size in bytes that can be written as 1048576, 1MiB or 1MB.
+type ByteSize int64
+
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// ParseByteSize parses a byte count with an optional unit suffix.
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s, mult = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(n * mult), nil
+}
+
+func (b ByteSize) String() string {
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if int64(b) >= u.mult && int64(b)%u.mult == 0 {
+			return strconv.FormatInt(int64(b)/u.mult, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+// UnmarshalYAML accepts both plain integers and strings with a unit.
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	n, err := ParseByteSize(node.Value)
+	if err != nil {
+		return err
+	}
+	*b = n
+	return nil
+}
+
+// UnmarshalTOML accepts both plain integers and strings with a unit.
+func (b *ByteSize) UnmarshalTOML(v any) error {
+	switch v := v.(type) {
+	case int64:
+		*b = ByteSize(v)
+		return nil
+	case string:
+		n, err := ParseByteSize(v)
+		if err != nil {
+			return err
+		}
+		*b = n
+		return nil
+	}
+	return fmt.Errorf("invalid size %v", v)
+}
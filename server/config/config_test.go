@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	files := map[string]string{
+		"vestra.yaml": `
+listen: 127.0.0.1:9000
+cors_origins: [https://a.example, https://b.example]
+max_upload_size: 1GiB
+upload_part_size: 8388608
+proxy_downloads: true
+timeouts:
+  browse: 45s
+`,
+		"vestra.toml": `
+listen = "127.0.0.1:9000"
+cors_origins = ["https://a.example", "https://b.example"]
+max_upload_size = "1GiB"
+upload_part_size = 8388608
+proxy_downloads = true
+
+[timeouts]
+browse = "45s"
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(EnvConfigFile, "")
+			cfg, _, err := Load([]string{"-config", writeConfig(t, name, content)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Listen != "127.0.0.1:9000" || len(cfg.CORSOrigins) != 2 || !cfg.ProxyDownloads {
+				t.Errorf("listen %q, cors_origins %v, proxy_downloads %v", cfg.Listen, cfg.CORSOrigins, cfg.ProxyDownloads)
+			}
+			if cfg.MaxUploadSize != 1<<30 || cfg.UploadPartSize != 8<<20 {
+				t.Errorf("max_upload_size %s, upload_part_size %s", cfg.MaxUploadSize, cfg.UploadPartSize)
+			}
+			if cfg.Timeouts.Browse != 45*time.Second || cfg.Timeouts.List != Default().Timeouts.List {
+				t.Errorf("timeouts.browse %s, timeouts.list %s", cfg.Timeouts.Browse, cfg.Timeouts.List)
+			}
+			for key, want := range map[string]string{
+				"listen": "file", "timeouts.browse": "file", "timeouts.list": "default", "db_dsn": "default",
+			} {
+				if got := cfg.sources[key]; got != want {
+					t.Errorf("source of %s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadFileUnknownKey(t *testing.T) {
+	for name, content := range map[string]string{
+		"vestra.yaml": "listen: :9000\nlisten_addr: :9001\n",
+		"vestra.toml": "listen = \":9000\"\n[timeouts]\nbrowze = \"1s\"\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := Load([]string{"-config", writeConfig(t, name, content)})
+			if err == nil {
+				t.Fatal("Load accepted an unknown key")
+			}
+			if !strings.Contains(err.Error(), name) {
+				t.Errorf("error %q does not name the file", err)
+			}
+		})
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeConfig(t, "vestra.toml", "listen = \":9000\"\nupload_concurrency = 2\n")
+	t.Setenv("VESTRA_UPLOAD_CONCURRENCY", "3")
+	t.Setenv("VESTRA_LISTEN", ":9001")
+	cfg, _, err := Load([]string{"-config", path, "-listen", ":9002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != ":9002" || cfg.UploadConcurrency != 3 {
+		t.Errorf("listen %q, upload_concurrency %d; want the flag and the env var to win", cfg.Listen, cfg.UploadConcurrency)
+	}
+}
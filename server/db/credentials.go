@@ -0,0 +1,61 @@
+package db
+
+import (
+	"github.com/PandhuWibowo/oss-portable/secrets"
+)
+
+// ResealCredentials makes every stored credential sealed with the keyring's
+// primary key. Plain-text rows (written before encryption was enabled) are
+// encrypted and rows sealed with an older key in the keyring are re-sealed,
+// so the same pass serves as the one-time migration and as key rotation.
+// All rows are updated in a single transaction. It returns the number of
+// rows rewritten.
+func ResealCredentials(kr *secrets.Keyring) (int, error) {
+	if !kr.Enabled() {
+		return 0, nil
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	type row struct {
+		id          int64
+		credentials string
+	}
+	rows, err := tx.Query("SELECT id, credentials FROM connections")
+	if err != nil {
+		return 0, err
+	}
+	var pending []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.credentials); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if r.credentials != "" && secrets.SealedWith(r.credentials) != kr.KeyID() {
+			pending = append(pending, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range pending {
+		plain, err := kr.Decrypt(r.credentials)
+		if err != nil {
+			return 0, err
+		}
+		sealed, err := kr.Encrypt(plain)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(Rebind("UPDATE connections SET credentials = ? WHERE id = ?"), sealed, r.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(pending), tx.Commit()
+}
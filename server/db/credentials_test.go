@@ -0,0 +1,78 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PandhuWibowo/oss-portable/secrets"
+)
+
+func TestResealCredentials(t *testing.T) {
+	forEachDialect(t, func(t *testing.T) {
+		if _, err := Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		oldKr, err := secrets.NewKeyring(bytes.Repeat([]byte{1}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		kr, err := secrets.NewKeyring(bytes.Repeat([]byte{2}, 32), bytes.Repeat([]byte{1}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		oldSealed, err := oldKr.Encrypt(`{"key":"old"}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current, err := kr.Encrypt(`{"key":"current"}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[int64]string{}
+		for stored, plain := range map[string]string{
+			`{"key":"plain"}`: `{"key":"plain"}`,
+			oldSealed:         `{"key":"old"}`,
+			current:           `{"key":"current"}`,
+			"":                "",
+		} {
+			id, err := InsertID(
+				"INSERT INTO connections (provider, name, bucket, credentials, created_at) VALUES (?, ?, ?, ?, ?)",
+				"aws", "c", "bucket", stored, "2024-01-01T00:00:00Z",
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want[id] = plain
+		}
+
+		// The plain-text and old-key rows are rewritten; the current and
+		// empty ones are left alone.
+		if n, err := ResealCredentials(kr); err != nil || n != 2 {
+			t.Fatalf("ResealCredentials = %d, %v; want 2, nil", n, err)
+		}
+		for id, plain := range want {
+			var stored string
+			if err := QueryRow("SELECT credentials FROM connections WHERE id = ?", id).Scan(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if plain != "" && secrets.SealedWith(stored) != kr.KeyID() {
+				t.Errorf("row %d is sealed with %q, want %s", id, secrets.SealedWith(stored), kr.KeyID())
+			}
+			if got, err := kr.Decrypt(stored); err != nil || got != plain {
+				t.Errorf("row %d opens to %q, %v; want %q", id, got, err, plain)
+			}
+		}
+		if n, err := ResealCredentials(kr); err != nil || n != 0 {
+			t.Errorf("second ResealCredentials = %d, %v; want 0, nil", n, err)
+		}
+
+		// A row no key in the keyring can open fails the whole pass.
+		if n, err := ResealCredentials(oldKr); err == nil {
+			t.Errorf("ResealCredentials with a missing key = %d, nil; want an error", n)
+		}
+		disabled, _ := secrets.NewKeyring()
+		if n, err := ResealCredentials(disabled); err != nil || n != 0 {
+			t.Errorf("ResealCredentials without a key = %d, %v; want 0, nil", n, err)
+		}
+	})
+}
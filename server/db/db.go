@@ -1,73 +1,160 @@
+// Package db owns the metadata store: SQLite by default, or PostgreSQL when
+// the DSN is a postgres:// URL so several replicas can share one database.
+// Queries are written with ? placeholders and rebound for the active dialect.
 package db
 
 import (
 	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "modernc.org/sqlite"
 )
 
 var DB *sql.DB
 
-func Init() error {
+// Dialect is the SQL dialect of DB: "sqlite" or "postgres".
+var Dialect = "sqlite"
+
+// Open connects to the database without touching the schema. postgres://
+// and postgresql:// URLs select PostgreSQL and anything else is handed to
+// the SQLite driver.
+func Open(dsn string) error {
+	driver := "sqlite"
+	Dialect = "sqlite"
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		driver = "pgx"
+		Dialect = "postgres"
+	}
 	var err error
-	DB, err = sql.Open("sqlite", "file:data.db?_foreign_keys=1")
+	DB, err = sql.Open(driver, dsn)
 	if err != nil {
 		return err
 	}
-	return createTables()
+	return DB.Ping()
 }
 
-func createTables() error {
-	_, err := DB.Exec(`
-		CREATE TABLE IF NOT EXISTS gcp_connections (
-			id          INTEGER PRIMARY KEY AUTOINCREMENT,
-			name        TEXT NOT NULL,
-			bucket      TEXT NOT NULL,
-			credentials TEXT NOT NULL,
-			created_at  DATETIME NOT NULL
-		)`)
-	if err != nil {
+// Init opens the database and applies any pending migrations.
+func Init(dsn string) error {
+	if err := Open(dsn); err != nil {
 		return err
 	}
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS aws_connections (
-			id          INTEGER PRIMARY KEY AUTOINCREMENT,
-			name        TEXT NOT NULL,
-			bucket      TEXT NOT NULL,
-			credentials TEXT NOT NULL,
-			created_at  DATETIME NOT NULL
-		)`)
-	if err != nil {
-		return err
+	_, err := Migrate()
+	return err
+}
+
+// Close closes the database, if it was opened.
+func Close() error {
+	if DB == nil {
+		return nil
 	}
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS huawei_connections (
-			id          INTEGER PRIMARY KEY AUTOINCREMENT,
-			name        TEXT NOT NULL,
-			bucket      TEXT NOT NULL,
-			credentials TEXT NOT NULL,
-			created_at  DATETIME NOT NULL
-		)`)
-	if err != nil {
-		return err
+	return DB.Close()
+}
+
+// ── dialect helpers ───────────────────────────────────────────────
+
+// Rebind rewrites ? placeholders to $1, $2, … for PostgreSQL.
+func Rebind(query string) string {
+	if Dialect != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			inString = !inString
+		case c == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func Exec(query string, args ...any) (sql.Result, error) {
+	return DB.Exec(Rebind(query), args...)
+}
+
+func Query(query string, args ...any) (*sql.Rows, error) {
+	return DB.Query(Rebind(query), args...)
+}
+
+func QueryRow(query string, args ...any) *sql.Row {
+	return DB.QueryRow(Rebind(query), args...)
+}
+
+// InsertID runs an INSERT and returns the generated id column. PostgreSQL
+// has no LastInsertId, so the statement gets a RETURNING clause instead.
+func InsertID(query string, args ...any) (int64, error) {
+	return insertID(DB, query, args...)
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func insertID(e execer, query string, args ...any) (int64, error) {
+	if Dialect == "postgres" {
+		var id int64
+		err := e.QueryRow(Rebind(query)+" RETURNING id", args...).Scan(&id)
+		return id, err
 	}
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS alibaba_connections (
-			id          INTEGER PRIMARY KEY AUTOINCREMENT,
-			name        TEXT NOT NULL,
-			bucket      TEXT NOT NULL,
-			credentials TEXT NOT NULL,
-			created_at  DATETIME NOT NULL
-		)`)
+	res, err := e.Exec(query, args...)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS azure_connections (
-			id          INTEGER PRIMARY KEY AUTOINCREMENT,
-			name        TEXT NOT NULL,
-			bucket      TEXT NOT NULL,
-			credentials TEXT NOT NULL,
-			created_at  DATETIME NOT NULL
-		)`)
-	return err
+	return res.LastInsertId()
+}
+
+// ResolveConnectionID translates a connection ID from before the merge of
+// the per-provider tables into its current one. IDs that were kept, and
+// connections created since, come back unchanged.
+func ResolveConnectionID(provider string, id int64) (int64, error) {
+	var newID int64
+	err := QueryRow(
+		"SELECT new_id FROM connection_id_remap WHERE provider = ? AND old_id = ?", provider, id,
+	).Scan(&newID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return id, nil
+	}
+	return newID, err
+}
+
+// LimitOffset returns a LIMIT/OFFSET clause and its arguments. limit <= 0
+// means no limit.
+func LimitOffset(limit, offset int) (string, []any) {
+	switch {
+	case limit > 0:
+		return " LIMIT ? OFFSET ?", []any{limit, offset}
+	case offset > 0 && Dialect == "sqlite":
+		// SQLite only accepts OFFSET after a LIMIT; -1 means unbounded.
+		return " LIMIT -1 OFFSET ?", []any{offset}
+	case offset > 0:
+		return " OFFSET ?", []any{offset}
+	}
+	return "", nil
+}
+
+// autoIDColumn is the column definition of an auto-incrementing primary key.
+func autoIDColumn() string {
+	if Dialect == "postgres" {
+		return "id BIGSERIAL PRIMARY KEY"
+	}
+	return "id INTEGER PRIMARY KEY AUTOINCREMENT"
+}
+
+// timestampType is the column type used for RFC 3339 timestamps.
+func timestampType() string {
+	if Dialect == "postgres" {
+		return "TIMESTAMPTZ"
+	}
+	return "DATETIME"
 }
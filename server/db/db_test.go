@@ -0,0 +1,237 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// envTestPostgres names a PostgreSQL DSN to run the tests against as well.
+// Each run works in a schema of its own, dropped afterwards.
+const envTestPostgres = "VESTRA_TEST_POSTGRES_DSN"
+
+// forEachDialect runs fn on a fresh, unmigrated SQLite database and, when
+// envTestPostgres is set, on a fresh PostgreSQL schema.
+func forEachDialect(t *testing.T, fn func(t *testing.T)) {
+	t.Run("sqlite", func(t *testing.T) {
+		if err := Open("file:" + filepath.Join(t.TempDir(), "test.db") + "?_foreign_keys=1"); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { Close() })
+		fn(t)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(envTestPostgres)
+		if dsn == "" {
+			t.Skip(envTestPostgres + " is not set")
+		}
+		suffix := make([]byte, 6)
+		_, _ = rand.Read(suffix)
+		schema := "vestra_test_" + hex.EncodeToString(suffix)
+		if err := Open(dsn); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DB.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		Close()
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		if err := Open(dsn + sep + "search_path=" + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			DB.Exec("DROP SCHEMA " + schema + " CASCADE")
+			Close()
+		})
+		fn(t)
+	})
+}
+
+func TestRebind(t *testing.T) {
+	defer func(d string) { Dialect = d }(Dialect)
+	query := "SELECT * FROM t WHERE a = ? AND b = '?' AND c IN (?, ?)"
+
+	Dialect = "sqlite"
+	if got := Rebind(query); got != query {
+		t.Errorf("sqlite: Rebind changed the query to %q", got)
+	}
+	Dialect = "postgres"
+	want := "SELECT * FROM t WHERE a = $1 AND b = '?' AND c IN ($2, $3)"
+	if got := Rebind(query); got != want {
+		t.Errorf("postgres: Rebind = %q, want %q", got, want)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	forEachDialect(t, func(t *testing.T) {
+		n, err := Migrate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(migrations) {
+			t.Errorf("Migrate applied %d migrations, want %d", n, len(migrations))
+		}
+		states, err := Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range states {
+			if !s.Applied || s.AppliedAt.IsZero() {
+				t.Errorf("migration %03d %s is not recorded as applied", s.Version, s.Name)
+			}
+		}
+		if n, err := Migrate(); err != nil || n != 0 {
+			t.Errorf("second Migrate = %d, %v; want 0, nil", n, err)
+		}
+		if pending, err := DryRun(); err != nil || len(pending) != 0 {
+			t.Errorf("DryRun = %d pending, %v; want none", len(pending), err)
+		}
+	})
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	forEachDialect(t, func(t *testing.T) {
+		// Replicas starting together each see every migration pending.
+		const replicas = 4
+		var (
+			wg    sync.WaitGroup
+			total atomic.Int64
+			errs  = make(chan error, replicas)
+		)
+		for range replicas {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n, err := Migrate()
+				total.Add(int64(n))
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		if n := total.Load(); n != int64(len(migrations)) {
+			t.Errorf("the replicas applied %d migrations between them, want %d", n, len(migrations))
+		}
+		var rows int
+		if err := QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+		if rows != len(migrations) {
+			t.Errorf("schema_migrations has %d rows, want %d", rows, len(migrations))
+		}
+	})
+}
+
+func TestMergeConnectionTables(t *testing.T) {
+	forEachDialect(t, func(t *testing.T) {
+		if err := ensureMigrationsTable(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := apply(migrations[0]); err != nil {
+			t.Fatal(err)
+		}
+		// Both tables start at ID 1; aws also has ID 2.
+		for _, row := range []struct{ table, name string }{
+			{"gcp_connections", "gcp-one"},
+			{"aws_connections", "aws-one"},
+			{"aws_connections", "aws-two"},
+		} {
+			if _, err := Exec(
+				"INSERT INTO "+row.table+" (name, bucket, credentials, created_at) VALUES (?, ?, ?, ?)",
+				row.name, "bucket", "{}", "2024-01-01T00:00:00Z",
+			); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := Migrate(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range []struct {
+			provider string
+			oldID    int64
+			name     string
+		}{
+			{"gcp", 1, "gcp-one"},
+			{"aws", 1, "aws-one"},
+			{"aws", 2, "aws-two"},
+		} {
+			id, err := ResolveConnectionID(c.provider, c.oldID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var provider, name string
+			if err := QueryRow("SELECT provider, name FROM connections WHERE id = ?", id).Scan(&provider, &name); err != nil {
+				t.Fatalf("%s %d resolved to %d: %v", c.provider, c.oldID, id, err)
+			}
+			if provider != c.provider || name != c.name {
+				t.Errorf("%s %d resolved to %s %q, want %q", c.provider, c.oldID, provider, name, c.name)
+			}
+		}
+
+		// New rows must not reuse an ID the merge inserted explicitly.
+		id, err := InsertID(
+			"INSERT INTO connections (provider, name, bucket, credentials, created_at) VALUES (?, ?, ?, ?, ?)",
+			"azure", "new", "bucket", "{}", "2024-01-02T00:00:00Z",
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		if err := QueryRow("SELECT COUNT(*) FROM connections").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if id <= 2 || count != 4 {
+			t.Errorf("InsertID = %d with %d rows; want a fresh ID and 4 rows", id, count)
+		}
+		if got, err := ResolveConnectionID("azure", id); err != nil || got != id {
+			t.Errorf("ResolveConnectionID(azure, %d) = %d, %v; want it unchanged", id, got, err)
+		}
+	})
+}
+
+func TestLimitOffset(t *testing.T) {
+	forEachDialect(t, func(t *testing.T) {
+		if _, err := Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			if _, err := InsertID(
+				"INSERT INTO connections (provider, name, bucket, credentials, created_at) VALUES (?, ?, ?, ?, ?)",
+				"gcp", "c", "bucket", "{}", "2024-01-01T00:00:00Z",
+			); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, c := range []struct{ limit, offset, want int }{
+			{0, 0, 5}, {2, 0, 2}, {2, 4, 1}, {0, 3, 2},
+		} {
+			clause, args := LimitOffset(c.limit, c.offset)
+			rows, err := Query("SELECT id FROM connections ORDER BY id"+clause, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for rows.Next() {
+				n++
+			}
+			rows.Close()
+			if n != c.want {
+				t.Errorf("LimitOffset(%d, %d) returned %d rows, want %d", c.limit, c.offset, n, c.want)
+			}
+		}
+	})
+}
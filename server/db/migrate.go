@@ -0,0 +1,434 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Migration is one numbered, forward-only schema change. Migrations run in
+// Version order, each in its own transaction together with the
+// schema_migrations row that records it, so a failed migration leaves the
+// database exactly as it was.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+// migrations is the ordered list of schema changes. Append new entries with
+// the next version number; never edit or reorder an applied migration.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create provider connection tables",
+		// IF NOT EXISTS keeps this a no-op on databases created before
+		// migrations were tracked.
+		Up: func(tx *sql.Tx) error {
+			for _, legacy := range legacyConnectionTables {
+				if _, err := tx.Exec(connectionTableDDL(legacy.table)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "merge provider tables into connections",
+		Up:      mergeConnectionTables,
+	},
+	{
+		Version: 3,
+		Name:    "create resumable upload sessions",
+		Up: func(tx *sql.Tx) error {
+			return execAll(`
+				CREATE TABLE uploads (
+					id            TEXT PRIMARY KEY,
+					provider      TEXT NOT NULL,
+					connection_id BIGINT NOT NULL,
+					object        TEXT NOT NULL,
+					content_type  TEXT NOT NULL,
+					size          BIGINT NOT NULL,
+					part_size     BIGINT NOT NULL,
+					upload_id     TEXT NOT NULL,
+					sent          BIGINT NOT NULL,
+					parts         TEXT NOT NULL,
+					completed     BOOLEAN NOT NULL,
+					created_at    `+timestampType()+` NOT NULL,
+					expires_at    `+timestampType()+` NOT NULL
+				)`,
+				"CREATE INDEX idx_uploads_expires_at ON uploads (expires_at)",
+			)(tx)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create folder moves",
+		Up: func(tx *sql.Tx) error {
+			return execAll(`
+				CREATE TABLE moves (
+					id            TEXT PRIMARY KEY,
+					provider      TEXT NOT NULL,
+					connection_id BIGINT NOT NULL,
+					source        TEXT NOT NULL,
+					destination   TEXT NOT NULL,
+					overwrite     BOOLEAN NOT NULL,
+					state         TEXT NOT NULL,
+					error         TEXT NOT NULL,
+					created_at    `+timestampType()+` NOT NULL,
+					updated_at    `+timestampType()+` NOT NULL
+				)`,
+				"CREATE INDEX idx_moves_state ON moves (state)",
+			)(tx)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create connection id remap",
+		// Migration 2 creates the table on databases it has yet to merge;
+		// this one adds it, empty, where the merge ran before it kept one.
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(connectionRemapDDL)
+			return err
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create archive links",
+		Up: func(tx *sql.Tx) error {
+			return execAll(`
+				CREATE TABLE archive_links (
+					id            TEXT PRIMARY KEY,
+					provider      TEXT NOT NULL,
+					connection_id BIGINT NOT NULL,
+					selection     TEXT NOT NULL,
+					expires_at    `+timestampType()+` NOT NULL
+				)`,
+				"CREATE INDEX idx_archive_links_expires ON archive_links (expires_at)",
+			)(tx)
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add folder move leases",
+		// A replica holds the moves it runs until lease_expires; NULL means
+		// nobody does.
+		Up: func(tx *sql.Tx) error {
+			return execAll(
+				"ALTER TABLE moves ADD COLUMN owner TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE moves ADD COLUMN lease_expires "+timestampType(),
+			)(tx)
+		},
+	},
+}
+
+func connectionTableDDL(table string) string {
+	return `
+		CREATE TABLE IF NOT EXISTS ` + table + ` (
+			` + autoIDColumn() + `,
+			name        TEXT NOT NULL,
+			bucket      TEXT NOT NULL,
+			credentials TEXT NOT NULL,
+			created_at  ` + timestampType() + ` NOT NULL
+		)`
+}
+
+// legacyConnectionTables are the per-provider tables replaced by migration 2,
+// in the order their rows are merged.
+var legacyConnectionTables = []struct{ table, provider string }{
+	{"gcp_connections", "gcp"},
+	{"aws_connections", "aws"},
+	{"huawei_connections", "huawei"},
+	{"alibaba_connections", "alibaba"},
+	{"azure_connections", "azure"},
+}
+
+// connectionRemapDDL holds the connections that migration 2 had to give a
+// new ID, keyed by provider and their ID in the per-provider table. See
+// ResolveConnectionID.
+const connectionRemapDDL = `
+	CREATE TABLE IF NOT EXISTS connection_id_remap (
+		provider TEXT NOT NULL,
+		old_id   BIGINT NOT NULL,
+		new_id   BIGINT NOT NULL,
+		PRIMARY KEY (provider, old_id)
+	)`
+
+// mergeConnectionTables copies every per-provider row into the single
+// connections table and drops the old tables. IDs and created_at are kept;
+// the per-provider tables had independent ID sequences, so when two providers
+// used the same ID the first one (in legacyConnectionTables order) keeps it
+// and later ones get a fresh ID after all preserved rows are in place. Every
+// such move is recorded in connection_id_remap, so requests that still use
+// the old ID reach the same connection rather than another provider's.
+func mergeConnectionTables(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE connections (
+			` + autoIDColumn() + `,
+			provider    TEXT NOT NULL,
+			name        TEXT NOT NULL,
+			bucket      TEXT NOT NULL,
+			credentials TEXT NOT NULL,
+			created_at  ` + timestampType() + ` NOT NULL
+		)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE INDEX idx_connections_provider ON connections (provider)"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(connectionRemapDDL); err != nil {
+		return err
+	}
+
+	type row struct {
+		provider, name, bucket, credentials, createdAt string
+		id                                             int64
+	}
+	taken := map[int64]bool{}
+	var collided []row
+	for _, legacy := range legacyConnectionTables {
+		rows, err := tx.Query("SELECT id, name, bucket, credentials, created_at FROM " + legacy.table + " ORDER BY id")
+		if err != nil {
+			return err
+		}
+		var all []row
+		for rows.Next() {
+			r := row{provider: legacy.provider}
+			if err := rows.Scan(&r.id, &r.name, &r.bucket, &r.credentials, &r.createdAt); err != nil {
+				rows.Close()
+				return err
+			}
+			all = append(all, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, r := range all {
+			if taken[r.id] {
+				collided = append(collided, r)
+				continue
+			}
+			taken[r.id] = true
+			if _, err := tx.Exec(Rebind(
+				"INSERT INTO connections (id, provider, name, bucket, credentials, created_at) VALUES (?, ?, ?, ?, ?, ?)"),
+				r.id, r.provider, r.name, r.bucket, r.credentials, r.createdAt,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	if Dialect == "postgres" && len(taken) > 0 {
+		// Explicit ids do not advance a BIGSERIAL sequence.
+		if _, err := tx.Exec(
+			"SELECT setval(pg_get_serial_sequence('connections', 'id'), (SELECT MAX(id) FROM connections))",
+		); err != nil {
+			return err
+		}
+	}
+	for _, r := range collided {
+		newID, err := insertID(tx,
+			"INSERT INTO connections (provider, name, bucket, credentials, created_at) VALUES (?, ?, ?, ?, ?)",
+			r.provider, r.name, r.bucket, r.credentials, r.createdAt,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(Rebind(
+			"INSERT INTO connection_id_remap (provider, old_id, new_id) VALUES (?, ?, ?)"),
+			r.provider, r.id, newID,
+		); err != nil {
+			return err
+		}
+		log.Printf("migration: %s connection %q moved from id %d to %d", r.provider, r.name, r.id, newID)
+	}
+
+	for _, legacy := range legacyConnectionTables {
+		if _, err := tx.Exec("DROP TABLE " + legacy.table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execAll returns a migration body that runs each statement in order.
+func execAll(stmts ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(Rebind(stmt)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// MigrationState describes a migration and whether it has been applied.
+type MigrationState struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+func ensureMigrationsTable() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at ` + timestampType() + ` NOT NULL
+		)`)
+	return err
+}
+
+// Status reports every known migration and whether it has been applied.
+func Status() ([]MigrationState, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := DB.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at string
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version], _ = time.Parse(time.RFC3339, at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		states = append(states, MigrationState{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: at})
+	}
+	return states, nil
+}
+
+// Pending returns the migrations that have not been applied yet.
+func Pending() ([]Migration, error) {
+	states, err := Status()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for i, s := range states {
+		if !s.Applied {
+			pending = append(pending, migrations[i])
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations and returns how many ran. Several
+// processes may migrate the same database at once, as replicas sharing one
+// PostgreSQL do when they start together: each migration is applied by
+// whichever gets to it first, and skipped by the others.
+func Migrate() (int, error) {
+	pending, err := Pending()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range pending {
+		ran, err := apply(m)
+		if err != nil {
+			return n, err
+		}
+		if ran {
+			n++
+			log.Printf("applied migration %03d %s", m.Version, m.Name)
+		}
+	}
+	return n, nil
+}
+
+// DryRun runs every pending migration inside a transaction and rolls it
+// back, so a broken migration is caught before an upgrade touches the data.
+// Each migration runs on top of the previous one only if that one succeeded;
+// the first failure stops the dry run.
+func DryRun() ([]Migration, error) {
+	pending, err := Pending()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for i, m := range pending {
+		if err := m.Up(tx); err != nil {
+			return pending[:i], fmt.Errorf("migration %03d %s: %w", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// apply runs m, unless another process applied it since Pending looked, and
+// reports whether it ran.
+func apply(m Migration) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := lockMigrations(tx); err != nil {
+		return false, fmt.Errorf("lock schema_migrations: %w", err)
+	}
+	var applied int
+	if err := tx.QueryRow(Rebind(
+		"SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), m.Version,
+	).Scan(&applied); err != nil {
+		return false, err
+	}
+	if applied > 0 {
+		return false, nil
+	}
+	if err := m.Up(tx); err != nil {
+		return false, fmt.Errorf("migration %03d %s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.Exec(Rebind(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+		m.Version, m.Name, time.Now().UTC().Format(time.RFC3339),
+	); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// migrationLock is the PostgreSQL advisory lock key taken while migrating.
+const migrationLock = 0x76657374 // "vest"
+
+// migrationLockTimeout is how long a process waits for another one's
+// migration to finish on SQLite, in milliseconds.
+const migrationLockTimeout = 60000
+
+// lockMigrations makes tx the only transaction applying migrations until it
+// ends. PostgreSQL takes an advisory lock, released with the transaction.
+// SQLite takes the database's write lock with a statement that changes
+// nothing, waiting for a process that holds it.
+func lockMigrations(tx *sql.Tx) error {
+	if Dialect == "postgres" {
+		_, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLock)
+		return err
+	}
+	if _, err := tx.Exec("PRAGMA busy_timeout = " + strconv.Itoa(migrationLockTimeout)); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM schema_migrations WHERE version < 0")
+	return err
+}
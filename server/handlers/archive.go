@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appdb "github.com/PandhuWibowo/oss-portable/db"
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// Folders and multi-selections download as one ZIP or tar.gz, written to
+// the response while it is read from the provider: nothing is buffered on
+// disk. ArchiveURL checks the selection against archive_max_size, saves it
+// in archive_links and returns a short signed link to StreamArchive, which
+// builds the archive. The selection stays out of the link so that thousands
+// of keys don't overflow the URL limits of proxies. Objects that fail are
+// listed in an ARCHIVE-ERRORS.txt entry at the end instead of aborting the
+// download.
+
+// archiveErrorsName is the entry listing the objects that could not be added.
+const archiveErrorsName = "ARCHIVE-ERRORS.txt"
+
+// archiveSelection is what to put in an archive: everything under Prefix, or
+// the Keys, where a key ending in "/" stands for everything under it. Entry
+// names are the keys relative to Base.
+type archiveSelection struct {
+	Prefix string   `json:"prefix,omitempty"`
+	Keys   []string `json:"keys,omitempty"`
+	Base   string   `json:"base"`
+	Format string   `json:"format"` // "zip" or "tar.gz"
+}
+
+func (s *archiveSelection) validate() error {
+	if s.Format == "" {
+		s.Format = "zip"
+	}
+	if s.Format != "zip" && s.Format != "tar.gz" {
+		return errors.New(`format must be "zip" or "tar.gz"`)
+	}
+	if s.Prefix == "" && len(s.Keys) == 0 {
+		// An empty prefix is the whole bucket; ask for it explicitly.
+		return errors.New("prefix or keys is required")
+	}
+	if s.Prefix != "" && len(s.Keys) > 0 {
+		return errors.New("send either prefix or keys, not both")
+	}
+	if s.Prefix != "" && s.Base == "" {
+		// A folder's archive holds the folder itself.
+		s.Base = parentPrefix(s.Prefix)
+	}
+	return nil
+}
+
+// parentPrefix returns the folder holding key, with a trailing slash, or "".
+func parentPrefix(key string) string {
+	i := strings.LastIndex(strings.TrimSuffix(key, "/"), "/")
+	return key[:i+1]
+}
+
+// entryName turns a key into a safe relative path inside the archive.
+func (s *archiveSelection) entryName(key string) string {
+	name := strings.TrimPrefix(key, s.Base)
+	// Keys may contain "..", which must not escape the extraction directory.
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// archiveName is the file name offered for the download.
+func (s *archiveSelection) archiveName() string {
+	name := "download"
+	switch {
+	case s.Prefix != "":
+		name = path.Base(strings.TrimSuffix(s.Prefix, "/"))
+	case s.Base != "":
+		name = path.Base(strings.TrimSuffix(s.Base, "/"))
+	}
+	return name + "." + s.Format
+}
+
+// archiveLinkTTL is how long an archive link stays valid.
+const archiveLinkTTL = 15 * time.Minute
+
+// saveArchiveLink stores the selection for a link and returns its ID.
+// Expired links are cleared out on the way.
+func saveArchiveLink(provider string, connectionID int64, sel *archiveSelection, expires time.Time) (string, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := appdb.Exec("DELETE FROM archive_links WHERE expires_at < ?", now); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(sel)
+	if err != nil {
+		return "", err
+	}
+	id := newJobID()
+	_, err = appdb.Exec(
+		"INSERT INTO archive_links (id, provider, connection_id, selection, expires_at) VALUES (?, ?, ?, ?, ?)",
+		id, provider, connectionID, string(data), expires.UTC().Format(time.RFC3339),
+	)
+	return id, err
+}
+
+// errArchiveLinkNotFound is returned for links that expired and were
+// cleared out, or never existed.
+var errArchiveLinkNotFound = errors.New("archive link not found or expired")
+
+// loadArchiveLink returns the connection and selection saved for a link.
+func loadArchiveLink(provider, id string) (int64, *archiveSelection, error) {
+	var connectionID int64
+	var data string
+	err := appdb.QueryRow(
+		"SELECT connection_id, selection FROM archive_links WHERE id = ? AND provider = ?", id, provider,
+	).Scan(&connectionID, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, errArchiveLinkNotFound
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	var sel archiveSelection
+	if err := json.Unmarshal([]byte(data), &sel); err != nil {
+		return 0, nil, err
+	}
+	return connectionID, &sel, nil
+}
+
+func archiveSignature(provider, id string, expires int64) string {
+	mac := hmac.New(sha256.New, signingKey())
+	fmt.Fprintf(mac, "archive\n%s\n%s\n%d", provider, id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// archiveEntry is one object to add. Size is -1 until the object is
+// stat'ed.
+type archiveEntry struct {
+	Key     string
+	Size    int64
+	Updated time.Time
+}
+
+// collect lists the objects of the selection. Walked prefixes give their
+// sizes; with stat, selected keys are stat'ed too (a few at a time), and
+// missing ones reported through failed.
+func (s *archiveSelection) collect(ctx context.Context, backend storage.Backend, stat bool, failed func(key string, err error)) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	walk := func(prefix string) error {
+		return backend.Walk(ctx, prefix, func(o storage.Object) error {
+			if !strings.HasSuffix(o.Name, "/") { // skip folder markers
+				entries = append(entries, archiveEntry{Key: o.Name, Size: o.Size, Updated: o.Updated})
+			}
+			return nil
+		})
+	}
+	if s.Prefix != "" {
+		return entries, walk(s.Prefix)
+	}
+
+	var files []int
+	for _, k := range s.Keys {
+		if strings.HasSuffix(k, "/") {
+			if err := walk(k); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		files = append(files, len(entries))
+		entries = append(entries, archiveEntry{Key: k, Size: -1})
+	}
+	if !stat {
+		return entries, nil
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		sem  = make(chan struct{}, 8)
+		gone = map[int]bool{}
+	)
+	for _, i := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			md, err := backend.Stat(ctx, entries[i].Key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed(entries[i].Key, err)
+				gone[i] = true
+				return
+			}
+			entries[i].Size = md.Size
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	kept := entries[:0]
+	for i, e := range entries {
+		if !gone[i] {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
+
+// ArchiveURL handles POST /api/{provider}/bucket/archive. It lists the
+// selection, refuses it with 413 when it is larger than archive_max_size,
+// and returns a link (valid 15 minutes) that downloads the archive.
+func ArchiveURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		ConnectionID int64    `json:"connection_id"`
+		Prefix       string   `json:"prefix"`
+		Keys         []string `json:"keys"`
+		Base         string   `json:"base"`
+		Format       string   `json:"format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	sel := archiveSelection{Prefix: req.Prefix, Keys: req.Keys, Base: req.Base, Format: req.Format}
+	if err := sel.validate(); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.List)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	provider := providerFromPath(r.URL.Path)
+	var missing []string
+	entries, err := sel.collect(ctx, backend, true, func(key string, err error) {
+		missing = append(missing, key)
+	})
+	if err != nil {
+		writeStorageError(w, provider, err)
+		return
+	}
+	if len(entries) == 0 {
+		writeError(w, storage.CodeNotFound, "nothing to download")
+		return
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	if total > int64(cfg.ArchiveMaxSize) {
+		writeAPIError(w, apiError{
+			Code:    codeTooLarge,
+			Message: fmt.Sprintf("the selection is larger than the %s archive limit", cfg.ArchiveMaxSize),
+			Details: map[string]any{"size": total, "files": len(entries)},
+		})
+		return
+	}
+
+	expires := time.Now().Add(archiveLinkTTL)
+	id, err := saveArchiveLink(provider, req.ConnectionID, &sel, expires)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	v := url.Values{}
+	v.Set("id", id)
+	v.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	v.Set("sig", archiveSignature(provider, id, expires.Unix()))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"url":     "/api/" + provider + "/bucket/archive/stream?" + v.Encode(),
+		"name":    sel.archiveName(),
+		"files":   len(entries),
+		"size":    total,
+		"missing": missing,
+	})
+}
+
+// StreamArchive handles GET /api/{provider}/bucket/archive/stream, writing
+// the archive for a link issued by ArchiveURL.
+func StreamArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	provider := providerFromPath(r.URL.Path)
+	q := r.URL.Query()
+	id := q.Get("id")
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(archiveSignature(provider, id, expires))) {
+		writeError(w, storage.CodeAccessDenied, "invalid archive link signature")
+		return
+	}
+	if time.Now().Unix() > expires {
+		writeError(w, storage.CodeAccessDenied, "archive link has expired")
+		return
+	}
+	connectionID, sel, err := loadArchiveLink(provider, id)
+	if errors.Is(err, errArchiveLinkNotFound) {
+		writeError(w, storage.CodeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	backend, ok := openBackend(ctx, w, r, connectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	listCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts.List)
+	entries, err := sel.collect(listCtx, backend, false, nil)
+	cancel()
+	if err != nil {
+		writeStorageError(w, provider, err)
+		return
+	}
+
+	contentType := "application/gzip"
+	if sel.Format == "zip" {
+		contentType = "application/zip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": sel.archiveName()}))
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	var aw archiveWriter
+	if sel.Format == "zip" {
+		aw = &zipArchive{zw: zip.NewWriter(w)}
+	} else {
+		gz := gzip.NewWriter(w)
+		aw = &tarArchive{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	if err := writeArchive(ctx, backend, sel, entries, aw); err != nil {
+		// The response is under way; all that can be done is to stop.
+		log.Printf("archive of %s connection %d: %v", provider, connectionID, err)
+	}
+}
+
+// archiveWriter adds entries to a ZIP or tar.gz stream.
+type archiveWriter interface {
+	// Add writes name from r, which holds size bytes (-1 when unknown).
+	Add(name string, modified time.Time, size int64, r io.Reader) (int64, error)
+	Close() error
+}
+
+// writeArchive adds the entries one by one. An object that cannot be read
+// is left out, or cut short if it fails halfway, and reported in
+// archiveErrorsName. Only failures to write to the client end the archive.
+func writeArchive(ctx context.Context, backend storage.Backend, sel *archiveSelection, entries []archiveEntry, aw archiveWriter) error {
+	var report strings.Builder
+	failed := func(key string, err error) {
+		fmt.Fprintf(&report, "%s: %v\n", key, err)
+	}
+	var written int64
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if e.Size < 0 {
+			md, err := backend.Stat(ctx, e.Key)
+			if err != nil {
+				failed(e.Key, err)
+				continue
+			}
+			e.Size, e.Updated = md.Size, md.Updated
+		}
+		if written+e.Size > int64(cfg.ArchiveMaxSize) {
+			failed(e.Key, fmt.Errorf("left out: the archive reached the %s limit", cfg.ArchiveMaxSize))
+			continue
+		}
+		if e.Updated.IsZero() {
+			e.Updated = time.Now()
+		}
+		rc, err := backend.Open(ctx, e.Key)
+		if err != nil {
+			failed(e.Key, err)
+			continue
+		}
+		src := &archiveSource{r: rc}
+		n, err := aw.Add(sel.entryName(e.Key), e.Updated, e.Size, io.LimitReader(src, e.Size))
+		rc.Close()
+		written += n
+		if err != nil {
+			return err
+		}
+		if src.err != nil {
+			failed(e.Key, fmt.Errorf("cut short after %d of %d bytes: %w", n, e.Size, src.err))
+		} else if n < e.Size {
+			failed(e.Key, fmt.Errorf("cut short after %d of %d bytes: the object changed", n, e.Size))
+		}
+	}
+	if report.Len() > 0 {
+		msg := report.String()
+		if _, err := aw.Add(archiveErrorsName, time.Now(), int64(len(msg)), strings.NewReader(msg)); err != nil {
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+// archiveSource ends an entry at the first read error and keeps the error
+// for the report, so that errors from Add are only those of the client.
+type archiveSource struct {
+	r   io.Reader
+	err error
+}
+
+func (s *archiveSource) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, io.EOF
+	}
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+		err = io.EOF
+	}
+	return n, err
+}
+
+type zipArchive struct{ zw *zip.Writer }
+
+func (a *zipArchive) Add(name string, modified time.Time, size int64, r io.Reader) (int64, error) {
+	method := zip.Deflate
+	if compressedExt[strings.ToLower(path.Ext(name))] {
+		method = zip.Store
+	}
+	f, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(f, r)
+}
+
+func (a *zipArchive) Close() error { return a.zw.Close() }
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+// Add pads an entry that ends early with zeros, since a tar header fixes
+// the size before the data.
+func (a *tarArchive) Add(name string, modified time.Time, size int64, r io.Reader) (int64, error) {
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modified,
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(a.tw, r)
+	if err == nil && n < size {
+		_, err = io.CopyN(a.tw, zeros{}, size-n)
+	}
+	return n, err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// compressedExt lists formats that are stored in ZIPs as they are, since
+// deflating them again costs time and saves nothing.
+var compressedExt = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true, ".heic": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".flac": true, ".opus": true,
+	".mp4": true, ".m4v": true, ".mov": true, ".webm": true, ".mkv": true, ".avi": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true, ".jar": true, ".apk": true,
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+func TestArchiveLargeSelection(t *testing.T) {
+	// Enough long keys that a link carrying them would run far past the
+	// 8 KiB request line limit of common proxies.
+	objects := map[string]string{}
+	var keys []string
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("reports/2024/quarterly-summary-for-region-%04d.csv", i)
+		objects[key] = fmt.Sprint(i)
+		keys = append(keys, key)
+	}
+	id := memoryConnection(t, objects)
+
+	var link struct {
+		URL   string `json:"url"`
+		Name  string `json:"name"`
+		Files int    `json:"files"`
+	}
+	w := call(t, ArchiveURL, "/api/memory/bucket/archive", map[string]any{
+		"connection_id": id, "keys": keys, "base": "reports/",
+	})
+	decode(t, w, http.StatusOK, &link)
+	if link.Files != len(keys) || link.Name != "reports.zip" {
+		t.Errorf("archive link for %d files named %q, want %d files in reports.zip", link.Files, link.Name, len(keys))
+	}
+	if len(link.URL) > 512 {
+		t.Errorf("archive link is %d bytes long", len(link.URL))
+	}
+
+	w = httptest.NewRecorder()
+	StreamArchive(w, httptest.NewRequest(http.MethodGet, link.URL, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("stream: status %d, content type %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if len(names) != len(keys) || names[0] != "2024/quarterly-summary-for-region-0000.csv" {
+		t.Fatalf("archive holds %d entries starting with %v, want %d", len(names), names[:min(len(names), 1)], len(keys))
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if want := objects["reports/"+zr.File[0].Name]; string(data) != want {
+		t.Errorf("entry %s holds %q, want %q", zr.File[0].Name, data, want)
+	}
+}
+
+func TestArchiveLinkChecks(t *testing.T) {
+	id := memoryConnection(t, map[string]string{"a.txt": "a"})
+	var link struct {
+		URL string `json:"url"`
+	}
+	decode(t, call(t, ArchiveURL, "/api/memory/bucket/archive", map[string]any{
+		"connection_id": id, "keys": []string{"a.txt"},
+	}), http.StatusOK, &link)
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := func(path string, change func(q url.Values)) *httptest.ResponseRecorder {
+		q := u.Query()
+		change(q)
+		w := httptest.NewRecorder()
+		StreamArchive(w, httptest.NewRequest(http.MethodGet, path+"?"+q.Encode(), nil))
+		return w
+	}
+	for _, c := range []struct {
+		name   string
+		path   string
+		change func(q url.Values)
+		status int
+		code   string
+	}{
+		{"other provider", strings.Replace(u.Path, "/memory/", "/local/", 1), func(url.Values) {}, http.StatusForbidden, storage.CodeAccessDenied},
+		{"later expiry", u.Path, func(q url.Values) { q.Set("expires", q.Get("expires")+"0") }, http.StatusForbidden, storage.CodeAccessDenied},
+		{"other id", u.Path, func(q url.Values) { q.Set("id", q.Get("id")+"0") }, http.StatusForbidden, storage.CodeAccessDenied},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			expectError(t, stream(c.path, c.change), c.status, c.code)
+		})
+	}
+
+	// A correctly signed link whose selection was cleared out.
+	q := u.Query()
+	q.Set("id", "gone")
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	q.Set("sig", archiveSignature("memory", "gone", expires))
+	w := httptest.NewRecorder()
+	StreamArchive(w, httptest.NewRequest(http.MethodGet, u.Path+"?"+q.Encode(), nil))
+	expectError(t, w, http.StatusNotFound, storage.CodeNotFound)
+}
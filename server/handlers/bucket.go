@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// openBackend loads a saved connection for the provider named in the path and
+// builds its storage backend. On failure it writes the error response and
+// returns ok=false.
+func openBackend(ctx context.Context, w http.ResponseWriter, r *http.Request, connectionID int64) (backend storage.Backend, ok bool) {
+	provider := providerFromPath(r.URL.Path)
+	bucket, credentials, err := loadConnection(provider, connectionID)
+	if err != nil {
+		writeConnError(w, err)
+		return nil, false
+	}
+	backend, err = storage.Open(ctx, provider, bucket, credentials)
+	if err != nil {
+		writeStorageError(w, provider, err)
+		return nil, false
+	}
+	return backend, true
+}
+
+// ── bucket operations ─────────────────────────────────────────────
+
+// BrowseBucket lists entries (files + virtual folders) at a given prefix with pagination.
+func BrowseBucket(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConnectionID int64  `json:"connection_id"`
+		Prefix       string `json:"prefix"`
+		PageToken    string `json:"page_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Browse)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	page, err := backend.List(ctx, req.Prefix, req.PageToken, 200)
+	if err != nil {
+		writeStorageError(w, providerFromPath(r.URL.Path), err)
+		return
+	}
+	resp := map[string]any{
+		"prefix":          req.Prefix,
+		"entries":         page.Entries,
+		"next_page_token": page.NextPageToken,
+	}
+	if expires := credentialsExpiry(ctx, backend); !expires.IsZero() {
+		resp["credentials_expire_at"] = expires.UTC()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// ListObjects is kept for backward compat (flat listing).
+func ListObjects(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConnectionID int64 `json:"connection_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.List)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	const maxResults = 1000
+	objects := []storage.Object{}
+	err := backend.Walk(ctx, "", func(obj storage.Object) error {
+		objects = append(objects, obj)
+		if len(objects) == maxResults {
+			return storage.StopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		writeStorageError(w, providerFromPath(r.URL.Path), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"objects":   objects,
+		"truncated": len(objects) == maxResults,
+	})
+}
+
+// DownloadURL returns a time-limited download URL (15 min expiry): the
+// provider's presigned URL, or a signed link to StreamObject for providers
+// that have none, when the request sets "proxy", or with proxy_downloads.
+func DownloadURL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConnectionID int64  `json:"connection_id"`
+		Object       string `json:"object"`
+		Proxy        bool   `json:"proxy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	const ttl = 15 * time.Minute
+	provider := providerFromPath(r.URL.Path)
+	if req.Proxy || cfg.ProxyDownloads {
+		if _, _, err := loadConnection(provider, req.ConnectionID); err != nil {
+			writeConnError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"url": streamURL(provider, req.ConnectionID, req.Object, ttl)})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Download)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	url, err := backend.Presign(ctx, req.Object, ttl)
+	if errors.Is(err, storage.ErrNotSupported) {
+		url, err = streamURL(provider, req.ConnectionID, req.Object, ttl), nil
+	}
+	if err != nil {
+		writeStorageError(w, provider, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"url": url})
+}
+
+// DeleteObject deletes a single object.
+func DeleteObject(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConnectionID int64  `json:"connection_id"`
+		Object       string `json:"object"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Delete)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	if err := backend.Delete(ctx, req.Object); err != nil {
+		writeStorageError(w, providerFromPath(r.URL.Path), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CopyObject copies (and optionally deletes) an object — used for rename/move.
+func CopyObject(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConnectionID int64  `json:"connection_id"`
+		Source       string `json:"source"`
+		Destination  string `json:"destination"`
+		Delete       bool   `json:"delete_source"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Copy)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	if renamer, ok := backend.(storage.Renamer); ok && req.Delete {
+		if err := renamer.Rename(ctx, req.Source, req.Destination); err != nil {
+			writeStorageError(w, providerFromPath(r.URL.Path), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := backend.Copy(ctx, req.Source, req.Destination); err != nil {
+		writeStorageError(w, providerFromPath(r.URL.Path), err)
+		return
+	}
+	if req.Delete {
+		if err := backend.Delete(ctx, req.Source); err != nil {
+			writeStorageError(w, providerFromPath(r.URL.Path), err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UploadObject streams a file from a multipart form to the bucket without
+// buffering it. The connection_id, prefix and optional size fields must come
+// before the file part, which is read straight from the request body.
+func UploadObject(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.MaxUploadSize))
+	mr, err := r.MultipartReader()
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	fields := map[string]string{}
+	var part *multipart.Part
+	for part == nil {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			badRequest(w, "missing file field")
+			return
+		}
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		if p.FormName() == "file" {
+			part = p
+			break
+		}
+		v, err := io.ReadAll(io.LimitReader(p, 4096))
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		fields[p.FormName()] = string(v)
+	}
+	defer part.Close()
+
+	connectionID, err := strconv.ParseInt(fields["connection_id"], 10, 64)
+	if err != nil {
+		badRequest(w, "invalid connection_id (it must come before the file field)")
+		return
+	}
+	prefix := fields["prefix"]
+	size := int64(-1)
+	if v := fields["size"]; v != "" {
+		if size, err = strconv.ParseInt(v, 10, 64); err != nil || size < 0 {
+			badRequest(w, "invalid size")
+			return
+		}
+		if size > int64(cfg.MaxUploadSize) {
+			writeError(w, codeTooLarge, fmt.Sprintf("file is larger than the %s upload limit", cfg.MaxUploadSize))
+			return
+		}
+	}
+	if part.FileName() == "" {
+		badRequest(w, "missing file name")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Upload)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, connectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	contentType := part.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	objectName := prefix + part.FileName()
+	body := &uploadBody{r: part, size: size}
+	if err := backend.Put(ctx, objectName, body, size, contentType); err != nil {
+		// Providers wrap errors from the request body in their own, so
+		// look at what the body itself returned first.
+		if body.err != nil {
+			writeUploadError(w, r, body.err)
+			return
+		}
+		writeStorageError(w, providerFromPath(r.URL.Path), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"name": objectName})
+}
+
+// uploadBody is the file part of an upload. It holds the client to the
+// declared size, if any, and remembers the first error reading the request.
+type uploadBody struct {
+	r    io.Reader
+	size int64 // -1 when not declared
+	n    int64
+	err  error
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.size >= 0 && b.n > b.size {
+		err = fmt.Errorf("file is larger than the declared size of %d bytes", b.size)
+	} else if err == io.EOF && b.size >= 0 && b.n < b.size {
+		err = fmt.Errorf("file is smaller than the declared size of %d bytes", b.size)
+	}
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// writeUploadError reports a failure reading the upload request itself.
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, codeTooLarge, fmt.Sprintf("upload is larger than the %s limit", cfg.MaxUploadSize))
+	case r.Context().Err() != nil:
+		writeError(w, storage.CodeCanceled, "upload canceled by the client")
+	default:
+		badRequest(w, err.Error())
+	}
+}
+
+// BucketStats returns sampled object count and total size.
+func BucketStats(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConnectionID int64 `json:"connection_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Stats)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	const maxSample = 10000
+	var count, totalSize int64
+	err := backend.Walk(ctx, "", func(obj storage.Object) error {
+		count++
+		totalSize += obj.Size
+		if count == maxSample {
+			return storage.StopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		writeStorageError(w, providerFromPath(r.URL.Path), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"object_count": count,
+		"total_size":   totalSize,
+		"truncated":    count == maxSample,
+	})
+}
+
+// GetMetadata returns full metadata for a single object.
+func GetMetadata(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConnectionID int64  `json:"connection_id"`
+		Object       string `json:"object"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Metadata)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	md, err := backend.Stat(ctx, req.Object)
+	if err != nil {
+		writeStorageError(w, providerFromPath(r.URL.Path), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(md)
+}
+
+// UpdateMetadata patches content-type, cache-control, and custom metadata on an object.
+func UpdateMetadata(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConnectionID int64             `json:"connection_id"`
+		Object       string            `json:"object"`
+		ContentType  string            `json:"content_type"`
+		CacheControl string            `json:"cache_control"`
+		Metadata     map[string]string `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.MetadataUpdate)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	if err := backend.UpdateMetadata(ctx, req.Object, storage.MetadataUpdate{
+		ContentType:  req.ContentType,
+		CacheControl: req.CacheControl,
+		Metadata:     req.Metadata,
+	}); err != nil {
+		writeStorageError(w, providerFromPath(r.URL.Path), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
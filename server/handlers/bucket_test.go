@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PandhuWibowo/oss-portable/config"
+	appdb "github.com/PandhuWibowo/oss-portable/db"
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// TestMain runs the handlers against a fresh SQLite database and the
+// default configuration. Connections use the memory provider.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "vestra-handlers-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := appdb.Init("file:" + filepath.Join(dir, "test.db") + "?_foreign_keys=1"); err != nil {
+		log.Fatal(err)
+	}
+	Configure(config.Default())
+	code := m.Run()
+	appdb.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// call sends body as JSON to handler at path and returns the recorded
+// response.
+func call(t *testing.T, handler http.HandlerFunc, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data)))
+	return w
+}
+
+// decode checks the status of a response and decodes its JSON body into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, status int, v any) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("decoding %q: %v", w.Body, err)
+		}
+	}
+}
+
+// expectError checks that a response is an API error with the given status
+// and code.
+func expectError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var e apiError
+	decode(t, w, status, &e)
+	if e.Code != code || e.Message == "" {
+		t.Errorf("error body %+v, want code %s and a message", e, code)
+	}
+}
+
+// memoryConnection saves a connection to a memory bucket of the test's own,
+// seeded with objects, and returns its ID.
+func memoryConnection(t *testing.T, objects map[string]string) int64 {
+	t.Helper()
+	bucket := strings.NewReplacer("/", "-", " ", "-").Replace(t.Name())
+	w := call(t, CreateConnection, "/api/memory/connection", map[string]string{"name": t.Name(), "bucket": bucket})
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	decode(t, w, http.StatusOK, &created)
+	b, err := storage.Open(t.Context(), "memory", bucket, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for key, body := range objects {
+		if err := b.Put(t.Context(), key, strings.NewReader(body), int64(len(body)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return created.ID
+}
+
+// metadata fetches an object's metadata through GetMetadata.
+func metadata(t *testing.T, id int64, object string) *httptest.ResponseRecorder {
+	t.Helper()
+	return call(t, GetMetadata, "/api/memory/bucket/metadata", map[string]any{"connection_id": id, "object": object})
+}
+
+func TestBrowseBucket(t *testing.T) {
+	id := memoryConnection(t, map[string]string{
+		"readme.md":       "# hi",
+		"docs/a.txt":      "a",
+		"docs/deep/b.txt": "bb",
+		"photos/cat.jpg":  "meow",
+	})
+
+	var page struct {
+		Prefix  string          `json:"prefix"`
+		Entries []storage.Entry `json:"entries"`
+	}
+	decode(t, call(t, BrowseBucket, "/api/memory/bucket/browse", map[string]any{"connection_id": id}), http.StatusOK, &page)
+	var got []string
+	for _, e := range page.Entries {
+		got = append(got, e.Type+":"+e.Name)
+	}
+	if want := "dir:docs/,dir:photos/,file:readme.md"; strings.Join(got, ",") != want {
+		t.Errorf("root entries %v, want %s", got, want)
+	}
+
+	decode(t, call(t, BrowseBucket, "/api/memory/bucket/browse", map[string]any{"connection_id": id, "prefix": "docs/"}), http.StatusOK, &page)
+	if page.Prefix != "docs/" || len(page.Entries) != 2 || page.Entries[0].Name != "docs/a.txt" || page.Entries[0].Size != 1 || page.Entries[1].Name != "docs/deep/" {
+		t.Errorf("docs/ listing %+v", page)
+	}
+
+	expectError(t, call(t, BrowseBucket, "/api/memory/bucket/browse", map[string]any{"connection_id": id + 1000}), http.StatusNotFound, storage.CodeNotFound)
+	w := httptest.NewRecorder()
+	BrowseBucket(w, httptest.NewRequest(http.MethodPost, "/api/memory/bucket/browse", strings.NewReader("{")))
+	expectError(t, w, http.StatusBadRequest, storage.CodeInvalid)
+}
+
+// upload posts a multipart form with the given fields, in order, followed
+// by the file part.
+func upload(t *testing.T, fields [][2]string, name, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range fields {
+		mw.WriteField(f[0], f[1])
+	}
+	if name != "" {
+		h := make(map[string][]string)
+		h["Content-Disposition"] = []string{fmt.Sprintf(`form-data; name="file"; filename=%q`, name)}
+		h["Content-Type"] = []string{contentType}
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(body))
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/memory/bucket/upload", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	UploadObject(w, r)
+	return w
+}
+
+func TestUploadObject(t *testing.T) {
+	id := memoryConnection(t, nil)
+	conn := fmt.Sprint(id)
+
+	var uploaded struct {
+		Name string `json:"name"`
+	}
+	w := upload(t, [][2]string{{"connection_id", conn}, {"prefix", "docs/"}, {"size", "5"}}, "notes.txt", "text/plain", "hello")
+	decode(t, w, http.StatusOK, &uploaded)
+	if uploaded.Name != "docs/notes.txt" {
+		t.Errorf("uploaded as %q, want docs/notes.txt", uploaded.Name)
+	}
+	var md storage.Metadata
+	decode(t, metadata(t, id, "docs/notes.txt"), http.StatusOK, &md)
+	if md.Size != 5 || md.ContentType != "text/plain" {
+		t.Errorf("metadata of the upload %+v", md)
+	}
+
+	for _, c := range []struct {
+		name   string
+		fields [][2]string
+		file   string
+		body   string
+		status int
+		code   string
+	}{
+		{"no connection", nil, "a.txt", "x", http.StatusBadRequest, storage.CodeInvalid},
+		{"no file", [][2]string{{"connection_id", conn}}, "", "", http.StatusBadRequest, storage.CodeInvalid},
+		{"size mismatch", [][2]string{{"connection_id", conn}, {"size", "2"}}, "a.txt", "abc", http.StatusBadRequest, storage.CodeInvalid},
+		{"over the limit", [][2]string{{"connection_id", conn}, {"size", fmt.Sprint(int64(cfg.MaxUploadSize) + 1)}}, "a.txt", "x", http.StatusRequestEntityTooLarge, codeTooLarge},
+		{"unknown connection", [][2]string{{"connection_id", fmt.Sprint(id + 1000)}}, "a.txt", "x", http.StatusNotFound, storage.CodeNotFound},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			expectError(t, upload(t, c.fields, c.file, "text/plain", c.body), c.status, c.code)
+		})
+	}
+	expectError(t, metadata(t, id, "a.txt"), http.StatusNotFound, storage.CodeNotFound)
+
+	// A full store is out of room, not a bad request.
+	storage.SetMemoryLimit(1)
+	defer storage.SetMemoryLimit(int64(config.Default().MemoryMaxSize))
+	w = upload(t, [][2]string{{"connection_id", conn}, {"size", "2"}}, "b.txt", "text/plain", "ab")
+	expectError(t, w, http.StatusInsufficientStorage, storage.CodeInsufficientStorage)
+}
+
+func TestDeleteObject(t *testing.T) {
+	id := memoryConnection(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	w := call(t, DeleteObject, "/api/memory/bucket/delete", map[string]any{"connection_id": id, "object": "a.txt"})
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("delete: status %d, body %q; want 204 and no body", w.Code, w.Body)
+	}
+	expectError(t, metadata(t, id, "a.txt"), http.StatusNotFound, storage.CodeNotFound)
+	decode(t, metadata(t, id, "b.txt"), http.StatusOK, nil)
+
+	w = call(t, DeleteObject, "/api/memory/bucket/delete", map[string]any{"connection_id": id, "object": "a.txt"})
+	expectError(t, w, http.StatusNotFound, storage.CodeNotFound)
+	var e apiError
+	json.Unmarshal(w.Body.Bytes(), &e)
+	if e.Provider != "memory" || e.Retryable {
+		t.Errorf("error body %+v, want provider memory and not retryable", e)
+	}
+}
+
+func TestCopyObject(t *testing.T) {
+	id := memoryConnection(t, map[string]string{"src.txt": "data"})
+	copyObject := func(src, dst string, del bool) *httptest.ResponseRecorder {
+		return call(t, CopyObject, "/api/memory/bucket/copy", map[string]any{
+			"connection_id": id, "source": src, "destination": dst, "delete_source": del,
+		})
+	}
+
+	if w := copyObject("src.txt", "copy.txt", false); w.Code != http.StatusNoContent {
+		t.Fatalf("copy: status %d: %s", w.Code, w.Body)
+	}
+	var md storage.Metadata
+	decode(t, metadata(t, id, "copy.txt"), http.StatusOK, &md)
+	if md.Size != 4 {
+		t.Errorf("copy has size %d, want 4", md.Size)
+	}
+	decode(t, metadata(t, id, "src.txt"), http.StatusOK, nil)
+
+	// delete_source turns the copy into a move.
+	if w := copyObject("src.txt", "moved/src.txt", true); w.Code != http.StatusNoContent {
+		t.Fatalf("move: status %d: %s", w.Code, w.Body)
+	}
+	expectError(t, metadata(t, id, "src.txt"), http.StatusNotFound, storage.CodeNotFound)
+	decode(t, metadata(t, id, "moved/src.txt"), http.StatusOK, nil)
+
+	expectError(t, copyObject("missing.txt", "x.txt", false), http.StatusNotFound, storage.CodeNotFound)
+}
+
+func TestMetadata(t *testing.T) {
+	id := memoryConnection(t, map[string]string{"report.csv": "a,b\n1,2\n"})
+
+	var md storage.Metadata
+	decode(t, metadata(t, id, "report.csv"), http.StatusOK, &md)
+	if md.Size != 8 || !strings.HasPrefix(md.ContentType, "text/csv") || md.ETag == "" {
+		t.Errorf("metadata %+v", md)
+	}
+
+	w := call(t, UpdateMetadata, "/api/memory/bucket/metadata/update", map[string]any{
+		"connection_id": id,
+		"object":        "report.csv",
+		"content_type":  "text/plain",
+		"cache_control": "max-age=60",
+		"metadata":      map[string]string{"owner": "finance"},
+	})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("update: status %d: %s", w.Code, w.Body)
+	}
+	decode(t, metadata(t, id, "report.csv"), http.StatusOK, &md)
+	if md.ContentType != "text/plain" || md.CacheControl != "max-age=60" || md.Metadata["owner"] != "finance" {
+		t.Errorf("metadata after update %+v", md)
+	}
+
+	w = call(t, UpdateMetadata, "/api/memory/bucket/metadata/update", map[string]any{
+		"connection_id": id, "object": "missing.csv", "content_type": "text/plain",
+	})
+	expectError(t, w, http.StatusNotFound, storage.CodeNotFound)
+}
@@ -0,0 +1,11 @@
+package handlers
+
+import "github.com/PandhuWibowo/oss-portable/config"
+
+// cfg holds the timeouts, upload limits and docs location used by the
+// handlers. It starts out as the built-in defaults.
+var cfg = config.Default()
+
+// Configure sets the configuration used by the handlers. Call it before the
+// server starts accepting requests.
+func Configure(c *config.Config) { cfg = c }
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	appdb "github.com/PandhuWibowo/oss-portable/db"
+	"github.com/PandhuWibowo/oss-portable/secrets"
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// ── helpers ──────────────────────────────────────────────────────
+
+// providerFromPath extracts {provider} from /api/{provider}/….
+func providerFromPath(path string) string {
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[2]
+}
+
+// errConnectionNotFound is returned by loadConnection for unknown IDs.
+var errConnectionNotFound = errors.New("connection not found")
+
+// loadConnection returns the bucket and stored credentials of a connection.
+// Credentials never leave the server; clients refer to connections by ID.
+func loadConnection(provider string, id int64) (bucket, credentials string, err error) {
+	if id, err = appdb.ResolveConnectionID(provider, id); err != nil {
+		return "", "", err
+	}
+	err = appdb.QueryRow(
+		"SELECT bucket, credentials FROM connections WHERE id = ? AND provider = ?", id, provider,
+	).Scan(&bucket, &credentials)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", errConnectionNotFound
+	}
+	if err != nil {
+		return "", "", err
+	}
+	credentials, err = secrets.Decrypt(credentials)
+	return bucket, credentials, err
+}
+
+// testBucket verifies bucket access for a provider and reports when its
+// temporary credentials expire (zero for long-lived ones). The check is
+// abandoned when the client goes away.
+func testBucket(ctx context.Context, provider, bucket, credentials string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeouts.Test)
+	defer cancel()
+
+	backend, err := storage.Open(ctx, provider, bucket, credentials)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer backend.Close()
+	if err := backend.Test(ctx); err != nil {
+		return time.Time{}, err
+	}
+	return credentialsExpiry(ctx, backend), nil
+}
+
+// credentialsExpiry returns when the backend's temporary credentials expire,
+// or the zero time. Failures are left to the next real request to report.
+func credentialsExpiry(ctx context.Context, backend storage.Backend) time.Time {
+	if e, ok := backend.(storage.CredentialsExpirer); ok {
+		if t, err := e.CredentialsExpiry(ctx); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ── connection CRUD ───────────────────────────────────────────────
+
+// Connection is a saved connection as returned to the client. Credentials
+// are deliberately omitted; has_credentials lets the UI tell a public
+// (anonymous) connection from one with stored secrets.
+type Connection struct {
+	ID             int64     `json:"id"`
+	Provider       string    `json:"provider"`
+	Name           string    `json:"name"`
+	Bucket         string    `json:"bucket"`
+	HasCredentials bool      `json:"has_credentials"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+const connectionColumns = "id, provider, name, bucket, credentials <> '', created_at"
+
+func scanConnections(rows *sql.Rows) ([]Connection, error) {
+	defer rows.Close()
+	conns := []Connection{}
+	for rows.Next() {
+		var c Connection
+		var created string
+		if err := rows.Scan(&c.ID, &c.Provider, &c.Name, &c.Bucket, &c.HasCredentials, &created); err != nil {
+			return nil, err
+		}
+		c.CreatedAt, _ = time.Parse(time.RFC3339, created)
+		conns = append(conns, c)
+	}
+	return conns, rows.Err()
+}
+
+// connectionSorts maps the ?sort= values of ListAllConnections to columns.
+var connectionSorts = map[string]string{
+	"created_at": "created_at",
+	"name":       "LOWER(name)",
+	"bucket":     "LOWER(bucket)",
+	"provider":   "provider",
+}
+
+// ListAllConnections handles GET /api/connections.
+//
+// Query parameters (all optional):
+//
+//	provider  comma-separated provider filter, e.g. aws,gcp
+//	q         case-insensitive substring match on name or bucket
+//	sort      created_at (default) | name | bucket | provider
+//	order     desc (default) | asc
+//	limit     page size (default: no limit, max 1000)
+//	offset    rows to skip
+func ListAllConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+
+	var where []string
+	var args []any
+	if p := q.Get("provider"); p != "" {
+		var placeholders []string
+		for _, name := range strings.Split(p, ",") {
+			placeholders = append(placeholders, "?")
+			args = append(args, strings.TrimSpace(name))
+		}
+		where = append(where, "provider IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if term := strings.TrimSpace(q.Get("q")); term != "" {
+		where = append(where, "(LOWER(name) LIKE ? OR LOWER(bucket) LIKE ?)")
+		like := "%" + strings.ToLower(term) + "%"
+		args = append(args, like, like)
+	}
+	whereSQL := ""
+	if len(where) > 0 {
+		whereSQL = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	sortCol := "created_at"
+	if s := q.Get("sort"); s != "" {
+		col, ok := connectionSorts[s]
+		if !ok {
+			badRequest(w, "invalid sort")
+			return
+		}
+		sortCol = col
+	}
+	order := "DESC"
+	switch strings.ToLower(q.Get("order")) {
+	case "", "desc":
+	case "asc":
+		order = "ASC"
+	default:
+		badRequest(w, "invalid order")
+		return
+	}
+
+	const maxLimit = 1000
+	limit, offset := 0, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			badRequest(w, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			badRequest(w, "invalid offset")
+			return
+		}
+		offset = n
+	}
+
+	var total int
+	if err := appdb.QueryRow("SELECT COUNT(*) FROM connections"+whereSQL, args...).Scan(&total); err != nil {
+		internalError(w, err)
+		return
+	}
+	page, pageArgs := appdb.LimitOffset(limit, offset)
+	rows, err := appdb.Query(
+		"SELECT "+connectionColumns+" FROM connections"+whereSQL+
+			" ORDER BY "+sortCol+" "+order+", id "+order+page,
+		append(args, pageArgs...)...,
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	conns, err := scanConnections(rows)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"connections": conns,
+		"total":       total,
+	})
+}
+
+// ListConnections handles GET /api/{provider}/connections.
+func ListConnections(w http.ResponseWriter, r *http.Request) {
+	provider := providerFromPath(r.URL.Path)
+	rows, err := appdb.Query(
+		"SELECT "+connectionColumns+" FROM connections WHERE provider = ? ORDER BY created_at DESC",
+		provider,
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	conns, err := scanConnections(rows)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(conns)
+}
+
+// CreateConnection handles POST /api/{provider}/connection.
+func CreateConnection(w http.ResponseWriter, r *http.Request) {
+	provider := providerFromPath(r.URL.Path)
+	var req struct {
+		Name        string `json:"name"`
+		Bucket      string `json:"bucket"`
+		Credentials string `json:"credentials"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if _, err := testBucket(r.Context(), provider, req.Bucket, req.Credentials); err != nil {
+		writeStorageError(w, provider, fmt.Errorf("test failed: %w", err))
+		return
+	}
+	sealed, err := secrets.Encrypt(req.Credentials)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	id, err := appdb.InsertID(
+		"INSERT INTO connections (provider, name, bucket, credentials, created_at) VALUES (?, ?, ?, ?, ?)",
+		provider, req.Name, req.Bucket, sealed, now,
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"id": id})
+}
+
+// ConnectionByID handles both DELETE and PUT for /api/{provider}/connection/{id}.
+func ConnectionByID(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodDelete:
+		DeleteConnection(w, r)
+	case http.MethodPut:
+		UpdateConnection(w, r)
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+	}
+}
+
+func DeleteConnection(w http.ResponseWriter, r *http.Request) {
+	provider := providerFromPath(r.URL.Path)
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 {
+		badRequest(w, "invalid path")
+		return
+	}
+	id, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		badRequest(w, "invalid id")
+		return
+	}
+	if id, err = appdb.ResolveConnectionID(provider, id); err != nil {
+		internalError(w, err)
+		return
+	}
+	if _, err = appdb.Exec("DELETE FROM connections WHERE id = ? AND provider = ?", id, provider); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func UpdateConnection(w http.ResponseWriter, r *http.Request) {
+	provider := providerFromPath(r.URL.Path)
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 {
+		badRequest(w, "invalid path")
+		return
+	}
+	id, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		badRequest(w, "invalid id")
+		return
+	}
+	if id, err = appdb.ResolveConnectionID(provider, id); err != nil {
+		internalError(w, err)
+		return
+	}
+	var req struct {
+		Name        string `json:"name"`
+		Bucket      string `json:"bucket"`
+		Credentials string `json:"credentials"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	// Blank credentials keep the stored ones, since the client never sees them.
+	if req.Credentials == "" {
+		if _, req.Credentials, err = loadConnection(provider, id); err != nil {
+			writeConnError(w, err)
+			return
+		}
+	}
+	if _, err := testBucket(r.Context(), provider, req.Bucket, req.Credentials); err != nil {
+		writeStorageError(w, provider, fmt.Errorf("test failed: %w", err))
+		return
+	}
+	sealed, err := secrets.Encrypt(req.Credentials)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if _, err := appdb.Exec(
+		"UPDATE connections SET name=?, bucket=?, credentials=? WHERE id=? AND provider=?",
+		req.Name, req.Bucket, sealed, id, provider,
+	); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TestConnection handles POST /api/{provider}/test.
+// When editing a saved connection the client may send its connection_id with
+// blank credentials to test against the stored ones.
+func TestConnection(w http.ResponseWriter, r *http.Request) {
+	provider := providerFromPath(r.URL.Path)
+	var req struct {
+		ConnectionID int64  `json:"connection_id"`
+		Bucket       string `json:"bucket"`
+		Credentials  string `json:"credentials"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if req.Credentials == "" && req.ConnectionID != 0 {
+		var err error
+		if _, req.Credentials, err = loadConnection(provider, req.ConnectionID); err != nil {
+			writeConnError(w, err)
+			return
+		}
+	}
+	expires, err := testBucket(r.Context(), provider, req.Bucket, req.Credentials)
+	if err != nil {
+		writeStorageError(w, provider, err)
+		return
+	}
+	resp := map[string]any{"status": "ok"}
+	if !expires.IsZero() {
+		resp["credentials_expire_at"] = expires.UTC()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// deletePageSize is how many keys of a prefix are listed before they are
+// deleted: enough for several batches in flight, while bounding the memory a
+// prefix delete holds.
+const deletePageSize = 10000
+
+// deleteListPage is the page size of the listing of a non-recursive delete.
+const deleteListPage = 1000
+
+// DeleteObjects handles POST /api/{provider}/bucket/delete/batch. It deletes
+// a list of keys, or the objects under a prefix: those directly in it, or
+// with recursive everything below it too, the prefix's folder marker
+// included. S3-compatible and Azure
+// connections delete in batches (1000 and 256 keys per request); the other
+// providers delete several keys in parallel.
+//
+// The delete runs as a job. If it finishes within timeouts.delete the reply
+// is 200 with its report; otherwise it is 202 with the job, which can be
+// polled and cancelled at /api/jobs/{id}. Keys that no longer exist count as
+// deleted.
+func DeleteObjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		ConnectionID int64    `json:"connection_id"`
+		Keys         []string `json:"keys"`
+		Prefix       string   `json:"prefix"`
+		Recursive    bool     `json:"recursive"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	switch {
+	case len(req.Keys) > 0 && req.Prefix != "":
+		badRequest(w, "send either keys or prefix, not both")
+		return
+	case len(req.Keys) == 0 && req.Prefix == "":
+		// An empty prefix would be the whole bucket.
+		badRequest(w, "keys or prefix is required")
+		return
+	case req.Prefix != "" && !strings.HasSuffix(req.Prefix, "/"):
+		badRequest(w, `prefix must end with "/"`)
+		return
+	}
+
+	// The backend outlives the request, so it must not be tied to it.
+	backend, ok := openBackend(context.Background(), w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	provider := providerFromPath(r.URL.Path)
+	j := startJob(newJobID(), "delete", provider, req.ConnectionID, func(ctx context.Context, j *job) error {
+		defer backend.Close()
+		if len(req.Keys) > 0 {
+			j.setTotal(int64(len(req.Keys)))
+			deleteKeys(ctx, backend, j, req.Keys)
+			return nil
+		}
+		return deletePrefix(ctx, backend, j, req.Prefix, req.Recursive)
+	})
+	j.wait(r.Context(), cfg.Timeouts.Delete)
+	writeJob(w, j)
+}
+
+// deleteKeys deletes keys and records the outcome of each in j. Keys cut
+// off by a cancel are not recorded at all.
+func deleteKeys(ctx context.Context, backend storage.Backend, j *job, keys []string) {
+	storage.DeleteMany(ctx, backend, keys, func(key string, err error) {
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			return
+		case storage.Classify(j.Provider, err).Code == storage.CodeNotFound:
+			err = nil
+		}
+		j.finish(key, err)
+	})
+}
+
+// deletePrefix deletes the objects under prefix a page at a time, while it
+// lists them. Every provider's listing either is a snapshot or continues
+// after the last key returned, so deleting behind it skips nothing. Without
+// recursive only the folder's own level is listed, however much lies below
+// it.
+func deletePrefix(ctx context.Context, backend storage.Backend, j *job, prefix string, recursive bool) error {
+	j.setTotal(0)
+	var err error
+	if recursive {
+		err = deleteTree(ctx, backend, j, prefix)
+	} else {
+		err = deleteLevel(ctx, backend, j, prefix)
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// deleteTree deletes everything below prefix. The folder's own marker
+// object, if it has one, goes last, so the folder stays in listings until
+// its contents are gone.
+func deleteTree(ctx context.Context, backend storage.Backend, j *job, prefix string) error {
+	page := make([]string, 0, deletePageSize)
+	marker := false
+	err := backend.Walk(ctx, prefix, func(o storage.Object) error {
+		if o.Name == prefix {
+			marker = true
+			j.addTotal(1)
+			return nil
+		}
+		page = append(page, o.Name)
+		j.addTotal(1)
+		if len(page) == deletePageSize {
+			deleteKeys(ctx, backend, j, page)
+			page = page[:0]
+		}
+		return ctx.Err()
+	})
+	if err == nil {
+		deleteKeys(ctx, backend, j, page)
+	}
+	if err == nil && marker && ctx.Err() == nil {
+		deleteKeys(ctx, backend, j, []string{prefix})
+	}
+	return err
+}
+
+// deleteLevel deletes the objects directly in prefix, a page of its
+// listing at a time. Subfolders are left alone, and so is the folder's own
+// marker object, since the folder still holds them.
+func deleteLevel(ctx context.Context, backend storage.Backend, j *job, prefix string) error {
+	token := ""
+	for {
+		p, err := backend.List(ctx, prefix, token, deleteListPage)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(p.Entries))
+		for _, e := range p.Entries {
+			if e.Type == "file" && e.Name != prefix {
+				keys = append(keys, e.Name)
+			}
+		}
+		j.addTotal(int64(len(keys)))
+		deleteKeys(ctx, backend, j, keys)
+		if p.NextPageToken == "" || ctx.Err() != nil {
+			return ctx.Err()
+		}
+		token = p.NextPageToken
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// noWalk is a backend whose Walk fails, to show a code path only lists.
+type noWalk struct{ storage.Backend }
+
+func (noWalk) Walk(context.Context, string, func(storage.Object) error) error {
+	return errors.New("Walk called")
+}
+
+// bucketKeys lists every key in the memory bucket of a test's connection.
+func bucketKeys(t *testing.T) []string {
+	t.Helper()
+	bucket := strings.NewReplacer("/", "-", " ", "-").Replace(t.Name())
+	b, err := storage.Open(t.Context(), "memory", bucket, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	var keys []string
+	if err := b.Walk(t.Context(), "", func(o storage.Object) error {
+		keys = append(keys, o.Name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestDeletePrefix(t *testing.T) {
+	objects := map[string]string{
+		"logs/a.log":         "a",
+		"logs/b.log":         "b",
+		"logs/2024/c.log":    "c",
+		"logs/2024/01/d.log": "d",
+		"other.txt":          "o",
+	}
+	for _, c := range []struct {
+		name      string
+		recursive bool
+		done      int64
+		left      string
+	}{
+		{"level", false, 2, "logs/2024/01/d.log,logs/2024/c.log,other.txt"},
+		{"recursive", true, 4, "other.txt"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			id := memoryConnection(t, objects)
+			var j struct {
+				State       string `json:"state"`
+				Total       int64  `json:"total"`
+				Done        int64  `json:"done"`
+				FailedCount int64  `json:"failed_count"`
+			}
+			decode(t, call(t, DeleteObjects, "/api/memory/bucket/delete/batch", map[string]any{
+				"connection_id": id, "prefix": "logs/", "recursive": c.recursive,
+			}), http.StatusOK, &j)
+			if j.State != jobDone || j.Done != c.done || j.Total != c.done || j.FailedCount != 0 {
+				t.Errorf("job %+v, want done with %d keys", j, c.done)
+			}
+			if got := strings.Join(bucketKeys(t), ","); got != c.left {
+				t.Errorf("left %s, want %s", got, c.left)
+			}
+		})
+	}
+}
+
+// folderMarker is a backend with an S3-style marker object for a folder,
+// which the memory provider cannot hold. It records the keys it deletes, in
+// order.
+type folderMarker struct {
+	storage.Backend
+	folder  string
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (f *folderMarker) Walk(ctx context.Context, prefix string, fn func(storage.Object) error) error {
+	if strings.HasPrefix(f.folder, prefix) {
+		if err := fn(storage.Object{Name: f.folder}); err != nil {
+			return err
+		}
+	}
+	return f.Backend.Walk(ctx, prefix, fn)
+}
+
+func (f *folderMarker) List(ctx context.Context, prefix, pageToken string, limit int) (*storage.Page, error) {
+	p, err := f.Backend.List(ctx, prefix, pageToken, limit)
+	if err == nil && prefix == f.folder && pageToken == "" {
+		p.Entries = append([]storage.Entry{{Type: "file", Name: f.folder}}, p.Entries...)
+	}
+	return p, err
+}
+
+func (f *folderMarker) Delete(ctx context.Context, key string) error {
+	f.mu.Lock()
+	f.deleted = append(f.deleted, key)
+	f.mu.Unlock()
+	if key == f.folder {
+		return nil
+	}
+	return f.Backend.Delete(ctx, key)
+}
+
+func TestDeleteFolderMarker(t *testing.T) {
+	objects := map[string]string{"dir/sub/keep.txt": "k"}
+	for i := 0; i < 20; i++ {
+		objects[fmt.Sprintf("dir/f%02d.txt", i)] = "f"
+	}
+	for _, c := range []struct {
+		name      string
+		recursive bool
+		deleted   int
+	}{
+		// The folder keeps its marker while it still holds subfolders.
+		{"level", false, 20},
+		{"recursive", true, 22},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			memoryConnection(t, objects)
+			bucket := strings.NewReplacer("/", "-", " ", "-").Replace(t.Name())
+			b, err := storage.Open(t.Context(), "memory", bucket, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := &folderMarker{Backend: b, folder: "dir/"}
+			j := startJob(newJobID(), "delete", "memory", 0, func(ctx context.Context, j *job) error {
+				return deletePrefix(ctx, f, j, "dir/", c.recursive)
+			})
+			<-j.finished
+			j.mu.Lock()
+			done, total := j.Done, j.Total
+			j.mu.Unlock()
+			if len(f.deleted) != c.deleted || done != int64(c.deleted) || total != int64(c.deleted) {
+				t.Fatalf("deleted %v, %d of %d done; want %d keys", f.deleted, done, total, c.deleted)
+			}
+			last := f.deleted[len(f.deleted)-1]
+			if marker := slices.Contains(f.deleted, "dir/"); marker != c.recursive || (c.recursive && last != "dir/") {
+				t.Errorf("deleted %v; want the marker deleted last only when recursive", f.deleted)
+			}
+		})
+	}
+}
+
+func TestDeleteLevelDoesNotWalk(t *testing.T) {
+	// More than one page of listing, and a subfolder that must stay.
+	objects := map[string]string{"dir/sub/keep.txt": "k"}
+	for i := 0; i < deleteListPage+5; i++ {
+		objects[fmt.Sprintf("dir/f%04d.txt", i)] = "f"
+	}
+	memoryConnection(t, objects)
+	bucket := strings.NewReplacer("/", "-", " ", "-").Replace(t.Name())
+	b, err := storage.Open(t.Context(), "memory", bucket, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := startJob(newJobID(), "delete", "memory", 0, func(ctx context.Context, j *job) error {
+		return deletePrefix(ctx, noWalk{b}, j, "dir/", false)
+	})
+	<-j.finished
+	j.mu.Lock()
+	state, done, errText := j.State, j.Done, j.Error
+	j.mu.Unlock()
+	if state != jobDone || done != deleteListPage+5 {
+		t.Errorf("job %s with %d keys done (%s), want done with %d", state, done, errText, deleteListPage+5)
+	}
+	if got := strings.Join(bucketKeys(t), ","); got != "dir/sub/keep.txt" {
+		t.Errorf("left %s, want dir/sub/keep.txt", got)
+	}
+}
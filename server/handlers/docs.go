@@ -5,12 +5,18 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
 )
 
-// docsDir resolves the docs directory regardless of working directory.
+// docsDir returns the configured docs directory or, when none is set,
+// resolves it regardless of working directory.
 // In dev the server runs from server/, so docs are at ../docs.
 // In production the binary runs from the project root, so docs are at ./docs.
 func docsDir() string {
+	if cfg.DocsDir != "" {
+		return cfg.DocsDir
+	}
 	for _, candidate := range []string{"../docs", "./docs", "docs"} {
 		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
 			return candidate
@@ -22,7 +28,7 @@ func docsDir() string {
 // ServeDocs handles GET /api/docs/{page} and returns the raw markdown file.
 func ServeDocs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		writeError(w, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 
@@ -42,7 +48,7 @@ func ServeDocs(w http.ResponseWriter, r *http.Request) {
 	fullPath := filepath.Join(docsDir(), page)
 	data, err := os.ReadFile(fullPath)
 	if err != nil {
-		http.Error(w, `{"error":"page not found"}`, http.StatusNotFound)
+		writeError(w, storage.CodeNotFound, "page not found")
 		return
 	}
 
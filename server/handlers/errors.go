@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// Codes for errors raised by the API itself rather than by a provider. The
+// provider codes are defined in the storage package.
+const (
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeTooLarge             = "payload_too_large"
+	codePreconditionFailed   = "precondition_failed"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeRangeNotSatisfiable  = "range_not_satisfiable"
+)
+
+// apiError is the JSON body of every error response.
+type apiError struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Provider  string         `json:"provider,omitempty"`
+	Retryable bool           `json:"retryable"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// statusForCode maps an error code to its HTTP status.
+var statusForCode = map[string]int{
+	storage.CodeInvalid:             http.StatusBadRequest,
+	storage.CodeAccessDenied:        http.StatusForbidden,
+	storage.CodeNotFound:            http.StatusNotFound,
+	storage.CodeConflict:            http.StatusConflict,
+	storage.CodeThrottled:           http.StatusTooManyRequests,
+	storage.CodeTimeout:             http.StatusGatewayTimeout,
+	storage.CodeCanceled:            499, // client closed the request
+	storage.CodeUnavailable:         http.StatusBadGateway,
+	storage.CodeNotSupported:        http.StatusNotImplemented,
+	storage.CodeInternal:            http.StatusInternalServerError,
+	storage.CodeInsufficientStorage: http.StatusInsufficientStorage,
+	codeMethodNotAllowed:            http.StatusMethodNotAllowed,
+	codeTooLarge:                    http.StatusRequestEntityTooLarge,
+	codePreconditionFailed:          http.StatusPreconditionFailed,
+	codeUnsupportedMediaType:        http.StatusUnsupportedMediaType,
+	codeRangeNotSatisfiable:         http.StatusRequestedRangeNotSatisfiable,
+}
+
+func writeAPIError(w http.ResponseWriter, e apiError) {
+	status, ok := statusForCode[e.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(e)
+}
+
+// writeError replies with an error raised by the API itself.
+func writeError(w http.ResponseWriter, code, message string) {
+	writeAPIError(w, apiError{Code: code, Message: message})
+}
+
+// badRequest replies 400 with the given message.
+func badRequest(w http.ResponseWriter, message string) {
+	writeError(w, storage.CodeInvalid, message)
+}
+
+// internalError replies 500 for failures of the server itself (database,
+// encryption) and logs the cause.
+func internalError(w http.ResponseWriter, err error) {
+	log.Printf("internal error: %v", err)
+	writeError(w, storage.CodeInternal, err.Error())
+}
+
+// writeConnError replies to a failure to load a saved connection.
+func writeConnError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errConnectionNotFound) {
+		writeError(w, storage.CodeNotFound, err.Error())
+		return
+	}
+	internalError(w, err)
+}
+
+// writeStorageError classifies an error returned by a storage backend and
+// replies with the matching code and status.
+func writeStorageError(w http.ResponseWriter, provider string, err error) {
+	e := storage.Classify(provider, err)
+	writeAPIError(w, apiError{
+		Code:      e.Code,
+		Message:   err.Error(),
+		Provider:  e.Provider,
+		Retryable: e.Retryable,
+		Details:   e.Details,
+	})
+}
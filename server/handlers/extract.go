@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// ExtractUpload expands an uploaded ZIP, tar or tar.gz into a prefix. tar
+// archives are read as they arrive; a ZIP keeps its directory at the end, so
+// it is spooled to upload_dir first. Entries are written upload_concurrency
+// at a time, and the reply lists the keys created and the entries that
+// failed.
+
+const (
+	// maxExtractEntries bounds the files of one archive, and with it the
+	// report.
+	maxExtractEntries = 100_000
+	// maxExtractFailures is how many failed entries the report lists; the
+	// rest are only counted.
+	maxExtractFailures = maxJobFailures
+)
+
+// errExtractLimit stops an extraction at the first file past
+// maxExtractEntries or extract_max_size.
+var errExtractLimit = errors.New("archive limit exceeded")
+
+// extractedObject is a key created from an archive entry.
+type extractedObject struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// extractFailure is an archive entry that was not written.
+type extractFailure struct {
+	Entry string `json:"entry"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// extractor writes archive entries under a prefix, a few at a time.
+type extractor struct {
+	ctx     context.Context
+	backend storage.Backend
+	prefix  string
+
+	sem   chan struct{}
+	wg    sync.WaitGroup
+	files int
+	total int64
+
+	mu          sync.Mutex
+	created     []extractedObject
+	failed      []extractFailure
+	failedCount int
+}
+
+// entryKey maps an entry name to the key it is written to. Names that would
+// land outside the prefix once extracted ("../x", "/etc/x", "C:\x") are
+// refused rather than cleaned up, since they are a sign of a hostile archive.
+func (x *extractor) entryKey(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	switch {
+	case strings.ContainsRune(name, 0):
+		return "", errors.New("name contains a NUL byte")
+	case strings.HasPrefix(name, "/"), len(name) >= 2 && name[1] == ':':
+		return "", errors.New("absolute path")
+	}
+	var parts []string
+	for _, p := range strings.Split(name, "/") {
+		switch p {
+		case "", ".":
+		case "..":
+			return "", errors.New("path escapes the target folder")
+		default:
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return "", errors.New("empty name")
+	}
+	return x.prefix + strings.Join(parts, "/"), nil
+}
+
+// admit returns the key of an entry, or "" when the entry is refused and
+// recorded as failed. An entry past the archive limits is an error: the
+// archive is not read any further.
+func (x *extractor) admit(name string, size int64) (string, error) {
+	key, err := x.entryKey(name)
+	if err != nil {
+		x.fail(name, "", err)
+		return "", nil
+	}
+	x.files++
+	x.total += size
+	switch {
+	case x.files > maxExtractEntries:
+		return "", fmt.Errorf("%w: the archive has more than %d files", errExtractLimit, maxExtractEntries)
+	case x.total > int64(cfg.ExtractMaxSize):
+		return "", fmt.Errorf("%w: the archive expands to more than the %s limit", errExtractLimit, cfg.ExtractMaxSize)
+	}
+	return key, nil
+}
+
+// fail records an entry that was not written.
+func (x *extractor) fail(name, key string, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.failedCount++
+	if len(x.failed) < maxExtractFailures {
+		x.failed = append(x.failed, extractFailure{Entry: name, Key: key, Error: err.Error()})
+	}
+}
+
+// acquire waits for a free writer.
+func (x *extractor) acquire() error {
+	select {
+	case x.sem <- struct{}{}:
+		return nil
+	case <-x.ctx.Done():
+		return x.ctx.Err()
+	}
+}
+
+// put writes one entry; the caller holds a writer slot, which put releases.
+func (x *extractor) put(name, key string, open func() (io.ReadCloser, error), size int64) {
+	defer func() { <-x.sem }()
+	rc, err := open()
+	if err != nil {
+		x.fail(name, key, err)
+		return
+	}
+	defer rc.Close()
+	contentType, body := guessContentType(name, rc)
+	if err := x.backend.Put(x.ctx, key, body, size, contentType); err != nil {
+		x.fail(name, key, err)
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.created = append(x.created, extractedObject{Key: key, Size: size, ContentType: contentType})
+}
+
+// goPut writes one entry in the background.
+func (x *extractor) goPut(name, key string, open func() (io.ReadCloser, error), size int64) error {
+	if err := x.acquire(); err != nil {
+		return err
+	}
+	x.wg.Add(1)
+	go func() {
+		defer x.wg.Done()
+		x.put(name, key, open, size)
+	}()
+	return nil
+}
+
+// guessContentType picks a content type from the name's extension, or else
+// from the first bytes of the data.
+func guessContentType(name string, r io.Reader) (string, io.Reader) {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t, r
+	}
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	return http.DetectContentType(head), br
+}
+
+// extractTar writes the regular files of a tar stream. Entries up to
+// upload_part_size are read into memory and written in the background; the
+// reader has to wait for bigger ones, which are written from the stream.
+func (x *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeDir {
+			continue
+		}
+		if !h.FileInfo().Mode().IsRegular() {
+			x.fail(h.Name, "", errors.New("not a regular file"))
+			continue
+		}
+		key, err := x.admit(h.Name, h.Size)
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			continue
+		}
+		if h.Size > int64(cfg.UploadPartSize) {
+			if err := x.acquire(); err != nil {
+				return err
+			}
+			x.put(h.Name, key, func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }, h.Size)
+			continue
+		}
+		data := make([]byte, h.Size)
+		if _, err := io.ReadFull(tr, data); err != nil {
+			return err
+		}
+		open := func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+		if err := x.goPut(h.Name, key, open, h.Size); err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip writes the files of a ZIP held in f.
+func (x *extractor) extractZip(f *os.File, size int64) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		if !zf.Mode().IsRegular() {
+			x.fail(zf.Name, "", errors.New("not a regular file"))
+			continue
+		}
+		// The reader holds each file to its declared size, so the limit
+		// cannot be bypassed by a lying header.
+		key, err := x.admit(zf.Name, int64(zf.UncompressedSize64))
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			continue
+		}
+		if err := x.goPut(zf.Name, key, zf.Open, int64(zf.UncompressedSize64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtractUpload handles POST /api/{provider}/bucket/upload/extract. The
+// form is that of /bucket/upload; the file is a ZIP, tar or tar.gz, told
+// apart by its first bytes. Each file in it is written to prefix plus its
+// path in the archive, with a content type guessed from its name or data.
+// The reply lists what was created and what failed; if the archive itself
+// turns out to be unreadable halfway, or goes past the extraction limits,
+// the error carries the same lists in its details.
+func ExtractUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.MaxUploadSize))
+	mr, err := r.MultipartReader()
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	fields := map[string]string{}
+	var part *multipart.Part
+	for part == nil {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			badRequest(w, "missing file field")
+			return
+		}
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		if p.FormName() == "file" {
+			part = p
+			break
+		}
+		v, err := io.ReadAll(io.LimitReader(p, 4096))
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		fields[p.FormName()] = string(v)
+	}
+	defer part.Close()
+
+	connectionID, err := strconv.ParseInt(fields["connection_id"], 10, 64)
+	if err != nil {
+		badRequest(w, "invalid connection_id (it must come before the file field)")
+		return
+	}
+	prefix := fields["prefix"]
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	body := bufio.NewReader(part)
+	magic, _ := body.Peek(262)
+	var format string
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		format = "zip"
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		format = "tar.gz"
+	case len(magic) == 262 && string(magic[257:262]) == "ustar":
+		format = "tar"
+	default:
+		writeError(w, codeUnsupportedMediaType, "the file is not a ZIP, tar or tar.gz archive")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Upload)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, connectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	x := &extractor{ctx: ctx, backend: backend, prefix: prefix, sem: make(chan struct{}, cfg.UploadConcurrency)}
+	switch format {
+	case "zip":
+		f, size, spoolErr := spoolArchive(body)
+		if spoolErr != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(spoolErr, &tooLarge) || r.Context().Err() != nil {
+				writeUploadError(w, r, spoolErr)
+			} else {
+				internalError(w, spoolErr)
+			}
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		err = x.extractZip(f, size)
+	case "tar.gz":
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(body); err == nil {
+			err = x.extractTar(gz)
+		}
+	default:
+		err = x.extractTar(body)
+	}
+	x.wg.Wait()
+
+	report := map[string]any{
+		"prefix": prefix, "format": format,
+		"created": x.created, "failed": x.failed, "failed_count": x.failedCount,
+	}
+	if x.created == nil {
+		report["created"] = []extractedObject{}
+	}
+	if x.failed == nil {
+		report["failed"] = []extractFailure{}
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		e := apiError{Code: storage.CodeInvalid, Message: "reading the archive: " + err.Error(), Details: report}
+		switch {
+		case errors.As(err, &tooLarge):
+			e.Code, e.Message = codeTooLarge, fmt.Sprintf("upload is larger than the %s limit", cfg.MaxUploadSize)
+		case errors.Is(err, errExtractLimit):
+			e.Code, e.Message = codeTooLarge, err.Error()
+		case r.Context().Err() != nil:
+			e.Code, e.Message = storage.CodeCanceled, "upload canceled by the client"
+		case ctx.Err() != nil:
+			e.Code, e.Message = storage.CodeTimeout, "extracting the archive timed out"
+		}
+		writeAPIError(w, e)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// spoolArchive copies a ZIP to a temporary file in upload_dir; the caller
+// removes it.
+func spoolArchive(r io.Reader) (*os.File, int64, error) {
+	if err := os.MkdirAll(uploadDir(), 0o700); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.CreateTemp(uploadDir(), "extract-*.zip")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	return f, size, nil
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+func TestEntryKey(t *testing.T) {
+	x := &extractor{prefix: "site/"}
+	for _, c := range []struct {
+		name string
+		key  string // "" when refused
+	}{
+		{"index.html", "site/index.html"},
+		{"a/b/c.txt", "site/a/b/c.txt"},
+		{"./a//b.txt", "site/a/b.txt"},
+		{`a\b.txt`, "site/a/b.txt"},
+		{"a/..b", "site/a/..b"},
+		{"../x", ""},
+		{"a/../../x", ""},
+		{"a/../x", ""},
+		{"/etc/x", ""},
+		{`C:\x`, ""},
+		{"C:x", ""},
+		{`a\..\..\x`, ""},
+		{`\\server\share\x`, ""},
+		{"a\x00.txt", ""},
+		{"./", ""},
+		{"", ""},
+	} {
+		key, err := x.entryKey(c.name)
+		if key != c.key || (err == nil) != (c.key != "") {
+			t.Errorf("entryKey(%q) = %q, %v; want %q", c.name, key, err, c.key)
+		}
+	}
+}
+
+// extract posts archive to ExtractUpload for connection id.
+func extract(t *testing.T, id int64, prefix string, archive []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("connection_id", fmt.Sprint(id))
+	mw.WriteField("prefix", prefix)
+	part, err := mw.CreateFormFile("file", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(archive)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/memory/bucket/upload/extract", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	ExtractUpload(w, r)
+	return w
+}
+
+// packedEntry is a file, folder or link to pack into a test archive.
+type packedEntry struct {
+	name string
+	body string
+	mode os.FileMode
+}
+
+func packZip(t *testing.T, entries []packedEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		h.SetMode(e.mode | 0o644)
+		f, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(e.body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func packTar(t *testing.T, entries []packedEntry, compress bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.mode.IsDir():
+			h.Typeflag, h.Size = tar.TypeDir, 0
+		case e.mode&os.ModeSymlink != 0:
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, e.body, 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			tw.Write([]byte(e.body))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	return buf.Bytes()
+}
+
+// extractReport is the reply of ExtractUpload, and the details of its
+// errors.
+type extractReport struct {
+	Prefix      string            `json:"prefix"`
+	Format      string            `json:"format"`
+	Created     []extractedObject `json:"created"`
+	Failed      []extractFailure  `json:"failed"`
+	FailedCount int               `json:"failed_count"`
+}
+
+func (r extractReport) keys() []string {
+	var keys []string
+	for _, o := range r.Created {
+		keys = append(keys, o.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r extractReport) failedEntries() []string {
+	var entries []string
+	for _, f := range r.Failed {
+		entries = append(entries, f.Entry)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+func TestExtractUpload(t *testing.T) {
+	id := memoryConnection(t, nil)
+	entries := []packedEntry{
+		{name: "index.html", body: "<html></html>"},
+		{name: "css/", mode: os.ModeDir},
+		{name: "css/site.css", body: "body{}"},
+		{name: "notes", body: "plain words"},
+		{name: "../evil.sh", body: "rm -rf /"},
+		{name: "/etc/passwd", body: "root"},
+		{name: "link", body: "index.html", mode: os.ModeSymlink},
+	}
+
+	for _, c := range []struct {
+		format  string
+		archive []byte
+	}{
+		{"zip", packZip(t, entries)},
+		{"tar.gz", packTar(t, entries, true)},
+		{"tar", packTar(t, entries, false)},
+	} {
+		t.Run(c.format, func(t *testing.T) {
+			prefix := "out-" + c.format
+			var report extractReport
+			decode(t, extract(t, id, prefix, c.archive), http.StatusOK, &report)
+			p := prefix + "/"
+			if report.Prefix != p || report.Format != c.format {
+				t.Errorf("report %+v", report)
+			}
+			if got, want := fmt.Sprint(report.keys()), fmt.Sprint([]string{p + "css/site.css", p + "index.html", p + "notes"}); got != want {
+				t.Errorf("created %s, want %s", got, want)
+			}
+			if got, want := fmt.Sprint(report.failedEntries()), "[../evil.sh /etc/passwd link]"; got != want {
+				t.Errorf("failed %s, want %s", got, want)
+			}
+			if report.FailedCount != 3 {
+				t.Errorf("failed_count %d, want 3", report.FailedCount)
+			}
+			for _, o := range report.Created {
+				var md storage.Metadata
+				decode(t, metadata(t, id, o.Key), http.StatusOK, &md)
+				if md.Size != o.Size || md.ContentType != o.ContentType {
+					t.Errorf("%s: metadata %+v, report %+v", o.Key, md, o)
+				}
+			}
+			expectError(t, metadata(t, id, "evil.sh"), http.StatusNotFound, storage.CodeNotFound)
+		})
+	}
+
+	t.Run("not an archive", func(t *testing.T) {
+		expectError(t, extract(t, id, "", []byte("just some text")), http.StatusUnsupportedMediaType, codeUnsupportedMediaType)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		archive := packTar(t, []packedEntry{{name: "a.txt", body: "a"}, {name: "b.txt", body: string(make([]byte, 4096))}}, false)
+		w := extract(t, id, "truncated", archive[:len(archive)-3000])
+		var e struct {
+			apiError
+			Details extractReport `json:"details"`
+		}
+		decode(t, w, http.StatusBadRequest, &e)
+		if e.Code != storage.CodeInvalid || fmt.Sprint(e.Details.keys()) != "[truncated/a.txt]" {
+			t.Errorf("error %+v", e)
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		saved := cfg.ExtractMaxSize
+		cfg.ExtractMaxSize = 8
+		defer func() { cfg.ExtractMaxSize = saved }()
+		files := []packedEntry{{name: "1.txt", body: "11111"}, {name: "2.txt", body: "22222"}, {name: "3.txt", body: "3"}}
+		for _, archive := range [][]byte{packZip(t, files), packTar(t, files, true)} {
+			var e struct {
+				apiError
+				Details extractReport `json:"details"`
+			}
+			decode(t, extract(t, id, "limited", archive), http.StatusRequestEntityTooLarge, &e)
+			if e.Code != codeTooLarge || fmt.Sprint(e.Details.keys()) != "[limited/1.txt]" {
+				t.Errorf("error %+v", e)
+			}
+			// Extraction stopped at 2.txt, so 3.txt, which would fit, is
+			// not written either.
+			expectError(t, metadata(t, id, "limited/3.txt"), http.StatusNotFound, storage.CodeNotFound)
+		}
+	})
+
+	t.Run("failures are capped", func(t *testing.T) {
+		var files []packedEntry
+		for i := 0; i < maxExtractFailures+5; i++ {
+			files = append(files, packedEntry{name: fmt.Sprintf("../%d", i)})
+		}
+		files = append(files, packedEntry{name: "ok.txt", body: "ok"})
+		var report extractReport
+		decode(t, extract(t, id, "capped", packTar(t, files, true)), http.StatusOK, &report)
+		if len(report.Failed) != maxExtractFailures || report.FailedCount != maxExtractFailures+5 || len(report.Created) != 1 {
+			t.Errorf("%d failures listed, failed_count %d, %d created", len(report.Failed), report.FailedCount, len(report.Created))
+		}
+	})
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// Jobs are bucket operations that take too long for one request, such as
+// deleting a folder with thousands of objects. They run in the background
+// on the replica that started them; clients poll /api/jobs/{id} for
+// progress and DELETE it to cancel. Finished jobs are kept for jobRetention.
+// When the server stops, ShutdownJobs gives running jobs until the drain
+// deadline and then cancels them.
+
+const (
+	jobRetention = time.Hour
+	// maxJobFailures is how many failed keys a job lists; the rest are
+	// only counted.
+	maxJobFailures = 1000
+	// jobStopTimeout is how long canceled jobs get to record where they
+	// stopped when the server shuts down.
+	jobStopTimeout = 10 * time.Second
+)
+
+const (
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobCanceled = "canceled"
+	jobFailed   = "failed"
+)
+
+// jobFailure is a key a job could not process.
+type jobFailure struct {
+	Key   string `json:"key"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// job is a background operation. Total is -1 while it is still unknown,
+// e.g. while a prefix is being listed. Phase names the current step of jobs
+// that have several.
+type job struct {
+	ID           string       `json:"id"`
+	Kind         string       `json:"kind"`
+	Provider     string       `json:"provider"`
+	ConnectionID int64        `json:"connection_id"`
+	State        string       `json:"state"`
+	Phase        string       `json:"phase,omitempty"`
+	Total        int64        `json:"total"`
+	Done         int64        `json:"done"`
+	FailedCount  int64        `json:"failed_count"`
+	Failures     []jobFailure `json:"failures"`
+	Error        string       `json:"error,omitempty"`
+	Started      time.Time    `json:"started_at"`
+	Finished     *time.Time   `json:"finished_at,omitempty"`
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+var jobs = struct {
+	sync.Mutex
+	m map[string]*job
+	// ctx is the parent of every job's context. ShutdownJobs cancels it
+	// with errShuttingDown.
+	ctx  context.Context
+	stop context.CancelCauseFunc
+}{m: map[string]*job{}}
+
+func init() {
+	jobs.ctx, jobs.stop = context.WithCancelCause(context.Background())
+}
+
+// errShuttingDown is the cause of cancellation for jobs still running when
+// the server stops.
+var errShuttingDown = errors.New("interrupted by server shutdown")
+
+// shuttingDown reports whether ctx of a job was canceled by ShutdownJobs
+// rather than by a client.
+func shuttingDown(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errShuttingDown)
+}
+
+// newJobID returns a random job ID.
+func newJobID() string {
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// startJob runs fn in the background as a job of the given kind. fn reports
+// progress through the job and returns an error only if it had to give up.
+func startJob(id, kind, provider string, connectionID int64, fn func(ctx context.Context, j *job) error) *job {
+	jobs.Lock()
+	ctx, cancel := context.WithCancel(jobs.ctx)
+	j := &job{
+		ID:           id,
+		Kind:         kind,
+		Provider:     provider,
+		ConnectionID: connectionID,
+		State:        jobRunning,
+		Total:        -1,
+		Failures:     []jobFailure{},
+		Started:      time.Now().UTC(),
+		cancel:       cancel,
+		finished:     make(chan struct{}),
+	}
+	for oldID, old := range jobs.m {
+		old.mu.Lock()
+		expired := old.Finished != nil && time.Since(*old.Finished) > jobRetention
+		old.mu.Unlock()
+		if expired {
+			delete(jobs.m, oldID)
+		}
+	}
+	jobs.m[j.ID] = j
+	jobs.Unlock()
+
+	go func() {
+		defer cancel()
+		err := fn(ctx, j)
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		switch {
+		case ctx.Err() != nil:
+			j.State = jobCanceled
+			if shuttingDown(ctx) {
+				j.Error = errShuttingDown.Error()
+			}
+		case err != nil:
+			j.State = jobFailed
+			j.Error = err.Error()
+		default:
+			j.State = jobDone
+		}
+		now := time.Now().UTC()
+		j.Finished = &now
+		close(j.finished)
+	}()
+	return j
+}
+
+// ShutdownJobs waits for running jobs until ctx is done, then cancels the
+// ones left and waits up to jobStopTimeout for them to stop. Each canceled
+// job is logged with the progress it made. Moves canceled this way stay
+// recorded as running, so the next start resumes them.
+func ShutdownJobs(ctx context.Context) {
+	jobs.Lock()
+	var running []*job
+	for _, j := range jobs.m {
+		select {
+		case <-j.finished:
+		default:
+			running = append(running, j)
+		}
+	}
+	jobs.Unlock()
+	if len(running) == 0 {
+		return
+	}
+	log.Printf("waiting for %d running job(s)", len(running))
+	for _, j := range running {
+		select {
+		case <-j.finished:
+		case <-ctx.Done():
+		}
+	}
+	jobs.stop(errShuttingDown)
+
+	stopped := time.After(jobStopTimeout)
+	for _, j := range running {
+		select {
+		case <-j.finished:
+		case <-stopped:
+		}
+		j.mu.Lock()
+		if j.State == jobCanceled {
+			log.Printf("%s job %s canceled by shutdown after %d of %d key(s), %d failed",
+				j.Kind, j.ID, j.Done, j.Total, j.FailedCount)
+		} else if j.State == jobRunning {
+			log.Printf("%s job %s did not stop within %s", j.Kind, j.ID, jobStopTimeout)
+		}
+		j.mu.Unlock()
+	}
+}
+
+// setPhase records which step of a job with several is running.
+func (j *job) setPhase(phase string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Phase = phase
+}
+
+// setTotal records how many keys the job has to process.
+func (j *job) setTotal(n int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Total = n
+}
+
+// addTotal adds keys found while the job runs.
+func (j *job) addTotal(n int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Total < 0 {
+		j.Total = 0
+	}
+	j.Total += n
+}
+
+// finish records the outcome for one key: done when err is nil, failed
+// otherwise.
+func (j *job) finish(key string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err == nil {
+		j.Done++
+		return
+	}
+	j.FailedCount++
+	if len(j.Failures) < maxJobFailures {
+		e := storage.Classify(j.Provider, err)
+		j.Failures = append(j.Failures, jobFailure{Key: key, Code: e.Code, Error: e.Error()})
+	}
+}
+
+// wait blocks until the job finishes or d passes, and reports whether it
+// finished.
+func (j *job) wait(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-j.finished:
+		return true
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	return false
+}
+
+// writeJob replies with the job's status: 200 once it has finished, 202
+// while it runs.
+func writeJob(w http.ResponseWriter, j *job) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := http.StatusOK
+	if j.Finished == nil {
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+// ListJobs handles GET /api/jobs, returning running and recent jobs, newest
+// first, without their failure lists.
+func ListJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	jobs.Lock()
+	all := make([]*job, 0, len(jobs.m))
+	for _, j := range jobs.m {
+		all = append(all, j)
+	}
+	jobs.Unlock()
+	sort.Slice(all, func(a, b int) bool { return all[a].Started.After(all[b].Started) })
+
+	list := make([]map[string]any, len(all))
+	for i, j := range all {
+		j.mu.Lock()
+		list[i] = map[string]any{
+			"id": j.ID, "kind": j.Kind, "provider": j.Provider, "connection_id": j.ConnectionID,
+			"state": j.State, "phase": j.Phase, "total": j.Total, "done": j.Done, "failed_count": j.FailedCount,
+			"started_at": j.Started, "finished_at": j.Finished,
+		}
+		j.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// JobByID handles GET /api/jobs/{id}, returning the job's progress, and
+// DELETE, which cancels it. Keys already processed stay processed.
+func JobByID(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+	jobs.Lock()
+	j := jobs.m[id]
+	jobs.Unlock()
+	if j == nil {
+		writeError(w, storage.CodeNotFound, "job not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJob(w, j)
+	case http.MethodDelete:
+		j.cancel()
+		j.wait(r.Context(), 5*time.Second)
+		writeJob(w, j)
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+	}
+}
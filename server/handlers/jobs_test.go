@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	appdb "github.com/PandhuWibowo/oss-portable/db"
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// restartJobs undoes ShutdownJobs for the tests that follow.
+func restartJobs(t *testing.T) {
+	t.Cleanup(func() {
+		jobs.Lock()
+		jobs.ctx, jobs.stop = context.WithCancelCause(context.Background())
+		jobs.Unlock()
+	})
+}
+
+// stalled is a backend whose Walk blocks until the context is canceled.
+type stalled struct{ storage.Backend }
+
+func (stalled) Walk(ctx context.Context, _ string, _ func(storage.Object) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (stalled) Close() error { return nil }
+
+func insertMove(t *testing.T, m *move) {
+	t.Helper()
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := appdb.Exec(
+		"INSERT INTO moves (id, provider, connection_id, source, destination, overwrite, state, error, owner, lease_expires, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		m.ID, m.Provider, m.ConnectionID, m.Source, m.Destination, m.Overwrite, jobRunning, "", moveOwner, leaseExpiry(moveLease), now, now,
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func moveState(t *testing.T, id string) string {
+	t.Helper()
+	var state string
+	if err := appdb.QueryRow("SELECT state FROM moves WHERE id = ?", id).Scan(&state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// moveOwnerOf returns the owner of a move and whether it holds a lease.
+func moveOwnerOf(t *testing.T, id string) (string, bool) {
+	t.Helper()
+	var owner string
+	var lease sql.NullString
+	if err := appdb.QueryRow("SELECT owner, lease_expires FROM moves WHERE id = ?", id).Scan(&owner, &lease); err != nil {
+		t.Fatal(err)
+	}
+	return owner, lease.Valid
+}
+
+func TestShutdownJobs(t *testing.T) {
+	restartJobs(t)
+
+	quick := startJob(newJobID(), "delete", "memory", 0, func(ctx context.Context, j *job) error {
+		time.Sleep(10 * time.Millisecond)
+		j.finish("a", nil)
+		return nil
+	})
+	slow := startJob(newJobID(), "delete", "memory", 0, func(ctx context.Context, j *job) error {
+		j.setTotal(3)
+		j.finish("a", nil)
+		<-ctx.Done()
+		return nil
+	})
+	canceledMove := &move{ID: newJobID(), Provider: "memory", Source: "a/", Destination: "b/"}
+	interruptedMove := &move{ID: newJobID(), Provider: "memory", Source: "c/", Destination: "d/"}
+	insertMove(t, canceledMove)
+	insertMove(t, interruptedMove)
+	userCanceled := canceledMove.start(stalled{})
+	interrupted := interruptedMove.start(stalled{})
+
+	// A move canceled by its user ends as canceled.
+	userCanceled.cancel()
+	<-userCanceled.finished
+	if got := moveState(t, canceledMove.ID); got != jobCanceled {
+		t.Errorf("move canceled by its user is %s, want %s", got, jobCanceled)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ShutdownJobs(ctx)
+
+	for _, c := range []struct {
+		name  string
+		j     *job
+		state string
+		done  int64
+		err   string
+	}{
+		{"quick", quick, jobDone, 1, ""},
+		{"slow", slow, jobCanceled, 1, errShuttingDown.Error()},
+		{"move", interrupted, jobCanceled, 0, errShuttingDown.Error()},
+	} {
+		select {
+		case <-c.j.finished:
+		default:
+			t.Errorf("%s job still running after ShutdownJobs", c.name)
+			continue
+		}
+		c.j.mu.Lock()
+		if c.j.State != c.state || c.j.Done != c.done || c.j.Error != c.err {
+			t.Errorf("%s job: state %s, done %d, error %q; want %s, %d, %q",
+				c.name, c.j.State, c.j.Done, c.j.Error, c.state, c.done, c.err)
+		}
+		c.j.mu.Unlock()
+	}
+	// The interrupted move is left for ResumeMoves, with its lease released.
+	if got := moveState(t, interruptedMove.ID); got != jobRunning {
+		t.Errorf("move interrupted by shutdown is %s, want %s", got, jobRunning)
+	}
+	if owner, _ := moveOwnerOf(t, interruptedMove.ID); owner != "" {
+		t.Errorf("move interrupted by shutdown is still owned by %q", owner)
+	}
+	if _, err := appdb.Exec("DELETE FROM moves"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoveLease(t *testing.T) {
+	restartJobs(t)
+	t.Cleanup(func() { _, _ = appdb.Exec("DELETE FROM moves") })
+	past := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+	future := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+
+	// Only moves without a live lease are resumed. There is no connection 0,
+	// so the ones claimed fail at once.
+	leased := &move{ID: newJobID(), Provider: "memory", Source: "a/", Destination: "b/"}
+	expired := &move{ID: newJobID(), Provider: "memory", Source: "c/", Destination: "d/"}
+	released := &move{ID: newJobID(), Provider: "memory", Source: "e/", Destination: "f/"}
+	for _, c := range []struct {
+		m     *move
+		owner string
+		lease any
+	}{
+		{leased, "other", future},
+		{expired, "other", past},
+		{released, "", nil},
+	} {
+		insertMove(t, c.m)
+		if _, err := appdb.Exec("UPDATE moves SET owner = ?, lease_expires = ? WHERE id = ?", c.owner, c.lease, c.m.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resumeMoves()
+	if owner, _ := moveOwnerOf(t, leased.ID); owner != "other" || moveState(t, leased.ID) != jobRunning {
+		t.Errorf("leased move was taken over: owner %q, state %s", owner, moveState(t, leased.ID))
+	}
+	for _, m := range []*move{expired, released} {
+		if owner, _ := moveOwnerOf(t, m.ID); owner != moveOwner || moveState(t, m.ID) != jobFailed {
+			t.Errorf("move %s of %s: owner %q, state %s; want it claimed", m.ID, m.Source, owner, moveState(t, m.ID))
+		}
+	}
+
+	// Of two claims on a move, one wins.
+	contested := &move{ID: newJobID(), Provider: "memory", Source: "g/", Destination: "h/"}
+	insertMove(t, contested)
+	if err := contested.release(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := contested.claim(); !ok || err != nil {
+		t.Fatalf("first claim = %v, %v; want it won", ok, err)
+	}
+	if ok, err := contested.claim(); ok || err != nil {
+		t.Fatalf("second claim = %v, %v; want it lost", ok, err)
+	}
+
+	// A move stops once another replica has taken it over, and leaves the
+	// row to that replica.
+	lease := moveLease
+	moveLease = 40 * time.Millisecond
+	t.Cleanup(func() { moveLease = lease })
+	lost := &move{ID: newJobID(), Provider: "memory", Source: "i/", Destination: "j/"}
+	insertMove(t, lost)
+	j := lost.start(stalled{})
+	if _, err := appdb.Exec("UPDATE moves SET owner = 'other' WHERE id = ?", lost.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !j.wait(context.Background(), 5*time.Second) {
+		t.Fatal("move still running after losing its lease")
+	}
+	j.mu.Lock()
+	state, errText := j.State, j.Error
+	j.mu.Unlock()
+	if state != jobFailed || errText != errMoveLeaseLost.Error() {
+		t.Errorf("move that lost its lease: state %s, error %q", state, errText)
+	}
+	if got := moveState(t, lost.ID); got != jobRunning {
+		t.Errorf("move taken over is %s in the table, want %s", got, jobRunning)
+	}
+}
+
+// copyOnly hides the memory provider's Rename, so moves copy and verify.
+type copyOnly struct{ storage.Backend }
+
+func TestMoveExistingDestination(t *testing.T) {
+	restartJobs(t)
+	t.Cleanup(func() { _, _ = appdb.Exec("DELETE FROM moves") })
+	id := memoryConnection(t, map[string]string{"src/a.txt": "hello", "src/b.txt": "b", "dst/a.txt": "hello"})
+	run := func(resumed bool) *job {
+		t.Helper()
+		b, err := storage.Open(t.Context(), "memory", strings.NewReplacer("/", "-", " ", "-").Replace(t.Name()), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &move{ID: newJobID(), Provider: "memory", ConnectionID: id, Source: "src/", Destination: "dst/", Resumed: resumed}
+		insertMove(t, m)
+		j := m.start(copyOnly{b})
+		<-j.finished
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		return j
+	}
+
+	// On a first run a matching destination could be anything, and is a
+	// conflict.
+	j := run(false)
+	if j.Done != 1 || j.FailedCount != 1 || j.Failures[0].Key != "src/a.txt" || j.Failures[0].Code != storage.CodeConflict {
+		t.Errorf("first run: %d done, failures %+v; want src/a.txt in conflict", j.Done, j.Failures)
+	}
+	if got := strings.Join(bucketKeys(t), ","); got != "dst/a.txt,dst/b.txt,src/a.txt" {
+		t.Errorf("after the first run: %s", got)
+	}
+
+	// A resumed move takes it for its own earlier copy.
+	j = run(true)
+	if j.Done != 1 || j.FailedCount != 0 {
+		t.Errorf("resumed run: %d done, failures %+v; want src/a.txt moved", j.Done, j.Failures)
+	}
+	if got := strings.Join(bucketKeys(t), ","); got != "dst/a.txt,dst/b.txt" {
+		t.Errorf("after the resumed run: %s", got)
+	}
+}
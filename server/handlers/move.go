@@ -0,0 +1,479 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	appdb "github.com/PandhuWibowo/oss-portable/db"
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// Folder moves run as jobs in two phases. First every object under the
+// source is copied to the destination and the copy checked against the
+// original; then the sources of the verified copies are deleted, in batches
+// where the provider has them. Backends that can rename (local, SFTP,
+// WebDAV, memory) rename each object instead.
+//
+// A move keeps no list of what it has done: whatever it moved is no longer
+// under the source, and when a move is resumed, a destination object that
+// matches its source counts as copied. So an interrupted move continues by
+// running again. Moves are recorded in the moves table, and those still
+// running when the server stopped are restarted by ResumeMoves.
+//
+// The replica running a move holds a lease on its row, which it renews while
+// the move runs and releases when the server shuts down. ResumeMoves only
+// takes over moves whose lease has been released or has run out, claiming
+// each with a conditional update, so two replicas never run the same move.
+
+// moveConcurrency is how many objects a move copies at a time.
+const moveConcurrency = 8
+
+// moveLease is how long a replica's claim on a move lasts without being
+// renewed. Running moves renew it every quarter of that, and ResumeMoves
+// looks for moves to take over as often.
+var moveLease = 2 * time.Minute
+
+// moveOwner identifies this process in the owner column of the moves it
+// runs.
+var moveOwner = newJobID()
+
+// errMoveLeaseLost ends a move that another replica has taken over.
+var errMoveLeaseLost = errors.New("another replica took over the move")
+
+// move is a row of the moves table.
+type move struct {
+	ID           string
+	Provider     string
+	ConnectionID int64
+	Source       string
+	Destination  string
+	Overwrite    bool
+	// Resumed is set on a move taken over by ResumeMoves, whose earlier run
+	// may have left copies at the destination.
+	Resumed bool
+}
+
+// setState records how the move ended, unless it is no longer ours.
+func (m *move) setState(state, errText string) error {
+	_, err := appdb.Exec(
+		"UPDATE moves SET state = ?, error = ?, updated_at = ? WHERE id = ? AND owner = ?",
+		state, errText, time.Now().UTC().Format(time.RFC3339), m.ID, moveOwner,
+	)
+	return err
+}
+
+func leaseExpiry(lease time.Duration) string {
+	return time.Now().UTC().Add(lease).Format(time.RFC3339)
+}
+
+// claim takes over a running move whose lease was released or has run out,
+// and reports whether it did: of several replicas claiming the same move,
+// one wins.
+func (m *move) claim() (bool, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := appdb.Exec(
+		"UPDATE moves SET owner = ?, lease_expires = ?, updated_at = ? WHERE id = ? AND state = ? AND (lease_expires IS NULL OR lease_expires < ?)",
+		moveOwner, leaseExpiry(moveLease), now, m.ID, jobRunning, now,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// renew extends the lease on the move, and reports false once the move is
+// no longer ours.
+func (m *move) renew(lease time.Duration) (bool, error) {
+	res, err := appdb.Exec(
+		"UPDATE moves SET lease_expires = ? WHERE id = ? AND owner = ? AND state = ?",
+		leaseExpiry(lease), m.ID, moveOwner, jobRunning,
+	)
+	if err != nil {
+		return true, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1 || err != nil, err
+}
+
+// release gives up the lease on a move left running, so that the next
+// replica to look can resume it at once.
+func (m *move) release() error {
+	_, err := appdb.Exec(
+		"UPDATE moves SET owner = '', lease_expires = NULL WHERE id = ? AND owner = ?", m.ID, moveOwner,
+	)
+	return err
+}
+
+// heartbeat renews the lease on the move until ctx is done. If another
+// replica has taken the move over, it stops the move with errMoveLeaseLost.
+func (m *move) heartbeat(ctx context.Context, stop context.CancelCauseFunc, lease time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(lease / 4):
+		}
+		ours, err := m.renew(lease)
+		if err != nil {
+			// The lease outlasts a few failed renewals.
+			log.Printf("move %s: renewing its lease: %v", m.ID, err)
+		}
+		if !ours {
+			stop(errMoveLeaseLost)
+			return
+		}
+	}
+}
+
+// start runs the move as a job with the move's ID, recording its outcome.
+func (m *move) start(backend storage.Backend) *job {
+	lease := moveLease
+	return startJob(m.ID, "move", m.Provider, m.ConnectionID, func(ctx context.Context, j *job) error {
+		defer backend.Close()
+		runCtx, stop := context.WithCancelCause(ctx)
+		defer stop(nil)
+		go m.heartbeat(runCtx, stop, lease)
+		err := m.run(runCtx, backend, j)
+		lost := errors.Is(context.Cause(runCtx), errMoveLeaseLost)
+		stop(nil)
+		state, errText := jobDone, ""
+		switch {
+		case shuttingDown(ctx):
+			// Still running as far as the table goes: the next start, or
+			// another replica, resumes it.
+			if err := m.release(); err != nil {
+				log.Printf("move %s: %v", m.ID, err)
+			}
+			return err
+		case lost:
+			// The table is the other replica's to update now.
+			return errMoveLeaseLost
+		case ctx.Err() != nil:
+			state = jobCanceled
+		case err != nil:
+			state, errText = jobFailed, err.Error()
+		}
+		if err := m.setState(state, errText); err != nil {
+			log.Printf("move %s: %v", m.ID, err)
+		}
+		return err
+	})
+}
+
+func (m *move) run(ctx context.Context, backend storage.Backend, j *job) error {
+	j.setPhase("listing")
+	var sources []storage.Object
+	err := backend.Walk(ctx, m.Source, func(o storage.Object) error {
+		sources = append(sources, o)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	err = backend.Walk(ctx, m.Destination, func(o storage.Object) error {
+		existing[o.Name] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	j.setTotal(int64(len(sources)))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		sem      = make(chan struct{}, moveConcurrency)
+		verified []string
+	)
+	renamer, _ := backend.(storage.Renamer)
+	if renamer != nil {
+		j.setPhase("moving")
+	} else {
+		j.setPhase("copying")
+	}
+	for _, o := range sources {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			dst := m.Destination + strings.TrimPrefix(o.Name, m.Source)
+			copied, err := m.copy(ctx, backend, renamer, o.Name, dst, existing[dst])
+			switch {
+			case err != nil:
+				if ctx.Err() == nil {
+					j.finish(o.Name, err)
+				}
+			case copied:
+				mu.Lock()
+				verified = append(verified, o.Name)
+				mu.Unlock()
+			default:
+				j.finish(o.Name, nil)
+			}
+		}()
+	}
+	wg.Wait()
+	if renamer != nil || ctx.Err() != nil {
+		return nil
+	}
+
+	j.setPhase("deleting")
+	deleteKeys(ctx, backend, j, verified)
+	return nil
+}
+
+// copy puts src at dst and reports whether src is left to delete: true
+// once dst holds a verified copy, false when it was renamed or had been
+// moved already. A dst that exists already is a conflict, unless the move
+// overwrites or is resumed and dst matches src, as a copy made before the
+// move was interrupted does. verifyCopy compares only sizes where neither
+// side has an MD5, so on a first run a matching dst is no evidence of
+// anything and is left alone.
+func (m *move) copy(ctx context.Context, backend storage.Backend, renamer storage.Renamer, src, dst string, exists bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeouts.Copy)
+	defer cancel()
+	if exists && !m.Overwrite {
+		if renamer != nil || !m.Resumed {
+			return false, moveConflict(dst)
+		}
+		if _, err := verifyCopy(ctx, backend, src, dst); err != nil {
+			var mismatch *copyMismatch
+			if errors.As(err, &mismatch) {
+				return false, moveConflict(dst)
+			}
+			return false, m.moved(ctx, backend, dst, err)
+		}
+		return true, nil
+	}
+	if renamer != nil {
+		return false, m.moved(ctx, backend, dst, renamer.Rename(ctx, src, dst))
+	}
+	if err := backend.Copy(ctx, src, dst); err != nil {
+		return false, m.moved(ctx, backend, dst, err)
+	}
+	if _, err := verifyCopy(ctx, backend, src, dst); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// moved filters out err when it says src is gone while dst exists: an
+// earlier run of the move got there first, between listing and copying.
+func (m *move) moved(ctx context.Context, backend storage.Backend, dst string, err error) error {
+	if err == nil || storage.Classify(m.Provider, err).Code != storage.CodeNotFound {
+		return err
+	}
+	if _, statErr := backend.Stat(ctx, dst); statErr != nil {
+		return err
+	}
+	return nil
+}
+
+func moveConflict(dst string) error {
+	return &storage.Error{Code: storage.CodeConflict, Err: fmt.Errorf("%s already exists", dst)}
+}
+
+// copyMismatch is a copy that differs from its source.
+type copyMismatch struct{ reason string }
+
+func (e *copyMismatch) Error() string { return "the copy does not match its source: " + e.reason }
+
+// verifyCopy checks that dst has the size of src and, where both have one,
+// the same MD5, or the same ETag when the provider's ETags are MD5s of the
+// content (S3 objects not uploaded in parts). Other ETags name a version of
+// one object and say nothing about the content.
+func verifyCopy(ctx context.Context, backend storage.Backend, src, dst string) (*storage.Metadata, error) {
+	a, err := backend.Stat(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	b, err := backend.Stat(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case a.Size != b.Size:
+		return nil, &copyMismatch{fmt.Sprintf("%d bytes instead of %d", b.Size, a.Size)}
+	case a.MD5 != "" && b.MD5 != "" && a.MD5 != b.MD5:
+		return nil, &copyMismatch{"the MD5 differs"}
+	case isMD5ETag(a.ETag) && isMD5ETag(b.ETag) && !strings.EqualFold(a.ETag, b.ETag):
+		return nil, &copyMismatch{"the ETag differs"}
+	}
+	return b, nil
+}
+
+func isMD5ETag(etag string) bool {
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != 32 {
+		return false
+	}
+	for _, c := range etag {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// MovePrefix handles POST /api/{provider}/bucket/move, which moves or
+// renames a folder: every object under source goes to the same path under
+// destination. Objects whose destination exists already are left where they
+// are and reported as conflicts, unless overwrite is set.
+//
+// The move runs as a job (see DeleteObjects for the reply). If a move of
+// the same folders is running, its job is returned instead of starting
+// another.
+func MovePrefix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		ConnectionID int64  `json:"connection_id"`
+		Source       string `json:"source"`
+		Destination  string `json:"destination"`
+		Overwrite    bool   `json:"overwrite"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	switch {
+	case req.Source == "" || req.Destination == "":
+		badRequest(w, "source and destination are required")
+		return
+	case !strings.HasSuffix(req.Source, "/") || !strings.HasSuffix(req.Destination, "/"):
+		badRequest(w, `source and destination must end with "/"`)
+		return
+	case strings.HasPrefix(req.Destination, req.Source) || strings.HasPrefix(req.Source, req.Destination):
+		badRequest(w, "source and destination must not contain each other")
+		return
+	}
+	provider := providerFromPath(r.URL.Path)
+
+	var running string
+	err := appdb.QueryRow(
+		"SELECT id FROM moves WHERE provider = ? AND connection_id = ? AND source = ? AND destination = ? AND state = ?",
+		provider, req.ConnectionID, req.Source, req.Destination, jobRunning,
+	).Scan(&running)
+	if err == nil {
+		jobs.Lock()
+		j := jobs.m[running]
+		jobs.Unlock()
+		if j != nil {
+			writeJob(w, j)
+			return
+		}
+	}
+
+	backend, ok := openBackend(context.Background(), w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	m := &move{
+		ID:           newJobID(),
+		Provider:     provider,
+		ConnectionID: req.ConnectionID,
+		Source:       req.Source,
+		Destination:  req.Destination,
+		Overwrite:    req.Overwrite,
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := appdb.Exec(
+		"INSERT INTO moves (id, provider, connection_id, source, destination, overwrite, state, error, owner, lease_expires, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		m.ID, m.Provider, m.ConnectionID, m.Source, m.Destination, m.Overwrite, jobRunning, "", moveOwner, leaseExpiry(moveLease), now, now,
+	); err != nil {
+		backend.Close()
+		internalError(w, err)
+		return
+	}
+	j := m.start(backend)
+	j.wait(r.Context(), cfg.Timeouts.Copy)
+	writeJob(w, j)
+}
+
+// ResumeMoves restarts the moves that were running when the server
+// stopped, keeping their job IDs. It looks again every moveLease until the
+// server shuts down, for moves left by a replica that stopped without
+// releasing them.
+func ResumeMoves() {
+	jobs.Lock()
+	done := jobs.ctx.Done()
+	jobs.Unlock()
+	for {
+		resumeMoves()
+		select {
+		case <-done:
+			return
+		case <-time.After(moveLease):
+		}
+	}
+}
+
+func resumeMoves() {
+	rows, err := appdb.Query(
+		"SELECT id, provider, connection_id, source, destination, overwrite FROM moves WHERE state = ? AND (lease_expires IS NULL OR lease_expires < ?)",
+		jobRunning, time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		log.Printf("resuming moves: %v", err)
+		return
+	}
+	var moves []*move
+	for rows.Next() {
+		m := &move{Resumed: true}
+		if err := rows.Scan(&m.ID, &m.Provider, &m.ConnectionID, &m.Source, &m.Destination, &m.Overwrite); err == nil {
+			moves = append(moves, m)
+		}
+	}
+	rows.Close()
+
+	for _, m := range moves {
+		jobs.Lock()
+		j := jobs.m[m.ID]
+		jobs.Unlock()
+		if j != nil {
+			select {
+			case <-j.finished:
+			default:
+				continue // ours, and its lease lapsed while the database was unreachable
+			}
+		}
+		claimed, err := m.claim()
+		if err != nil {
+			log.Printf("resuming move %s: %v", m.ID, err)
+			continue
+		}
+		if !claimed {
+			continue // another replica got there first
+		}
+		bucket, credentials, err := loadConnection(m.Provider, m.ConnectionID)
+		var backend storage.Backend
+		if err == nil {
+			backend, err = storage.Open(context.Background(), m.Provider, bucket, credentials)
+		}
+		if err != nil {
+			log.Printf("resuming move %s: %v", m.ID, err)
+			if err := m.setState(jobFailed, err.Error()); err != nil {
+				log.Printf("move %s: %v", m.ID, err)
+			}
+			continue
+		}
+		log.Printf("resuming move %s of %s to %s", m.ID, m.Source, m.Destination)
+		m.start(backend)
+	}
+}
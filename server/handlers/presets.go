@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// ListS3Presets handles GET /api/aws/presets, the catalog of S3-compatible
+// services an aws connection can select with its "preset" credential.
+func ListS3Presets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(storage.S3Presets())
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// Direct uploads send the bytes from the browser straight to the bucket.
+// PresignUpload hands out a signed PUT URL (or POST form) together with a
+// token; once the upload is done the client posts the token to
+// CompleteUpload, which checks that the object arrived with the size and
+// content type that were signed for.
+
+// uploadURLTTL is how long a presigned upload URL is valid. Completion is
+// accepted for timeouts.upload after that.
+const uploadURLTTL = 15 * time.Minute
+
+// uploadGrant is what an upload token vouches for.
+type uploadGrant struct {
+	ConnectionID int64  `json:"c"`
+	Object       string `json:"o"`
+	Size         int64  `json:"s"`
+	ContentType  string `json:"t"`
+	Method       string `json:"m"`
+	Issued       int64  `json:"i"`
+	Expires      int64  `json:"e"`
+}
+
+// uploadToken encodes g as base64 JSON followed by an HMAC of it under the
+// download link key.
+func uploadToken(provider string, g uploadGrant) string {
+	claims, _ := json.Marshal(g)
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + uploadTokenSignature(provider, payload)
+}
+
+func uploadTokenSignature(provider, payload string) string {
+	mac := hmac.New(sha256.New, signingKey())
+	fmt.Fprintf(mac, "upload\n%s\n%s", provider, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func parseUploadToken(provider, token string) (*uploadGrant, error) {
+	payload, sig, _ := strings.Cut(token, ".")
+	if !hmac.Equal([]byte(sig), []byte(uploadTokenSignature(provider, payload))) {
+		return nil, errors.New("invalid upload token")
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	var g uploadGrant
+	if err := json.Unmarshal(claims, &g); err != nil {
+		return nil, err
+	}
+	if time.Now().After(time.Unix(g.Expires, 0).Add(cfg.Timeouts.Upload)) {
+		return nil, errors.New("upload token has expired")
+	}
+	return &g, nil
+}
+
+// PresignUpload handles POST /api/{provider}/bucket/upload/presign. It
+// returns where to send the object and the token for CompleteUpload. With
+// method "PUT" (the default) the object must be exactly size bytes; with
+// "POST" size is the largest accepted. Providers that cannot sign uploads
+// reply 501 and the file should go through /bucket/upload instead.
+func PresignUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		ConnectionID int64  `json:"connection_id"`
+		Object       string `json:"object"`
+		Size         *int64 `json:"size"`
+		ContentType  string `json:"content_type"`
+		Method       string `json:"method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	switch {
+	case req.Object == "" || strings.HasSuffix(req.Object, "/"):
+		badRequest(w, "object must name a file")
+		return
+	case req.Size == nil || *req.Size < 0:
+		badRequest(w, "size is required")
+		return
+	case *req.Size > int64(cfg.MaxUploadSize):
+		writeError(w, codeTooLarge, fmt.Sprintf("file is larger than the %s upload limit", cfg.MaxUploadSize))
+		return
+	}
+	req.Method = strings.ToUpper(req.Method)
+	if req.Method == "" {
+		req.Method = http.MethodPut
+	}
+	if req.Method != http.MethodPut && req.Method != http.MethodPost {
+		badRequest(w, `method must be "PUT" or "POST"`)
+		return
+	}
+	if req.ContentType == "" {
+		req.ContentType = "application/octet-stream"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Download)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, req.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	provider := providerFromPath(r.URL.Path)
+	presigner, ok := backend.(storage.UploadPresigner)
+	if !ok {
+		writeStorageError(w, provider, fmt.Errorf("%w: direct uploads", storage.ErrNotSupported))
+		return
+	}
+	target, err := presigner.PresignUpload(ctx, storage.Upload{
+		Key:         req.Object,
+		ContentType: req.ContentType,
+		Size:        *req.Size,
+	}, req.Method, uploadURLTTL)
+	if err != nil {
+		writeStorageError(w, provider, err)
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(uploadURLTTL)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"upload":     target,
+		"object":     req.Object,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+		"token": uploadToken(provider, uploadGrant{
+			ConnectionID: req.ConnectionID,
+			Object:       req.Object,
+			Size:         *req.Size,
+			ContentType:  req.ContentType,
+			Method:       req.Method,
+			Issued:       now.Unix(),
+			Expires:      expires.Unix(),
+		}),
+	})
+}
+
+// CompleteUpload handles POST /api/{provider}/bucket/upload/complete, the
+// callback after a direct upload. It replies with the object's metadata if
+// the object is there with the signed size and content type. An object that
+// was written but does not match is deleted and reported as a conflict. The
+// check is a second line of defence: clients may skip this call, so only
+// providers whose signed URLs enforce the size and content type presign
+// uploads at all.
+func CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	provider := providerFromPath(r.URL.Path)
+	g, err := parseUploadToken(provider, req.Token)
+	if err != nil {
+		writeError(w, storage.CodeAccessDenied, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Metadata)
+	defer cancel()
+
+	backend, ok := openBackend(ctx, w, r, g.ConnectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	md, err := backend.Stat(ctx, g.Object)
+	if err != nil {
+		writeStorageError(w, provider, err)
+		return
+	}
+	// Allow for clock skew and providers that keep whole seconds.
+	if !md.Updated.IsZero() && md.Updated.Before(time.Unix(g.Issued, 0).Add(-time.Minute)) {
+		writeAPIError(w, apiError{
+			Code:    storage.CodeConflict,
+			Message: "the object has not been uploaded yet",
+			Details: map[string]any{"updated": md.Updated},
+		})
+		return
+	}
+
+	var problem string
+	switch {
+	case g.Method == http.MethodPut && md.Size != g.Size:
+		problem = fmt.Sprintf("object is %d bytes, expected %d", md.Size, g.Size)
+	case md.Size > g.Size:
+		problem = fmt.Sprintf("object is %d bytes, more than the %d allowed", md.Size, g.Size)
+	case md.ContentType != g.ContentType:
+		problem = fmt.Sprintf("object has content type %q, expected %q", md.ContentType, g.ContentType)
+	}
+	if problem != "" {
+		if err := backend.Delete(ctx, g.Object); err != nil {
+			writeStorageError(w, provider, err)
+			return
+		}
+		writeAPIError(w, apiError{
+			Code:    storage.CodeConflict,
+			Message: problem + "; it has been deleted",
+			Details: map[string]any{"size": md.Size, "content_type": md.ContentType},
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(md)
+}
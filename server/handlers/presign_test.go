@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// presignedBackend is a memory bucket that signs direct uploads. Nothing
+// listens at the URL it hands out; tests put the "uploaded" object into the
+// bucket themselves.
+type presignedBackend struct{ storage.Backend }
+
+func (presignedBackend) PresignUpload(_ context.Context, u storage.Upload, method string, _ time.Duration) (*storage.UploadTarget, error) {
+	return &storage.UploadTarget{Method: method, URL: "https://uploads.example.com/" + u.Key}, nil
+}
+
+func init() {
+	storage.Register("presigned", func(ctx context.Context, bucket, credentials string) (storage.Backend, error) {
+		b, err := storage.Open(ctx, "memory", bucket, credentials)
+		if err != nil {
+			return nil, err
+		}
+		return presignedBackend{b}, nil
+	})
+}
+
+func TestDirectUpload(t *testing.T) {
+	bucket := strings.ReplaceAll(t.Name(), "/", "-")
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	decode(t, call(t, CreateConnection, "/api/presigned/connection", map[string]string{"name": t.Name(), "bucket": bucket}), http.StatusOK, &created)
+	id := created.ID
+	b, err := storage.Open(t.Context(), "memory", bucket, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	// put stands in for the browser's upload to the signed URL.
+	put := func(key, body, contentType string) {
+		t.Helper()
+		if err := b.Put(t.Context(), key, strings.NewReader(body), int64(len(body)), contentType); err != nil {
+			t.Fatal(err)
+		}
+	}
+	presign := func(object string, size int64, contentType string) string {
+		t.Helper()
+		var resp struct {
+			Upload storage.UploadTarget `json:"upload"`
+			Token  string               `json:"token"`
+		}
+		decode(t, call(t, PresignUpload, "/api/presigned/bucket/upload/presign", map[string]any{
+			"connection_id": id, "object": object, "size": size, "content_type": contentType,
+		}), http.StatusOK, &resp)
+		if resp.Upload.Method != http.MethodPut || resp.Token == "" {
+			t.Fatalf("presign %s: %+v", object, resp)
+		}
+		return resp.Token
+	}
+	complete := func(token string) *httptest.ResponseRecorder {
+		return call(t, CompleteUpload, "/api/presigned/bucket/upload/complete", map[string]string{"token": token})
+	}
+	gone := func(key string) {
+		t.Helper()
+		if _, err := b.Stat(t.Context(), key); storage.Classify("memory", err).Code != storage.CodeNotFound {
+			t.Errorf("%s was not deleted: %v", key, err)
+		}
+	}
+
+	t.Run("matching", func(t *testing.T) {
+		token := presign("ok.txt", 5, "text/plain")
+		put("ok.txt", "hello", "text/plain")
+		var md storage.Metadata
+		decode(t, complete(token), http.StatusOK, &md)
+		if md.Size != 5 || md.ContentType != "text/plain" {
+			t.Errorf("metadata %+v", md)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		expectError(t, complete(presign("missing.txt", 5, "text/plain")), http.StatusNotFound, storage.CodeNotFound)
+	})
+
+	t.Run("size mismatch", func(t *testing.T) {
+		token := presign("short.txt", 5, "text/plain")
+		put("short.txt", "hel", "text/plain")
+		expectError(t, complete(token), http.StatusConflict, storage.CodeConflict)
+		gone("short.txt")
+	})
+
+	t.Run("content type mismatch", func(t *testing.T) {
+		token := presign("typed.txt", 5, "text/plain")
+		put("typed.txt", "hello", "text/html")
+		expectError(t, complete(token), http.StatusConflict, storage.CodeConflict)
+		gone("typed.txt")
+	})
+
+	t.Run("not uploaded yet", func(t *testing.T) {
+		// The object is older than the grant: what is there is not the
+		// upload, and is kept.
+		put("old.txt", "hello", "text/plain")
+		now := time.Now()
+		token := uploadToken("presigned", uploadGrant{
+			ConnectionID: id, Object: "old.txt", Size: 5, ContentType: "text/plain", Method: http.MethodPut,
+			Issued: now.Add(5 * time.Minute).Unix(), Expires: now.Add(uploadURLTTL).Unix(),
+		})
+		expectError(t, complete(token), http.StatusConflict, storage.CodeConflict)
+		if _, err := b.Stat(t.Context(), "old.txt"); err != nil {
+			t.Errorf("the older object was deleted: %v", err)
+		}
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		put("late.txt", "hello", "text/plain")
+		issued := time.Now().Add(-uploadURLTTL - cfg.Timeouts.Upload - time.Minute)
+		token := uploadToken("presigned", uploadGrant{
+			ConnectionID: id, Object: "late.txt", Size: 5, ContentType: "text/plain", Method: http.MethodPut,
+			Issued: issued.Unix(), Expires: issued.Add(uploadURLTTL).Unix(),
+		})
+		expectError(t, complete(token), http.StatusForbidden, storage.CodeAccessDenied)
+	})
+
+	t.Run("tampered token", func(t *testing.T) {
+		token := presign("t.txt", 5, "text/plain")
+		put("t.txt", "hello", "text/plain")
+		payload, sig, _ := strings.Cut(token, ".")
+		other := presign("u.txt", 5, "text/plain")
+		otherPayload, _, _ := strings.Cut(other, ".")
+		for _, c := range []struct{ name, provider, token string }{
+			{"signature", "presigned", payload + "." + strings.Repeat("0", len(sig))},
+			{"payload", "presigned", otherPayload + "." + sig},
+			{"unsigned", "presigned", payload},
+			{"other provider", "memory", token},
+		} {
+			w := call(t, CompleteUpload, "/api/"+c.provider+"/bucket/upload/complete", map[string]string{"token": c.token})
+			t.Run(c.name, func(t *testing.T) { expectError(t, w, http.StatusForbidden, storage.CodeAccessDenied) })
+		}
+	})
+
+	t.Run("presign", func(t *testing.T) {
+		expectError(t, call(t, PresignUpload, "/api/presigned/bucket/upload/presign", map[string]any{
+			"connection_id": id, "object": "big.bin", "size": int64(cfg.MaxUploadSize) + 1,
+		}), http.StatusRequestEntityTooLarge, codeTooLarge)
+		expectError(t, call(t, PresignUpload, "/api/presigned/bucket/upload/presign", map[string]any{
+			"connection_id": id, "object": "dir/", "size": 1,
+		}), http.StatusBadRequest, storage.CodeInvalid)
+		// Backends that cannot sign uploads send the client to /bucket/upload.
+		memory := memoryConnection(t, nil)
+		expectError(t, call(t, PresignUpload, "/api/memory/bucket/upload/presign", map[string]any{
+			"connection_id": memory, "object": "a.txt", "size": 1,
+		}), http.StatusNotImplemented, storage.CodeNotSupported)
+	})
+}
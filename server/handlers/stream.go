@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PandhuWibowo/oss-portable/secrets"
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// Providers without presigned URLs (local disk, SFTP, …) get download links
+// that point back at this server. The link carries an expiry and an HMAC
+// over the connection and object, so it can be opened by a plain <a href>
+// or shared for a short time like a presigned URL.
+
+var (
+	linkKeyOnce sync.Once
+	linkKey     []byte
+)
+
+// signingKey is derived from the master key, so links signed by one replica
+// verify on the others. Without a master key a per-process key is used and
+// links stop working when the server restarts.
+func signingKey() []byte {
+	linkKeyOnce.Do(func() {
+		linkKey = secrets.Current().DeriveKey("download-links")
+		if linkKey == nil {
+			linkKey = make([]byte, 32)
+			_, _ = rand.Read(linkKey)
+		}
+	})
+	return linkKey
+}
+
+func linkSignature(provider string, connectionID int64, object string, expires int64) string {
+	mac := hmac.New(sha256.New, signingKey())
+	fmt.Fprintf(mac, "%s\n%d\n%s\n%d", provider, connectionID, object, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// streamURL returns a signed link to StreamObject.
+func streamURL(provider string, connectionID int64, object string, ttl time.Duration) string {
+	expires := time.Now().Add(ttl).Unix()
+	q := url.Values{}
+	q.Set("connection_id", strconv.FormatInt(connectionID, 10))
+	q.Set("object", object)
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", linkSignature(provider, connectionID, object, expires))
+	return "/api/" + provider + "/bucket/stream?" + q.Encode()
+}
+
+// StreamObject handles GET and HEAD /api/{provider}/bucket/stream, serving
+// the object through the server for a link issued by DownloadURL. It honours
+// a single-range Range header (with If-Range), so players can seek and
+// download managers can resume, and answers If-None-Match and
+// If-Modified-Since with 304. Bytes are copied to the client as it reads
+// them; a slow client slows the read from the provider rather than filling
+// memory.
+func StreamObject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	provider := providerFromPath(r.URL.Path)
+	q := r.URL.Query()
+	connectionID, err := strconv.ParseInt(q.Get("connection_id"), 10, 64)
+	if err != nil {
+		badRequest(w, "invalid connection_id")
+		return
+	}
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		badRequest(w, "invalid expires")
+		return
+	}
+	object := q.Get("object")
+	want := linkSignature(provider, connectionID, object, expires)
+	if !hmac.Equal([]byte(want), []byte(q.Get("sig"))) {
+		writeError(w, storage.CodeAccessDenied, "invalid download link signature")
+		return
+	}
+	if time.Now().Unix() > expires {
+		writeError(w, storage.CodeAccessDenied, "download link has expired")
+		return
+	}
+
+	ctx := r.Context()
+	backend, ok := openBackend(ctx, w, r, connectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	md, err := backend.Stat(ctx, object)
+	if err != nil {
+		writeStorageError(w, provider, err)
+		return
+	}
+
+	h := w.Header()
+	etag := ""
+	if md.ETag != "" {
+		etag = `"` + strings.Trim(md.ETag, `"`) + `"`
+		h.Set("ETag", etag)
+	}
+	if !md.Updated.IsZero() {
+		h.Set("Last-Modified", md.Updated.UTC().Format(http.TimeFormat))
+	}
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Cache-Control", "private, no-cache")
+	if notModified(r, etag, md.Updated) {
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	contentType := md.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(object)}))
+	// The object is not ours to trust; never let a browser run it as a page
+	// of this origin.
+	h.Set("Content-Security-Policy", "sandbox")
+
+	status, offset, length := http.StatusOK, int64(0), md.Size
+	if rng := r.Header.Get("Range"); rng != "" && ifRangeMatches(r, etag, md.Updated) {
+		start, end, ok := parseRange(rng, md.Size)
+		switch {
+		case !ok:
+			// Malformed or multiple ranges: send the whole object.
+		case start >= md.Size:
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", md.Size))
+			writeError(w, codeRangeNotSatisfiable, "range not satisfiable")
+			return
+		default:
+			status, offset, length = http.StatusPartialContent, start, end-start+1
+			h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, md.Size))
+		}
+	}
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodHead || length == 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	var rc io.ReadCloser
+	if status == http.StatusPartialContent {
+		rc, err = storage.OpenRange(ctx, backend, object, offset, length)
+	} else {
+		rc, err = backend.Open(ctx, object)
+	}
+	if err != nil {
+		for _, k := range []string{"Content-Range", "Content-Length", "Content-Disposition"} {
+			h.Del(k)
+		}
+		writeStorageError(w, provider, err)
+		return
+	}
+	defer rc.Close()
+	w.WriteHeader(status)
+	buf := make([]byte, 256<<10)
+	_, _ = io.CopyBuffer(w, io.LimitReader(rc, length), buf)
+}
+
+// parseRange parses a Range header with one byte range against an object of
+// size bytes and returns the inclusive bounds. start >= size means the range
+// cannot be satisfied; ok is false for anything else it does not handle.
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+	if first == "" {
+		// Suffix range: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if size == 0 {
+			return 0, 0, true
+		}
+		return max(size-n, 0), size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is
+// none.
+func notModified(r *http.Request, etag string, updated time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == "*" || t == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !updated.IsZero() {
+		return !updated.Truncate(time.Second).After(ims)
+	}
+	return false
+}
+
+// ifRangeMatches reports whether a Range header applies: there is no
+// If-Range, or it names the current ETag or modification time.
+func ifRangeMatches(r *http.Request, etag string, updated time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		return etag != "" && ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !updated.IsZero() && updated.Truncate(time.Second).Equal(t)
+}
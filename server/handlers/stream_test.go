@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamObject(t *testing.T) {
+	id := memoryConnection(t, map[string]string{"clips/video.bin": "0123456789"})
+	link := streamURL("memory", id, "clips/video.bin", time.Minute)
+	stream := func(method, target string, headers ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		StreamObject(w, r)
+		return w
+	}
+
+	full := stream(http.MethodGet, link)
+	etag, modified := full.Header().Get("ETag"), full.Header().Get("Last-Modified")
+	if full.Code != http.StatusOK || full.Body.String() != "0123456789" || etag == "" || modified == "" ||
+		full.Header().Get("Accept-Ranges") != "bytes" || full.Header().Get("Content-Length") != "10" {
+		t.Fatalf("GET: status %d, body %q, headers %v", full.Code, full.Body, full.Header())
+	}
+	if cd := full.Header().Get("Content-Disposition"); cd != `attachment; filename=video.bin` {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+	stale := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	later := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	for _, c := range []struct {
+		name    string
+		method  string
+		headers []string
+		status  int
+		body    string
+		rng     string // Content-Range
+	}{
+		{"open-ended range", http.MethodGet, []string{"Range", "bytes=0-"}, http.StatusPartialContent, "0123456789", "bytes 0-9/10"},
+		{"range", http.MethodGet, []string{"Range", "bytes=2-4"}, http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"range past the end", http.MethodGet, []string{"Range", "bytes=8-20"}, http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"suffix", http.MethodGet, []string{"Range", "bytes=-3"}, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"suffix longer than the object", http.MethodGet, []string{"Range", "bytes=-20"}, http.StatusPartialContent, "0123456789", "bytes 0-9/10"},
+		{"start past the end", http.MethodGet, []string{"Range", "bytes=10-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"multiple ranges", http.MethodGet, []string{"Range", "bytes=0-1,4-5"}, http.StatusOK, "0123456789", ""},
+		{"malformed range", http.MethodGet, []string{"Range", "bytes=5-2"}, http.StatusOK, "0123456789", ""},
+		{"If-Range with the ETag", http.MethodGet, []string{"Range", "bytes=2-4", "If-Range", etag}, http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"stale If-Range ETag", http.MethodGet, []string{"Range", "bytes=2-4", "If-Range", `"stale"`}, http.StatusOK, "0123456789", ""},
+		{"If-Range with the date", http.MethodGet, []string{"Range", "bytes=2-4", "If-Range", modified}, http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"stale If-Range date", http.MethodGet, []string{"Range", "bytes=2-4", "If-Range", stale}, http.StatusOK, "0123456789", ""},
+		{"If-None-Match", http.MethodGet, []string{"If-None-Match", etag}, http.StatusNotModified, "", ""},
+		{"weak If-None-Match in a list", http.MethodGet, []string{"If-None-Match", `"other", W/` + etag}, http.StatusNotModified, "", ""},
+		{"other If-None-Match", http.MethodGet, []string{"If-None-Match", `"other"`}, http.StatusOK, "0123456789", ""},
+		{"If-Modified-Since", http.MethodGet, []string{"If-Modified-Since", later}, http.StatusNotModified, "", ""},
+		{"If-Modified-Since before the change", http.MethodGet, []string{"If-Modified-Since", stale}, http.StatusOK, "0123456789", ""},
+		{"HEAD", http.MethodHead, nil, http.StatusOK, "", ""},
+		{"HEAD with a range", http.MethodHead, []string{"Range", "bytes=-3"}, http.StatusPartialContent, "", "bytes 7-9/10"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			w := stream(c.method, link, c.headers...)
+			if w.Code != c.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, c.status, w.Body)
+			}
+			if c.status != http.StatusRequestedRangeNotSatisfiable && w.Body.String() != c.body {
+				t.Errorf("body %q, want %q", w.Body, c.body)
+			}
+			if got := w.Header().Get("Content-Range"); got != c.rng {
+				t.Errorf("Content-Range %q, want %q", got, c.rng)
+			}
+			switch {
+			case c.status == http.StatusNotModified:
+				if w.Header().Get("ETag") != etag || w.Header().Get("Content-Length") != "" {
+					t.Errorf("304 headers %v", w.Header())
+				}
+			case c.method == http.MethodHead:
+				want := "10"
+				if c.status == http.StatusPartialContent {
+					want = "3"
+				}
+				if got := w.Header().Get("Content-Length"); got != want {
+					t.Errorf("HEAD Content-Length %q, want %s", got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("links", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute).Unix()
+		expired := "/api/memory/bucket/stream?connection_id=" + strconv.FormatInt(id, 10) + "&object=clips%2Fvideo.bin&expires=" +
+			strconv.FormatInt(past, 10) + "&sig=" + linkSignature("memory", id, "clips/video.bin", past)
+		for _, c := range []struct {
+			name   string
+			target string
+			status int
+		}{
+			{"bad signature", strings.Replace(link, "sig=", "sig=0", 1), http.StatusForbidden},
+			{"other object", strings.Replace(link, "video.bin", "other.bin", 1), http.StatusForbidden},
+			{"other provider", strings.Replace(link, "/api/memory/", "/api/local/", 1), http.StatusForbidden},
+			{"later expiry", strings.Replace(link, "expires=", "expires=9", 1), http.StatusForbidden},
+			{"expired", expired, http.StatusForbidden},
+			{"no connection", strings.Replace(link, "connection_id=", "connection_id=x", 1), http.StatusBadRequest},
+		} {
+			t.Run(c.name, func(t *testing.T) {
+				w := stream(http.MethodGet, c.target)
+				if w.Code != c.status || w.Header().Get("Content-Disposition") != "" {
+					t.Errorf("status %d, want %d: %s", w.Code, c.status, w.Body)
+				}
+			})
+		}
+		if w := stream(http.MethodPost, link); w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("POST: status %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,645 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appdb "github.com/PandhuWibowo/oss-portable/db"
+	"github.com/PandhuWibowo/oss-portable/storage"
+)
+
+// Resumable uploads speak the tus 1.0.0 protocol (core, creation,
+// expiration and termination) under /api/{provider}/bucket/tus/. Sessions
+// are kept in the uploads table, so a client can ask for the offset and
+// carry on after a dropped connection, a page reload or a server restart.
+//
+// Incoming bytes are appended to a spool file in upload_dir. Backends that
+// implement storage.MultipartUploader get a part every time the spool
+// reaches the part size, after which it is truncated, so the spool never
+// holds more than one part. Other backends get the whole spooled file in
+// one Put at the end.
+
+const tusVersion = "1.0.0"
+
+// uploadSession is a row of the uploads table.
+type uploadSession struct {
+	ID           string
+	Provider     string
+	ConnectionID int64
+	Object       string
+	ContentType  string
+	Size         int64
+	PartSize     int64
+	UploadID     string // empty when the file is spooled whole
+	Sent         int64  // bytes handed to the provider as parts
+	Parts        []string
+	Completed    bool
+	ExpiresAt    time.Time
+}
+
+func (s *uploadSession) upload() storage.Upload {
+	return storage.Upload{Key: s.Object, ContentType: s.ContentType, Size: s.Size, ID: s.UploadID}
+}
+
+func (s *uploadSession) multipart() bool { return s.UploadID != "" }
+
+func uploadDir() string {
+	if cfg.UploadDir != "" {
+		return cfg.UploadDir
+	}
+	return filepath.Join(os.TempDir(), "vestra-uploads")
+}
+
+func (s *uploadSession) spoolPath() string { return filepath.Join(uploadDir(), s.ID) }
+
+// spooled returns how many bytes are waiting in the spool file.
+func (s *uploadSession) spooled() (int64, error) {
+	info, err := os.Stat(s.spoolPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+const uploadColumns = "id, provider, connection_id, object, content_type, size, part_size, upload_id, sent, parts, completed, expires_at"
+
+var errUploadNotFound = errors.New("upload not found or expired")
+
+// loadUpload reads an upload that has not expired.
+func loadUpload(provider, id string) (*uploadSession, error) {
+	s, err := readUpload(provider, id)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(s.ExpiresAt) {
+		return nil, errUploadNotFound
+	}
+	return s, nil
+}
+
+func readUpload(provider, id string) (*uploadSession, error) {
+	var s uploadSession
+	var parts, expires string
+	err := appdb.QueryRow("SELECT "+uploadColumns+" FROM uploads WHERE id = ? AND provider = ?", id, provider).Scan(
+		&s.ID, &s.Provider, &s.ConnectionID, &s.Object, &s.ContentType, &s.Size, &s.PartSize,
+		&s.UploadID, &s.Sent, &parts, &s.Completed, &expires,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(parts), &s.Parts); err != nil {
+		return nil, err
+	}
+	s.ExpiresAt, _ = time.Parse(time.RFC3339, expires)
+	return &s, nil
+}
+
+// save records progress and pushes the expiry back.
+func (s *uploadSession) save() error {
+	s.ExpiresAt = time.Now().UTC().Add(cfg.UploadExpiry).Truncate(time.Second)
+	parts, _ := json.Marshal(s.Parts)
+	_, err := appdb.Exec(
+		"UPDATE uploads SET sent = ?, parts = ?, completed = ?, expires_at = ? WHERE id = ?",
+		s.Sent, string(parts), s.Completed, s.ExpiresAt.Format(time.RFC3339), s.ID,
+	)
+	return err
+}
+
+// ── locking ──────────────────────────────────────────────────────
+
+// uploadLocks serialises requests for the same upload within this process.
+// Replicas sharing a database must route an upload's requests to one of
+// them, since the spool file is local. An entry lives for as long as a
+// request holds or waits for it, so finished uploads leave nothing behind.
+var uploadLocks = struct {
+	sync.Mutex
+	m map[string]*uploadLock
+}{m: map[string]*uploadLock{}}
+
+type uploadLock struct {
+	ch   chan struct{}
+	refs int // requests holding or waiting for ch; guarded by uploadLocks
+}
+
+// lockUpload waits for other requests on the upload to finish.
+func lockUpload(ctx context.Context, id string) (unlock func(), err error) {
+	uploadLocks.Lock()
+	l, ok := uploadLocks.m[id]
+	if !ok {
+		l = &uploadLock{ch: make(chan struct{}, 1)}
+		uploadLocks.m[id] = l
+	}
+	l.refs++
+	uploadLocks.Unlock()
+	select {
+	case l.ch <- struct{}{}:
+		return func() {
+			<-l.ch
+			releaseUploadLock(id, l)
+		}, nil
+	case <-ctx.Done():
+		releaseUploadLock(id, l)
+		return nil, ctx.Err()
+	}
+}
+
+// releaseUploadLock drops a reference to l, removing it once unused.
+func releaseUploadLock(id string, l *uploadLock) {
+	uploadLocks.Lock()
+	defer uploadLocks.Unlock()
+	if l.refs--; l.refs == 0 {
+		delete(uploadLocks.m, id)
+	}
+}
+
+// ── handlers ─────────────────────────────────────────────────────
+
+// TusHeaders adds the tus version headers to every response, including the
+// OPTIONS discovery request answered by the CORS middleware.
+func TusHeaders(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Tus-Version", tusVersion)
+			w.Header().Set("Tus-Extension", "creation,expiration,termination")
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(int64(cfg.MaxUploadSize), 10))
+		}
+		next(w, r)
+	}
+}
+
+// TusUpload handles /api/{provider}/bucket/tus/ (POST creates an upload)
+// and /api/{provider}/bucket/tus/{id} (HEAD, PATCH, DELETE).
+func TusUpload(w http.ResponseWriter, r *http.Request) {
+	if v := r.Header.Get("Tus-Resumable"); v != tusVersion {
+		writeError(w, codePreconditionFailed, "Tus-Resumable must be "+tusVersion)
+		return
+	}
+	provider := providerFromPath(r.URL.Path)
+	_, id, _ := strings.Cut(r.URL.Path, "/bucket/tus/")
+
+	if id == "" {
+		if r.Method != http.MethodPost {
+			writeError(w, codeMethodNotAllowed, "method not allowed")
+			return
+		}
+		createUpload(w, r, provider)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Upload)
+	defer cancel()
+	unlock, err := lockUpload(ctx, id)
+	if err != nil {
+		writeStorageError(w, provider, err)
+		return
+	}
+	defer unlock()
+
+	s, err := loadUpload(provider, id)
+	if errors.Is(err, errUploadNotFound) {
+		writeError(w, storage.CodeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		headUpload(ctx, w, s)
+	case http.MethodPatch:
+		patchUpload(ctx, w, r, s)
+	case http.MethodDelete:
+		deleteUpload(ctx, w, s)
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma-separated
+// keys, each followed by a space and its base64 value.
+func parseUploadMetadata(h string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(h, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		v, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata %s: %v", key, err)
+		}
+		m[key] = string(v)
+	}
+	return m, nil
+}
+
+// createUpload starts an upload of Upload-Length bytes. Upload-Metadata
+// carries connection_id, filename and optionally prefix and filetype.
+func createUpload(w http.ResponseWriter, r *http.Request, provider string) {
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		badRequest(w, "Upload-Length is required (deferred lengths are not supported)")
+		return
+	}
+	if size > int64(cfg.MaxUploadSize) {
+		writeError(w, codeTooLarge, fmt.Sprintf("file is larger than the %s upload limit", cfg.MaxUploadSize))
+		return
+	}
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	connectionID, err := strconv.ParseInt(meta["connection_id"], 10, 64)
+	if err != nil {
+		badRequest(w, "Upload-Metadata must include connection_id")
+		return
+	}
+	name := meta["filename"]
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		badRequest(w, "Upload-Metadata must include a filename without slashes")
+		return
+	}
+	contentType := meta["filetype"]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeouts.Upload)
+	defer cancel()
+	backend, ok := openBackend(ctx, w, r, connectionID)
+	if !ok {
+		return
+	}
+	defer backend.Close()
+
+	s := &uploadSession{
+		Provider:     provider,
+		ConnectionID: connectionID,
+		Object:       meta["prefix"] + name,
+		ContentType:  contentType,
+		Size:         size,
+		PartSize:     storage.UploadPartSize(),
+		Parts:        []string{},
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	s.ID = hex.EncodeToString(id)
+
+	if size == 0 {
+		// Nothing to resume.
+		if err := backend.Put(ctx, s.Object, strings.NewReader(""), 0, contentType); err != nil {
+			writeStorageError(w, provider, err)
+			return
+		}
+		s.Completed = true
+	} else if mu, ok := backend.(storage.MultipartUploader); ok {
+		u := s.upload()
+		if err := mu.StartUpload(ctx, &u); err != nil {
+			writeStorageError(w, provider, err)
+			return
+		}
+		s.UploadID = u.ID
+	}
+	if !s.Completed {
+		if err := os.MkdirAll(uploadDir(), 0o700); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	s.ExpiresAt = now.Add(cfg.UploadExpiry).Truncate(time.Second)
+	if _, err := appdb.Exec(
+		"INSERT INTO uploads ("+uploadColumns+", created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		s.ID, s.Provider, s.ConnectionID, s.Object, s.ContentType, s.Size, s.PartSize,
+		s.UploadID, s.Sent, "[]", s.Completed, s.ExpiresAt.Format(time.RFC3339), now.Format(time.RFC3339),
+	); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/"+provider+"/bucket/tus/"+s.ID)
+	w.Header().Set("Upload-Expires", s.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func writeUploadOffset(w http.ResponseWriter, s *uploadSession, offset int64) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(s.Size, 10))
+	w.Header().Set("Upload-Expires", s.ExpiresAt.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// headUpload reports the offset to resume from. An upload whose bytes are
+// all in but whose last step failed is finished first, because clients take
+// a full offset to mean done.
+func headUpload(ctx context.Context, w http.ResponseWriter, s *uploadSession) {
+	spooled, err := s.spooled()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	offset := s.Sent + spooled
+	if s.Completed {
+		offset = s.Size
+	} else if offset == s.Size {
+		backend, err := s.open(ctx)
+		if err == nil {
+			err = finishUpload(ctx, backend, s)
+			backend.Close()
+		}
+		if err != nil {
+			writePatchError(w, s, err)
+			return
+		}
+	}
+	writeUploadOffset(w, s, offset)
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchUpload appends the request body at Upload-Offset.
+func patchUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, s *uploadSession) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeError(w, codeUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+	spooled, err := s.spooled()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	offset := s.Sent + spooled
+	if s.Completed {
+		offset = s.Size
+	}
+	if got, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64); err != nil || got != offset {
+		writeAPIError(w, apiError{
+			Code:    storage.CodeConflict,
+			Message: fmt.Sprintf("Upload-Offset must be %d", offset),
+			Details: map[string]any{"offset": offset},
+		})
+		return
+	}
+	if s.Completed {
+		writeUploadOffset(w, s, offset)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	backend, err := s.open(ctx)
+	if err != nil {
+		writePatchError(w, s, err)
+		return
+	}
+	defer backend.Close()
+	spool, err := os.OpenFile(s.spoolPath(), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer spool.Close()
+
+	// Ignore anything past the declared length.
+	body := io.LimitReader(r.Body, s.Size-offset)
+	var readErr error
+	for s.Sent+spooled < s.Size && readErr == nil {
+		chunk := s.Size - s.Sent - spooled
+		if s.multipart() {
+			chunk = min(chunk, s.PartSize-spooled)
+		}
+		var n int64
+		n, readErr = io.CopyN(spool, body, chunk)
+		spooled += n
+		// A full part goes to the provider at once; the last one is sent
+		// by finishUpload.
+		if s.multipart() && spooled == s.PartSize && s.Sent+spooled < s.Size {
+			if err := sendPart(ctx, backend.(storage.MultipartUploader), s, spool, spooled); err != nil {
+				writePatchError(w, s, err)
+				return
+			}
+			spooled = 0
+		}
+	}
+
+	if s.Sent+spooled == s.Size {
+		if err := finishUpload(ctx, backend, s); err != nil {
+			writePatchError(w, s, err)
+			return
+		}
+		spooled = 0
+	} else if readErr != nil && readErr != io.EOF {
+		// The client went away; what arrived is kept for the resume.
+		log.Printf("upload %s: %v after %d bytes", s.ID, readErr, s.Sent+spooled)
+		writeUploadError(w, r, readErr)
+		return
+	} else if err := s.save(); err != nil {
+		internalError(w, err)
+		return
+	}
+	writeUploadOffset(w, s, s.Sent+spooled)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// open connects to the upload's bucket.
+func (s *uploadSession) open(ctx context.Context) (storage.Backend, error) {
+	bucket, credentials, err := loadConnection(s.Provider, s.ConnectionID)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := storage.Open(ctx, s.Provider, bucket, credentials)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := backend.(storage.MultipartUploader); s.multipart() && !ok {
+		backend.Close()
+		return nil, fmt.Errorf("%w: resumable multipart uploads", storage.ErrNotSupported)
+	}
+	return backend, nil
+}
+
+func writePatchError(w http.ResponseWriter, s *uploadSession, err error) {
+	if errors.Is(err, errConnectionNotFound) {
+		writeConnError(w, err)
+		return
+	}
+	writeStorageError(w, s.Provider, err)
+}
+
+// sendPart uploads the spool as the next part and empties it. The spool is
+// truncated before the progress is saved: if the server stops in between,
+// the client resumes from the start of the part and sends it again.
+func sendPart(ctx context.Context, mu storage.MultipartUploader, s *uploadSession, spool *os.File, size int64) error {
+	n := len(s.Parts) + 1
+	tag, err := mu.UploadPart(ctx, s.upload(), n, s.Sent, io.NewSectionReader(spool, 0, size), size)
+	if err != nil {
+		return err
+	}
+	if err := spool.Truncate(0); err != nil {
+		return err
+	}
+	s.Parts = append(s.Parts, tag)
+	s.Sent += size
+	return s.save()
+}
+
+// finishUpload completes the upload once every byte has arrived: the spool
+// is sent as the last part and the multipart upload completed, or the
+// spooled file is stored in one Put.
+func finishUpload(ctx context.Context, backend storage.Backend, s *uploadSession) error {
+	spool, err := os.OpenFile(s.spoolPath(), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer spool.Close()
+	spooled, err := spool.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if mu, ok := backend.(storage.MultipartUploader); ok && s.multipart() {
+		if spooled > 0 {
+			if err := sendPart(ctx, mu, s, spool, spooled); err != nil {
+				return err
+			}
+		}
+		if err := mu.CompleteUpload(ctx, s.upload(), s.Parts); err != nil {
+			return err
+		}
+	} else {
+		if err := backend.Put(ctx, s.Object, io.NewSectionReader(spool, 0, spooled), s.Size, s.ContentType); err != nil {
+			return err
+		}
+		s.Sent = s.Size
+	}
+	s.Completed = true
+	if err := s.save(); err != nil {
+		return err
+	}
+	if err := os.Remove(s.spoolPath()); err != nil {
+		log.Printf("upload %s: removing spool: %v", s.ID, err)
+	}
+	return nil
+}
+
+// deleteUpload terminates an upload and discards what was sent.
+func deleteUpload(ctx context.Context, w http.ResponseWriter, s *uploadSession) {
+	if err := discardUpload(ctx, s); err != nil {
+		writePatchError(w, s, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// discardUpload aborts the provider's side of an unfinished upload and
+// removes the session and its spool.
+func discardUpload(ctx context.Context, s *uploadSession) error {
+	if s.multipart() && !s.Completed {
+		backend, err := s.open(ctx)
+		if err == nil {
+			err = backend.(storage.MultipartUploader).AbortUpload(ctx, s.upload())
+			backend.Close()
+		}
+		// A deleted connection leaves nothing that could be aborted.
+		if err != nil && !errors.Is(err, errConnectionNotFound) {
+			return err
+		}
+	}
+	if err := os.Remove(s.spoolPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	_, err := appdb.Exec("DELETE FROM uploads WHERE id = ?", s.ID)
+	return err
+}
+
+// ── expiry ───────────────────────────────────────────────────────
+
+// ExpireUploads discards uploads that have made no progress for
+// upload_expiry, and finished ones after the same time. It checks now and
+// then every interval until ctx is done.
+func ExpireUploads(ctx context.Context, interval time.Duration) {
+	for {
+		expireUploads(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func expireUploads(ctx context.Context) {
+	rows, err := appdb.Query("SELECT id, provider FROM uploads WHERE expires_at < ?", time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		log.Printf("expiring uploads: %v", err)
+		return
+	}
+	type key struct{ id, provider string }
+	var expired []key
+	for rows.Next() {
+		var k key
+		if err := rows.Scan(&k.id, &k.provider); err == nil {
+			expired = append(expired, k)
+		}
+	}
+	rows.Close()
+
+	for _, k := range expired {
+		if err := expireUpload(ctx, k.provider, k.id); err != nil {
+			log.Printf("expiring upload %s: %v", k.id, err)
+		}
+	}
+}
+
+// expireUpload discards an upload unless a request extended it meanwhile.
+func expireUpload(ctx context.Context, provider, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeouts.Delete)
+	defer cancel()
+	unlock, err := lockUpload(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	s, err := readUpload(provider, id)
+	if errors.Is(err, errUploadNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(s.ExpiresAt) {
+		return nil
+	}
+	if err := discardUpload(ctx, s); err != nil {
+		return err
+	}
+	if !s.Completed {
+		log.Printf("expired unfinished upload %s of %s (%d of %d bytes)", s.ID, s.Object, s.Sent, s.Size)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockUpload(t *testing.T) {
+	const id = "upload-1"
+	unlock, err := lockUpload(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A waiter that gives up must not take the entry with it.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := lockUpload(ctx, id); err != context.DeadlineExceeded {
+		t.Fatalf("lockUpload on a held upload = %v, want a deadline error", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		u, err := lockUpload(context.Background(), id)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- u
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a second request got the lock while the first held it")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	(<-acquired)()
+
+	uploadLocks.Lock()
+	n := len(uploadLocks.m)
+	uploadLocks.Unlock()
+	if n != 0 {
+		t.Errorf("%d lock entries left after every request finished", n)
+	}
+}
+
+func TestLockUploadExclusive(t *testing.T) {
+	const id = "upload-2"
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	holders, most := 0, 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockUpload(context.Background(), id)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			holders++
+			most = max(most, holders)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if most != 1 {
+		t.Errorf("%d requests held the lock at once", most)
+	}
+	uploadLocks.Lock()
+	defer uploadLocks.Unlock()
+	if _, ok := uploadLocks.m[id]; ok {
+		t.Error("the lock entry outlived its last request")
+	}
+}
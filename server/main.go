@@ -1,112 +1,258 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/PandhuWibowo/oss-portable/config"
 	appdb "github.com/PandhuWibowo/oss-portable/db"
 	"github.com/PandhuWibowo/oss-portable/handlers"
 	"github.com/PandhuWibowo/oss-portable/middleware"
+	"github.com/PandhuWibowo/oss-portable/secrets"
+	"github.com/PandhuWibowo/oss-portable/storage"
 )
 
 func main() {
-	if err := appdb.Init(); err != nil {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if len(args) > 0 {
+		runCommand(cfg, args)
+		return
+	}
+
+	log.Printf("effective configuration:")
+	cfg.Print(log.Writer())
+	handlers.Configure(cfg)
+	middleware.SetAllowedOrigins(cfg.CORSOrigins)
+	storage.SetLocalRoots(cfg.LocalRoots)
+	storage.SetAmbientCredentials(cfg.AmbientCredentials)
+	storage.SetMemoryFixtures(cfg.MemoryFixtures)
+	storage.SetMemoryLimit(int64(cfg.MemoryMaxSize))
+	storage.SetUploadParts(int64(cfg.UploadPartSize), cfg.UploadConcurrency)
+
+	if err := secrets.Init(cfg.MasterKeyFile); err != nil {
+		log.Fatalf("master key: %v", err)
+	}
+	if err := appdb.Init(cfg.DBDSN); err != nil {
 		log.Fatalf("db init failed: %v", err)
 	}
+	if kr := secrets.Current(); kr.Enabled() {
+		n, err := appdb.ResealCredentials(kr)
+		if err != nil {
+			log.Fatalf("encrypting stored credentials failed: %v", err)
+		}
+		if n > 0 {
+			log.Printf("encrypted %d stored credential(s) with master key %s", n, kr.KeyID())
+		}
+	} else {
+		log.Printf("WARNING: %s is not set; connection credentials are stored unencrypted", secrets.EnvMasterKey)
+	}
+	go handlers.ExpireUploads(context.Background(), min(cfg.UploadExpiry, time.Hour))
+	go handlers.ResumeMoves()
 
 	mux := http.NewServeMux()
 
-	// ── GCP connections ───────────────────────────────────────────
-	mux.HandleFunc("/api/gcp/connections",   middleware.CORS(handlers.ListGCP))
-	mux.HandleFunc("/api/gcp/connection",    middleware.CORS(handlers.CreateGCP))
-	mux.HandleFunc("/api/gcp/connection/",   middleware.CORS(handlers.GCPConnByID))
-	mux.HandleFunc("/api/gcp/test",          middleware.CORS(handlers.TestGCP))
-
-	// ── GCP bucket operations ─────────────────────────────────────
-	mux.HandleFunc("/api/gcp/bucket/browse",           middleware.CORS(handlers.BrowseGCPBucket))
-	mux.HandleFunc("/api/gcp/bucket/objects",          middleware.CORS(handlers.ListGCPObjects))
-	mux.HandleFunc("/api/gcp/bucket/download",         middleware.CORS(handlers.GCPDownloadURL))
-	mux.HandleFunc("/api/gcp/bucket/delete",           middleware.CORS(handlers.DeleteGCPObject))
-	mux.HandleFunc("/api/gcp/bucket/copy",             middleware.CORS(handlers.CopyGCPObject))
-	mux.HandleFunc("/api/gcp/bucket/upload",           middleware.CORS(handlers.UploadGCPObject))
-	mux.HandleFunc("/api/gcp/bucket/stats",            middleware.CORS(handlers.GCPBucketStats))
-	mux.HandleFunc("/api/gcp/bucket/metadata",         middleware.CORS(handlers.GetGCPMetadata))
-	mux.HandleFunc("/api/gcp/bucket/metadata/update",  middleware.CORS(handlers.UpdateGCPMetadata))
-
-	// ── AWS connections ───────────────────────────────────────────
-	mux.HandleFunc("/api/aws/connections",   middleware.CORS(handlers.ListAWS))
-	mux.HandleFunc("/api/aws/connection",    middleware.CORS(handlers.CreateAWS))
-	mux.HandleFunc("/api/aws/connection/",   middleware.CORS(handlers.AWSConnByID))
-	mux.HandleFunc("/api/aws/test",          middleware.CORS(handlers.TestAWS))
-
-	// ── AWS bucket operations ─────────────────────────────────────
-	mux.HandleFunc("/api/aws/bucket/browse",           middleware.CORS(handlers.BrowseAWSBucket))
-	mux.HandleFunc("/api/aws/bucket/objects",          middleware.CORS(handlers.ListAWSObjects))
-	mux.HandleFunc("/api/aws/bucket/download",         middleware.CORS(handlers.AWSDownloadURL))
-	mux.HandleFunc("/api/aws/bucket/delete",           middleware.CORS(handlers.DeleteAWSObject))
-	mux.HandleFunc("/api/aws/bucket/copy",             middleware.CORS(handlers.CopyAWSObject))
-	mux.HandleFunc("/api/aws/bucket/upload",           middleware.CORS(handlers.UploadAWSObject))
-	mux.HandleFunc("/api/aws/bucket/stats",            middleware.CORS(handlers.AWSBucketStats))
-	mux.HandleFunc("/api/aws/bucket/metadata",         middleware.CORS(handlers.GetAWSMetadata))
-	mux.HandleFunc("/api/aws/bucket/metadata/update",  middleware.CORS(handlers.UpdateAWSMetadata))
-
-	// ── Huawei OBS connections ────────────────────────────────────
-	mux.HandleFunc("/api/huawei/connections",  middleware.CORS(handlers.ListHuawei))
-	mux.HandleFunc("/api/huawei/connection",   middleware.CORS(handlers.CreateHuawei))
-	mux.HandleFunc("/api/huawei/connection/",  middleware.CORS(handlers.HuaweiConnByID))
-	mux.HandleFunc("/api/huawei/test",         middleware.CORS(handlers.TestHuawei))
-
-	// ── Huawei OBS bucket operations ──────────────────────────────
-	mux.HandleFunc("/api/huawei/bucket/browse",          middleware.CORS(handlers.BrowseHuaweiBucket))
-	mux.HandleFunc("/api/huawei/bucket/objects",         middleware.CORS(handlers.ListHuaweiObjects))
-	mux.HandleFunc("/api/huawei/bucket/download",        middleware.CORS(handlers.HuaweiDownloadURL))
-	mux.HandleFunc("/api/huawei/bucket/delete",          middleware.CORS(handlers.DeleteHuaweiObject))
-	mux.HandleFunc("/api/huawei/bucket/copy",            middleware.CORS(handlers.CopyHuaweiObject))
-	mux.HandleFunc("/api/huawei/bucket/upload",          middleware.CORS(handlers.UploadHuaweiObject))
-	mux.HandleFunc("/api/huawei/bucket/stats",           middleware.CORS(handlers.HuaweiBucketStats))
-	mux.HandleFunc("/api/huawei/bucket/metadata",        middleware.CORS(handlers.GetHuaweiMetadata))
-	mux.HandleFunc("/api/huawei/bucket/metadata/update", middleware.CORS(handlers.UpdateHuaweiMetadata))
-
-	// ── Alibaba Cloud OSS connections ─────────────────────────────
-	mux.HandleFunc("/api/alibaba/connections",  middleware.CORS(handlers.ListAlibaba))
-	mux.HandleFunc("/api/alibaba/connection",   middleware.CORS(handlers.CreateAlibaba))
-	mux.HandleFunc("/api/alibaba/connection/",  middleware.CORS(handlers.AlibabaConnByID))
-	mux.HandleFunc("/api/alibaba/test",         middleware.CORS(handlers.TestAlibaba))
-
-	// ── Alibaba OSS bucket operations ─────────────────────────────
-	mux.HandleFunc("/api/alibaba/bucket/browse",          middleware.CORS(handlers.BrowseAlibabaBucket))
-	mux.HandleFunc("/api/alibaba/bucket/objects",         middleware.CORS(handlers.ListAlibabaObjects))
-	mux.HandleFunc("/api/alibaba/bucket/download",        middleware.CORS(handlers.AlibabaDownloadURL))
-	mux.HandleFunc("/api/alibaba/bucket/delete",          middleware.CORS(handlers.DeleteAlibabaObject))
-	mux.HandleFunc("/api/alibaba/bucket/copy",            middleware.CORS(handlers.CopyAlibabaObject))
-	mux.HandleFunc("/api/alibaba/bucket/upload",          middleware.CORS(handlers.UploadAlibabaObject))
-	mux.HandleFunc("/api/alibaba/bucket/stats",           middleware.CORS(handlers.AlibabaBucketStats))
-	mux.HandleFunc("/api/alibaba/bucket/metadata",        middleware.CORS(handlers.GetAlibabaMetadata))
-	mux.HandleFunc("/api/alibaba/bucket/metadata/update", middleware.CORS(handlers.UpdateAlibabaMetadata))
-
-	// ── Azure Blob Storage connections ────────────────────────────
-	mux.HandleFunc("/api/azure/connections",  middleware.CORS(handlers.ListAzure))
-	mux.HandleFunc("/api/azure/connection",   middleware.CORS(handlers.CreateAzure))
-	mux.HandleFunc("/api/azure/connection/",  middleware.CORS(handlers.AzureConnByID))
-	mux.HandleFunc("/api/azure/test",         middleware.CORS(handlers.TestAzure))
-
-	// ── Azure Blob Storage bucket operations ──────────────────────
-	mux.HandleFunc("/api/azure/bucket/browse",          middleware.CORS(handlers.BrowseAzureBucket))
-	mux.HandleFunc("/api/azure/bucket/objects",         middleware.CORS(handlers.ListAzureObjects))
-	mux.HandleFunc("/api/azure/bucket/download",        middleware.CORS(handlers.AzureDownloadURL))
-	mux.HandleFunc("/api/azure/bucket/delete",          middleware.CORS(handlers.DeleteAzureObject))
-	mux.HandleFunc("/api/azure/bucket/copy",            middleware.CORS(handlers.CopyAzureObject))
-	mux.HandleFunc("/api/azure/bucket/upload",          middleware.CORS(handlers.UploadAzureObject))
-	mux.HandleFunc("/api/azure/bucket/stats",           middleware.CORS(handlers.AzureBucketStats))
-	mux.HandleFunc("/api/azure/bucket/metadata",        middleware.CORS(handlers.GetAzureMetadata))
-	mux.HandleFunc("/api/azure/bucket/metadata/update", middleware.CORS(handlers.UpdateAzureMetadata))
+	// ── Connections across all providers ──────────────────────────
+	mux.HandleFunc("/api/connections", middleware.CORS(handlers.ListAllConnections))
+
+	// ── Background jobs ───────────────────────────────────────────
+	mux.HandleFunc("/api/jobs",  middleware.CORS(handlers.ListJobs))
+	mux.HandleFunc("/api/jobs/", middleware.CORS(handlers.JobByID))
+
+	// ── S3-compatible presets ─────────────────────────────────────
+	mux.HandleFunc("/api/aws/presets", middleware.CORS(handlers.ListS3Presets))
+
+	// Every provider registered in the storage package shares the same
+	// handlers; the provider is taken from the /api/{provider}/… path.
+	for _, provider := range storage.Providers() {
+		base := "/api/" + provider
+
+		// ── connections ───────────────────────────────────────────
+		mux.HandleFunc(base+"/connections", middleware.CORS(handlers.ListConnections))
+		mux.HandleFunc(base+"/connection",  middleware.CORS(handlers.CreateConnection))
+		mux.HandleFunc(base+"/connection/", middleware.CORS(handlers.ConnectionByID))
+		mux.HandleFunc(base+"/test",        middleware.CORS(handlers.TestConnection))
+
+		// ── bucket operations ─────────────────────────────────────
+		mux.HandleFunc(base+"/bucket/browse",          middleware.CORS(handlers.BrowseBucket))
+		mux.HandleFunc(base+"/bucket/objects",         middleware.CORS(handlers.ListObjects))
+		mux.HandleFunc(base+"/bucket/download",        middleware.CORS(handlers.DownloadURL))
+		mux.HandleFunc(base+"/bucket/stream",          middleware.CORS(handlers.StreamObject))
+		mux.HandleFunc(base+"/bucket/archive",         middleware.CORS(handlers.ArchiveURL))
+		mux.HandleFunc(base+"/bucket/archive/stream",  middleware.CORS(handlers.StreamArchive))
+		mux.HandleFunc(base+"/bucket/delete",          middleware.CORS(handlers.DeleteObject))
+		mux.HandleFunc(base+"/bucket/delete/batch",    middleware.CORS(handlers.DeleteObjects))
+		mux.HandleFunc(base+"/bucket/copy",            middleware.CORS(handlers.CopyObject))
+		mux.HandleFunc(base+"/bucket/move",            middleware.CORS(handlers.MovePrefix))
+		mux.HandleFunc(base+"/bucket/upload",          middleware.CORS(handlers.UploadObject))
+		mux.HandleFunc(base+"/bucket/upload/presign",  middleware.CORS(handlers.PresignUpload))
+		mux.HandleFunc(base+"/bucket/upload/complete", middleware.CORS(handlers.CompleteUpload))
+		mux.HandleFunc(base+"/bucket/upload/extract",  middleware.CORS(handlers.ExtractUpload))
+		mux.HandleFunc(base+"/bucket/stats",           middleware.CORS(handlers.BucketStats))
+		mux.HandleFunc(base+"/bucket/metadata",        middleware.CORS(handlers.GetMetadata))
+		mux.HandleFunc(base+"/bucket/metadata/update", middleware.CORS(handlers.UpdateMetadata))
+		mux.HandleFunc(base+"/bucket/tus/",            handlers.TusHeaders(middleware.CORS(handlers.TusUpload)))
+	}
 
 	// ── Docs ──────────────────────────────────────────────────────
 	mux.HandleFunc("/api/docs/", middleware.CORS(handlers.ServeDocs))
 
-	srv := &http.Server{Addr: ":8080", Handler: mux}
-	log.Printf("starting backend on %s", srv.Addr)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	srv := &http.Server{Addr: cfg.Listen, Handler: mux}
+	serve(srv, cfg.ShutdownTimeout)
+}
+
+// serve runs srv until SIGINT or SIGTERM, then stops accepting connections
+// and gives in-flight requests up to drain to finish. Requests still running
+// after that have their connections closed, which cancels their contexts
+// and with them any calls to the storage provider. Background jobs share
+// the same deadline and are canceled when it passes. The database is closed
+// last.
+func serve(srv *http.Server, drain time.Duration) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("starting backend on %s", srv.Addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
 		log.Fatalf("server failed: %v", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("shutting down, draining in-flight requests for up to %s", drain)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("drain deadline passed, closing remaining connections: %v", err)
+		_ = srv.Close()
+	}
+	handlers.ShutdownJobs(shutdownCtx)
+	if err := appdb.Close(); err != nil {
+		log.Printf("closing database: %v", err)
+	}
+	log.Printf("server stopped")
+}
+
+// runCommand handles the maintenance subcommands, which take the same flags
+// as the server itself:
+//
+//	server [flags] gen-key             print a new random master key
+//	server [flags] rotate-key          re-encrypt all credentials under VESTRA_NEW_MASTER_KEY
+//	server [flags] migrate [status]    show applied and pending schema migrations
+//	server [flags] migrate dry-run     run pending migrations in a rolled-back transaction
+//	server [flags] migrate up          apply pending migrations
+//	server [flags] config              print the effective configuration
+func runCommand(cfg *config.Config, args []string) {
+	switch name := args[0]; name {
+	case "migrate":
+		sub := "status"
+		if len(args) > 1 {
+			sub = args[1]
+		}
+		runMigrate(cfg, sub)
+
+	case "config":
+		cfg.Print(os.Stdout)
+
+	case "gen-key":
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			log.Fatalf("gen-key: %v", err)
+		}
+		fmt.Println(key)
+
+	case "rotate-key":
+		oldKey, err := secrets.LoadKey(secrets.EnvMasterKey, cfg.MasterKeyFile)
+		if err != nil {
+			log.Fatalf("rotate-key: %v", err)
+		}
+		newKey, err := secrets.LoadKey(secrets.EnvNewMasterKey, os.Getenv(secrets.EnvNewMasterKeyFile))
+		if err != nil {
+			log.Fatalf("rotate-key: %v", err)
+		}
+		if newKey == nil {
+			log.Fatalf("rotate-key: %s or %s must be set", secrets.EnvNewMasterKey, secrets.EnvNewMasterKeyFile)
+		}
+		keys := [][]byte{newKey}
+		if oldKey != nil {
+			keys = append(keys, oldKey)
+		}
+		kr, err := secrets.NewKeyring(keys...)
+		if err != nil {
+			log.Fatalf("rotate-key: %v", err)
+		}
+		if err := appdb.Init(cfg.DBDSN); err != nil {
+			log.Fatalf("db init failed: %v", err)
+		}
+		n, err := appdb.ResealCredentials(kr)
+		if err != nil {
+			log.Fatalf("rotate-key: %v", err)
+		}
+		log.Printf("re-encrypted %d credential(s) with master key %s", n, kr.KeyID())
+		log.Printf("now set %s to the new key and restart the server", secrets.EnvMasterKey)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q (available: gen-key, rotate-key, migrate, config)\n", name)
+		os.Exit(2)
+	}
+}
+
+func runMigrate(cfg *config.Config, sub string) {
+	if err := appdb.Open(cfg.DBDSN); err != nil {
+		log.Fatalf("db open failed: %v", err)
+	}
+	switch sub {
+	case "status":
+		states, err := appdb.Status()
+		if err != nil {
+			log.Fatalf("migrate status: %v", err)
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%03d  %-45s %s\n", s.Version, s.Name, applied)
+		}
+
+	case "dry-run":
+		ok, err := appdb.DryRun()
+		for _, m := range ok {
+			fmt.Printf("%03d  %-45s ok (rolled back)\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("dry run failed: %v", err)
+		}
+		if len(ok) == 0 {
+			fmt.Println("no pending migrations")
+		}
+
+	case "up":
+		n, err := appdb.Migrate()
+		if err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Printf("applied %d migration(s)\n", n)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q (available: status, dry-run, up)\n", sub)
+		os.Exit(2)
 	}
 }
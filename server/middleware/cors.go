@@ -1,20 +1,40 @@
 package middleware
 
-import "net/http"
+import (
+	"net/http"
+	"slices"
+)
 
-// AllowCORS sets CORS headers on a response writer.
-func AllowCORS(w http.ResponseWriter) {
+// allowedOrigins lists the origins allowed to call the API. "*" allows any.
+var allowedOrigins = []string{"*"}
+
+// SetAllowedOrigins replaces the list of allowed CORS origins.
+func SetAllowedOrigins(origins []string) { allowedOrigins = origins }
+
+// AllowCORS sets CORS headers on a response writer. A request from an origin
+// that is not allowed gets no Access-Control-Allow-Origin header, so the
+// browser blocks it.
+func AllowCORS(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if slices.Contains(allowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origin != "" && slices.Contains(allowedOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+	// The Tus-* and Upload-* headers belong to resumable uploads.
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Upload-Offset, Upload-Length, Upload-Expires")
 }
 
 // CORS returns a middleware that handles preflight OPTIONS requests
 // and sets CORS headers on all other requests.
 func CORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		AllowCORS(w)
+		AllowCORS(w, r)
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -0,0 +1,286 @@
+// Package secrets implements envelope encryption for stored connection
+// credentials.
+//
+// Every value is sealed with its own random 256-bit data key (AES-256-GCM),
+// and the data key is sealed with the master key. The stored form is
+//
+//	enc:v1:<master key id>:<base64 sealed data key>:<base64 sealed value>
+//
+// so a leaked database is useless without the master key. Rotating the
+// master key opens every value with the old key and seals it again, under a
+// new data key, with the new one.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const prefix = "enc:v1:"
+
+// Environment variables holding the master key, either inline or as a path
+// to a key file. The key is 32 random bytes, base64 or hex encoded.
+const (
+	EnvMasterKey        = "VESTRA_MASTER_KEY"
+	EnvMasterKeyFile    = "VESTRA_MASTER_KEY_FILE"
+	EnvNewMasterKey     = "VESTRA_NEW_MASTER_KEY"
+	EnvNewMasterKeyFile = "VESTRA_NEW_MASTER_KEY_FILE"
+)
+
+// ErrNoKey is returned when an encrypted value is read without a master key.
+var ErrNoKey = errors.New("credentials are encrypted but no master key is configured")
+
+type masterKey struct {
+	id   string
+	raw  []byte
+	aead cipher.AEAD
+}
+
+// Keyring holds the master key used for sealing plus any older keys that are
+// still accepted for opening (during rotation).
+type Keyring struct {
+	primary *masterKey
+	keys    map[string]*masterKey
+}
+
+// NewKeyring builds a keyring. The first key seals new values; all keys can
+// open existing ones. A keyring without keys stores values as plain text.
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	kr := &Keyring{keys: map[string]*masterKey{}}
+	for i, raw := range keys {
+		mk, err := newMasterKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			kr.primary = mk
+		}
+		kr.keys[mk.id] = mk
+	}
+	return kr, nil
+}
+
+func newMasterKey(raw []byte) (*masterKey, error) {
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(raw))
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &masterKey{id: hex.EncodeToString(sum[:4]), raw: raw, aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Enabled reports whether the keyring has a key to seal values with.
+func (kr *Keyring) Enabled() bool { return kr.primary != nil }
+
+// KeyID returns the ID of the sealing key, or "" when disabled.
+func (kr *Keyring) KeyID() string {
+	if kr.primary == nil {
+		return ""
+	}
+	return kr.primary.id
+}
+
+// DeriveKey returns a 32-byte key for the given purpose (e.g. signing
+// download links), derived from the primary master key so every replica
+// computes the same one. It returns nil when the keyring is disabled.
+func (kr *Keyring) DeriveKey(purpose string) []byte {
+	if kr.primary == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, kr.primary.raw)
+	mac.Write([]byte("vestra:" + purpose))
+	return mac.Sum(nil)
+}
+
+// IsEncrypted reports whether a stored value is in sealed form.
+func IsEncrypted(stored string) bool { return strings.HasPrefix(stored, prefix) }
+
+// SealedWith returns the master key ID a stored value was sealed with.
+func SealedWith(stored string) string {
+	if !IsEncrypted(stored) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(stored, prefix), ":")
+	return id
+}
+
+// Encrypt seals plaintext under the primary key. Empty values (anonymous
+// connections) and a disabled keyring pass through unchanged.
+func (kr *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" || kr.primary == nil {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedKey, err := seal(kr.primary.aead, dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := seal(dataAEAD, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return prefix + kr.primary.id + ":" +
+		base64.StdEncoding.EncodeToString(sealedKey) + ":" +
+		base64.StdEncoding.EncodeToString(sealedValue), nil
+}
+
+// Decrypt opens a stored value. Values that were never sealed (rows written
+// before encryption was enabled) are returned as is.
+func (kr *Keyring) Decrypt(stored string) (string, error) {
+	if !IsEncrypted(stored) {
+		return stored, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(stored, prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted credentials")
+	}
+	if len(kr.keys) == 0 {
+		return "", ErrNoKey
+	}
+	mk, ok := kr.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("credentials were sealed with unknown master key %s", parts[0])
+	}
+	sealedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(mk.aead, sealedKey)
+	if err != nil {
+		return "", fmt.Errorf("unseal data key: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, sealedValue)
+	if err != nil {
+		return "", fmt.Errorf("decrypt credentials: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// seal encrypts with a random nonce, which is prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// ── key loading ───────────────────────────────────────────────────
+
+// LoadKey reads a master key from the inline env var or, failing that, from
+// the key file at path. It returns nil when neither is set.
+func LoadKey(inlineVar, path string) ([]byte, error) {
+	encoded := os.Getenv(inlineVar)
+	if encoded == "" {
+		if path == "" {
+			return nil, nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read master key file: %w", err)
+		}
+		encoded = string(data)
+	}
+	key, err := ParseKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", inlineVar, err)
+	}
+	return key, nil
+}
+
+// ParseKey decodes a base64 or hex encoded 32-byte key.
+func ParseKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("master key must be base64 or hex encoded")
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// GenerateKey returns a new random master key, base64 encoded.
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ── process-wide keyring ──────────────────────────────────────────
+
+var current = &Keyring{keys: map[string]*masterKey{}}
+
+// Init loads the master key from VESTRA_MASTER_KEY or keyFile into the
+// process-wide keyring used by Encrypt and Decrypt.
+func Init(keyFile string) error {
+	key, err := LoadKey(EnvMasterKey, keyFile)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		current, _ = NewKeyring()
+		return nil
+	}
+	current, err = NewKeyring(key)
+	return err
+}
+
+// Current returns the process-wide keyring.
+func Current() *Keyring { return current }
+
+// Encrypt seals a value with the process-wide keyring.
+func Encrypt(plaintext string) (string, error) { return current.Encrypt(plaintext) }
+
+// Decrypt opens a value with the process-wide keyring.
+func Decrypt(stored string) (string, error) { return current.Decrypt(stored) }
@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+
+func mustKeyring(t *testing.T, keys ...[]byte) *Keyring {
+	t.Helper()
+	kr, err := NewKeyring(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestRoundTrip(t *testing.T) {
+	kr := mustKeyring(t, testKey(1))
+	const plain = `{"access_key_id":"AKIA","secret_access_key":"s3cr3t"}`
+	sealed, err := kr.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(sealed) || SealedWith(sealed) != kr.KeyID() || strings.Contains(sealed, "s3cr3t") {
+		t.Fatalf("Encrypt = %q, want it sealed with %s", sealed, kr.KeyID())
+	}
+	if again, _ := kr.Encrypt(plain); again == sealed {
+		t.Error("sealing a value twice gave the same ciphertext")
+	}
+	if got, err := kr.Decrypt(sealed); err != nil || got != plain {
+		t.Errorf("Decrypt = %q, %v; want %q", got, err, plain)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	sealed, err := mustKeyring(t, testKey(1)).Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mustKeyring(t, testKey(2)).Decrypt(sealed); err == nil || !strings.Contains(err.Error(), "unknown master key") {
+		t.Errorf("Decrypt with another key = %v, want an unknown key error", err)
+	}
+	if _, err := mustKeyring(t).Decrypt(sealed); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Decrypt without a key = %v, want ErrNoKey", err)
+	}
+	// A key with the same ID but other bytes still fails to open it.
+	other := mustKeyring(t, testKey(2))
+	other.keys[SealedWith(sealed)] = other.primary
+	if _, err := other.Decrypt(sealed); err == nil {
+		t.Error("Decrypt with the wrong key bytes succeeded")
+	}
+}
+
+func TestTampered(t *testing.T) {
+	kr := mustKeyring(t, testKey(1))
+	sealed, err := kr.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, prefix), ":")
+	flip := func(part int) string {
+		p := append([]string(nil), parts...)
+		raw, _ := base64.StdEncoding.DecodeString(p[part])
+		raw[len(raw)-1] ^= 1
+		p[part] = base64.StdEncoding.EncodeToString(raw)
+		return prefix + strings.Join(p, ":")
+	}
+	for name, stored := range map[string]string{
+		"data key":  flip(1),
+		"value":     flip(2),
+		"truncated": prefix + parts[0] + ":" + parts[1] + ":AAAA",
+		"malformed": prefix + parts[0] + ":" + parts[1],
+		"base64":    prefix + parts[0] + ":" + parts[1] + ":!!",
+	} {
+		if got, err := kr.Decrypt(stored); err == nil {
+			t.Errorf("%s: Decrypt = %q, want an error", name, got)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldKr := mustKeyring(t, testKey(1))
+	sealed, err := oldKr.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The new key seals; the old one still opens what it sealed.
+	kr := mustKeyring(t, testKey(2), testKey(1))
+	if kr.KeyID() == oldKr.KeyID() {
+		t.Fatal("the new keyring seals with the old key")
+	}
+	if got, err := kr.Decrypt(sealed); err != nil || got != "secret" {
+		t.Fatalf("Decrypt of an old value = %q, %v", got, err)
+	}
+	resealed, err := kr.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SealedWith(resealed) != kr.KeyID() {
+		t.Errorf("resealed with %s, want %s", SealedWith(resealed), kr.KeyID())
+	}
+	if _, err := oldKr.Decrypt(resealed); err == nil {
+		t.Error("the old key opens a value sealed with the new one")
+	}
+}
+
+func TestPlaintextPassthrough(t *testing.T) {
+	disabled := mustKeyring(t)
+	if disabled.Enabled() || disabled.KeyID() != "" || disabled.DeriveKey("links") != nil {
+		t.Error("a keyring without keys is enabled")
+	}
+	if got, err := disabled.Encrypt(`{"a":1}`); err != nil || got != `{"a":1}` {
+		t.Errorf("Encrypt without a key = %q, %v; want it unchanged", got, err)
+	}
+	kr := mustKeyring(t, testKey(1))
+	if got, err := kr.Encrypt(""); err != nil || got != "" {
+		t.Errorf("Encrypt of an empty value = %q, %v; want it empty", got, err)
+	}
+	// Rows written before encryption was enabled are read as they are.
+	for _, k := range []*Keyring{disabled, kr} {
+		if got, err := k.Decrypt(`{"a":1}`); err != nil || got != `{"a":1}` {
+			t.Errorf("Decrypt of plain text = %q, %v; want it unchanged", got, err)
+		}
+	}
+	if SealedWith(`{"a":1}`) != "" {
+		t.Error("plain text reports a sealing key")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	raw := testKey(7)
+	for _, encoded := range []string{
+		base64.StdEncoding.EncodeToString(raw),
+		"  " + strings.Repeat("07", 32) + "\n",
+	} {
+		if key, err := ParseKey(encoded); err != nil || !bytes.Equal(key, raw) {
+			t.Errorf("ParseKey(%q) = %x, %v", encoded, key, err)
+		}
+	}
+	for _, encoded := range []string{"", "not a key", base64.StdEncoding.EncodeToString(raw[:16])} {
+		if _, err := ParseKey(encoded); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", encoded)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package storage
+
+import "fmt"
+
+// ambientCredentials reports whether connections may authenticate as the
+// server itself (environment, shared config files, instance or workload
+// identity) instead of carrying their own secrets.
+var ambientCredentials bool
+
+// SetAmbientCredentials allows or forbids connections that use the server's
+// own cloud credentials.
+func SetAmbientCredentials(allow bool) {
+	ambientCredentials = allow
+}
+
+// requireAmbient fails unless ambient credentials are allowed. what names
+// the setting that needs them, for the error message.
+func requireAmbient(what string) error {
+	if !ambientCredentials {
+		return invalid(fmt.Errorf("%s uses the server's own credentials, which is disabled; set ambient_credentials in the server configuration", what))
+	}
+	return nil
+}
@@ -0,0 +1,623 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	azservice "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+func init() {
+	Register("azure", newAzureBackend)
+}
+
+type azureBackend struct {
+	client    *azcontainer.Client
+	container string
+	// With an AAD credential, download URLs are signed with a user
+	// delegation key from the service client. Shared-key clients sign
+	// their own; SAS connections stream downloads instead.
+	token   azcore.TokenCredential
+	service *azservice.Client
+}
+
+func strPtr(s string) *string { return &s }
+func i32Ptr(i int32) *int32   { return &i }
+
+// azureStorageScope is the AAD scope for Blob storage.
+const azureStorageScope = "https://storage.azure.com/.default"
+
+type azureCredentials struct {
+	AccountName      string `json:"account_name"`
+	AccountKey       string `json:"account_key"`
+	ConnectionString string `json:"connection_string"`
+	SASURL           string `json:"sas_url"`
+	TenantID         string `json:"tenant_id"`
+	ClientID         string `json:"client_id"`
+	ClientSecret     string `json:"client_secret"`
+	CredentialSource string `json:"credential_source"`
+	// Endpoint overrides https://{account_name}.blob.core.windows.net, for
+	// sovereign clouds and emulators.
+	Endpoint string `json:"endpoint"`
+}
+
+// newAzureBackend accepts, in order of precedence: a SAS URL, a connection
+// string, an account key, or an AAD identity (a service principal, the
+// managed identity, or the default chain).
+func newAzureBackend(ctx context.Context, container, credentials string) (Backend, error) {
+	var creds azureCredentials
+	if err := json.Unmarshal([]byte(credentials), &creds); err != nil {
+		return nil, invalid(err)
+	}
+	b := &azureBackend{container: container}
+	var err error
+	switch {
+	case creds.SASURL != "":
+		b.client, err = azureSASClient(creds.SASURL, container)
+	case creds.ConnectionString != "":
+		b.client, err = azcontainer.NewClientFromConnectionString(creds.ConnectionString, container, nil)
+	case creds.AccountKey != "":
+		if creds.AccountName == "" {
+			return nil, invalid(errors.New("missing account_name"))
+		}
+		var cred *azcontainer.SharedKeyCredential
+		if cred, err = azcontainer.NewSharedKeyCredential(creds.AccountName, creds.AccountKey); err != nil {
+			return nil, invalid(err)
+		}
+		b.client, err = azcontainer.NewClientWithSharedKeyCredential(creds.serviceURL()+container, cred, nil)
+	case creds.ClientSecret != "" || creds.CredentialSource != "":
+		if creds.AccountName == "" && creds.Endpoint == "" {
+			return nil, invalid(errors.New("missing account_name"))
+		}
+		if b.token, err = azureTokenCredential(creds); err != nil {
+			return nil, err
+		}
+		if b.service, err = azservice.NewClient(creds.serviceURL(), b.token, nil); err != nil {
+			return nil, invalid(err)
+		}
+		b.client = b.service.NewContainerClient(container)
+	default:
+		return nil, invalid(errors.New("missing credentials: give account_name and account_key, a connection_string, a sas_url, " +
+			"a service principal (tenant_id, client_id, client_secret) or a credential_source"))
+	}
+	if err != nil {
+		// The client constructors only parse their arguments.
+		return nil, invalid(err)
+	}
+	return b, nil
+}
+
+func (c azureCredentials) serviceURL() string {
+	if c.Endpoint != "" {
+		return strings.TrimSuffix(c.Endpoint, "/") + "/"
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net/", c.AccountName)
+}
+
+// azureSASClient builds a client from a SAS URL. A container SAS names the
+// container itself; an account SAS gets the connection's container added.
+// Expired tokens are rejected up front rather than failing every request.
+func azureSASClient(sasURL, container string) (*azcontainer.Client, error) {
+	u, err := url.Parse(sasURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("sas_url must be an https:// URL, got %q", sasURL)
+	}
+	q := u.Query()
+	if q.Get("sig") == "" {
+		return nil, errors.New("sas_url has no SAS token (sig parameter)")
+	}
+	if se, err := time.Parse(time.RFC3339, q.Get("se")); err == nil && time.Now().After(se) {
+		return nil, &Error{Code: CodeAccessDenied, Err: fmt.Errorf("the SAS token expired at %s", se.UTC().Format(time.RFC3339))}
+	}
+	if q.Get("sr") == "c" {
+		name := u.Path[strings.LastIndex(u.Path, "/")+1:]
+		if container != "" && container != name {
+			return nil, fmt.Errorf("sas_url is for container %q, not %q", name, container)
+		}
+	} else {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + container
+	}
+	return azcontainer.NewClientWithNoCredential(u.String(), nil)
+}
+
+// azureTokens shares AAD credentials between the backends of a connection,
+// so their token caches survive from one request to the next.
+var azureTokens = struct {
+	sync.Mutex
+	m map[[sha256.Size]byte]azcore.TokenCredential
+}{m: map[[sha256.Size]byte]azcore.TokenCredential{}}
+
+func azureTokenCredential(c azureCredentials) (azcore.TokenCredential, error) {
+	var build func() (azcore.TokenCredential, error)
+	switch c.CredentialSource {
+	case "":
+		if c.TenantID == "" || c.ClientID == "" {
+			return nil, invalid(errors.New("a service principal needs tenant_id, client_id and client_secret"))
+		}
+		build = func() (azcore.TokenCredential, error) {
+			cred, err := azidentity.NewClientSecretCredential(c.TenantID, c.ClientID, c.ClientSecret, nil)
+			if err != nil {
+				return nil, invalid(err)
+			}
+			return cred, nil
+		}
+	case "managed_identity":
+		if err := requireAmbient(`credential_source "managed_identity"`); err != nil {
+			return nil, err
+		}
+		build = func() (azcore.TokenCredential, error) {
+			opts := &azidentity.ManagedIdentityCredentialOptions{}
+			if c.ClientID != "" {
+				opts.ID = azidentity.ClientID(c.ClientID)
+			}
+			return azidentity.NewManagedIdentityCredential(opts)
+		}
+	case "default":
+		if err := requireAmbient(`credential_source "default"`); err != nil {
+			return nil, err
+		}
+		build = func() (azcore.TokenCredential, error) {
+			return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{TenantID: c.TenantID})
+		}
+	default:
+		return nil, invalid(fmt.Errorf(`credential_source must be "managed_identity" or "default", got %q`, c.CredentialSource))
+	}
+
+	raw, _ := json.Marshal(c)
+	key := sha256.Sum256(raw)
+	azureTokens.Lock()
+	defer azureTokens.Unlock()
+	if cred, ok := azureTokens.m[key]; ok {
+		return cred, nil
+	}
+	cred, err := build()
+	if err != nil {
+		return nil, err
+	}
+	azureTokens.m[key] = cred
+	return cred, nil
+}
+
+func (b *azureBackend) Test(ctx context.Context) error {
+	pager := b.client.NewListBlobsFlatPager(&azcontainer.ListBlobsFlatOptions{MaxResults: i32Ptr(1)})
+	_, err := pager.NextPage(ctx)
+	return err
+}
+
+func (b *azureBackend) List(ctx context.Context, prefix, pageToken string, limit int) (*Page, error) {
+	opts := &azcontainer.ListBlobsHierarchyOptions{
+		Prefix:     strPtr(prefix),
+		MaxResults: i32Ptr(int32(limit)),
+	}
+	if pageToken != "" {
+		opts.Marker = strPtr(pageToken)
+	}
+	pager := b.client.NewListBlobsHierarchyPager("/", opts)
+
+	page := &Page{Entries: []Entry{}}
+	if !pager.More() {
+		return page, nil
+	}
+	resp, err := pager.NextPage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range resp.Segment.BlobPrefixes {
+		if p.Name == nil {
+			continue
+		}
+		page.Entries = append(page.Entries, Entry{Type: "dir", Name: *p.Name, Display: display(*p.Name, prefix)})
+	}
+	for _, item := range resp.Segment.BlobItems {
+		if item.Name == nil || *item.Name == prefix {
+			continue
+		}
+		e := Entry{Type: "file", Name: *item.Name, Display: strings.TrimPrefix(*item.Name, prefix)}
+		if item.Properties != nil {
+			if item.Properties.ContentLength != nil {
+				e.Size = *item.Properties.ContentLength
+			}
+			if item.Properties.LastModified != nil {
+				e.Updated = *item.Properties.LastModified
+			}
+			if item.Properties.ContentType != nil {
+				e.ContentType = *item.Properties.ContentType
+			}
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if resp.NextMarker != nil {
+		page.NextPageToken = *resp.NextMarker
+	}
+	return page, nil
+}
+
+func (b *azureBackend) Walk(ctx context.Context, prefix string, fn func(Object) error) error {
+	opts := &azcontainer.ListBlobsFlatOptions{MaxResults: i32Ptr(1000)}
+	if prefix != "" {
+		opts.Prefix = strPtr(prefix)
+	}
+	pager := b.client.NewListBlobsFlatPager(opts)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range resp.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			obj := Object{Name: *item.Name}
+			if item.Properties != nil {
+				if item.Properties.ContentLength != nil {
+					obj.Size = *item.Properties.ContentLength
+				}
+				if item.Properties.LastModified != nil {
+					obj.Updated = *item.Properties.LastModified
+				}
+				if item.Properties.ContentType != nil {
+					obj.ContentType = *item.Properties.ContentType
+				}
+			}
+			err := fn(obj)
+			if err == StopWalk {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *azureBackend) Stat(ctx context.Context, key string) (*Metadata, error) {
+	resp, err := b.client.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	md := &Metadata{Metadata: fromAzureMetadata(resp.Metadata)}
+	if resp.ContentType != nil {
+		md.ContentType = *resp.ContentType
+	}
+	if resp.CacheControl != nil {
+		md.CacheControl = *resp.CacheControl
+	}
+	if resp.ETag != nil {
+		md.ETag = strings.Trim(string(*resp.ETag), `"`)
+	}
+	if resp.ContentLength != nil {
+		md.Size = *resp.ContentLength
+	}
+	if resp.LastModified != nil {
+		md.Updated = *resp.LastModified
+	}
+	if len(resp.ContentMD5) > 0 {
+		md.MD5 = fmt.Sprintf("%x", resp.ContentMD5)
+	}
+	return md, nil
+}
+
+func (b *azureBackend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.client.NewBlobClient(key).DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *azureBackend) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	// A zero Count reads to the end.
+	resp, err := b.client.NewBlobClient(key).DownloadStream(ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset, Count: max(length, 0)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put stages r as blocks and commits them once all are uploaded, so a failed
+// upload leaves the existing blob untouched.
+func (b *azureBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := b.client.NewBlockBlobClient(key).UploadStream(ctx, r, &blockblob.UploadStreamOptions{
+		BlockSize:   uploadPartSize,
+		Concurrency: uploadConcurrency,
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: strPtr(contentType)},
+	})
+	return err
+}
+
+// Resumable uploads stage one block per part and commit the list at the
+// end. The upload ID is a random prefix shared by its block IDs, which have
+// the same 64-byte layout as the SDK's own. Azure discards uncommitted
+// blocks after a week, so there is nothing to abort.
+func (b *azureBackend) StartUpload(ctx context.Context, u *Upload) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	u.ID = hex.EncodeToString(id)
+	return nil
+}
+
+func azureBlockID(u Upload, n int) string {
+	var id [64]byte
+	prefix, _ := hex.DecodeString(u.ID)
+	copy(id[:16], prefix)
+	binary.BigEndian.PutUint32(id[16:], uint32(n))
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+func (b *azureBackend) UploadPart(ctx context.Context, u Upload, n int, offset int64, r io.ReadSeeker, size int64) (string, error) {
+	id := azureBlockID(u, n)
+	_, err := b.client.NewBlockBlobClient(u.Key).StageBlock(ctx, id, streaming.NopCloser(r), nil)
+	return id, err
+}
+
+func (b *azureBackend) CompleteUpload(ctx context.Context, u Upload, tags []string) error {
+	_, err := b.client.NewBlockBlobClient(u.Key).CommitBlockList(ctx, tags, &blockblob.CommitBlockListOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: strPtr(u.ContentType)},
+	})
+	return err
+}
+
+func (b *azureBackend) AbortUpload(ctx context.Context, u Upload) error { return nil }
+
+// azureCopyPoll bounds the interval between checks on a pending copy.
+var azureCopyPoll = [2]time.Duration{200 * time.Millisecond, 2 * time.Second}
+
+// Copy starts a server-side copy and waits for it to finish: a copy within
+// one account usually completes at once, but may be left pending, and only
+// the destination's properties say when it is done. A copy still pending
+// when ctx ends is aborted.
+func (b *azureBackend) Copy(ctx context.Context, src, dst string) error {
+	client := b.client.NewBlobClient(dst)
+	// The source URL carries the connection's SAS token, if it has one.
+	resp, err := client.StartCopyFromURL(ctx, b.client.NewBlobClient(src).URL(), nil)
+	if err != nil {
+		return err
+	}
+	status, description := resp.CopyStatus, (*string)(nil)
+	defer func() {
+		if ctx.Err() != nil && resp.CopyID != nil && status != nil && *status == blob.CopyStatusTypePending {
+			// ctx is done, so the abort gets its own.
+			abortCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_, _ = client.AbortCopyFromURL(abortCtx, *resp.CopyID, nil)
+		}
+	}()
+	wait := azureCopyPoll[0]
+	for status != nil && *status == blob.CopyStatusTypePending {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait = min(2*wait, azureCopyPoll[1])
+		props, err := client.GetProperties(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if props.CopyID != nil && resp.CopyID != nil && *props.CopyID != *resp.CopyID {
+			return &Error{Code: CodeConflict, Err: fmt.Errorf("another copy to %s replaced this one", dst)}
+		}
+		status, description = props.CopyStatus, props.CopyStatusDescription
+	}
+	if status == nil || *status == blob.CopyStatusTypeSuccess {
+		return nil
+	}
+	err = fmt.Errorf("the copy to %s %s", dst, *status)
+	if description != nil && *description != "" {
+		err = fmt.Errorf("%w: %s", err, *description)
+	}
+	return &Error{Code: CodeInternal, Details: map[string]any{"copy_status": string(*status)}, Err: err}
+}
+
+func (b *azureBackend) Delete(ctx context.Context, key string) error {
+	_, err := b.client.NewBlobClient(key).Delete(ctx, nil)
+	return err
+}
+
+func (b *azureBackend) DeleteBatchSize() int { return 256 }
+
+// DeleteBatch sends a blob batch request, which the Azurite emulator and
+// some older accounts do not support; those get ErrNotSupported.
+func (b *azureBackend) DeleteBatch(ctx context.Context, keys []string) (map[string]error, error) {
+	bb, err := b.client.NewBatchBuilder()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if err := bb.Delete(k, nil); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := b.client.SubmitBatch(ctx, bb, nil)
+	if err != nil {
+		if Classify("azure", err).Code == CodeInvalid {
+			return nil, fmt.Errorf("%w: %v", ErrNotSupported, err)
+		}
+		return nil, err
+	}
+	failed := map[string]error{}
+	for _, item := range resp.Responses {
+		if item.Error != nil && item.BlobName != nil {
+			failed[*item.BlobName] = item.Error
+		}
+	}
+	return failed, nil
+}
+
+// Presign returns a read-only blob SAS URL, signed with the account key or
+// with a user delegation key for AAD connections. SAS connections stream
+// downloads instead: handing out their own token would also hand out
+// whatever else it allows.
+func (b *azureBackend) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return b.sasURL(ctx, key, sas.BlobPermissions{Read: true}, expires)
+}
+
+// The backend is no UploadPresigner: a SAS cannot limit the size or content
+// type of what is written with it, and stays valid after the upload, so a
+// client could store anything past max_upload_size. Uploads go through the
+// server instead.
+
+// sasURL signs a SAS for one blob, as described for Presign.
+func (b *azureBackend) sasURL(ctx context.Context, key string, perms sas.BlobPermissions, expires time.Duration) (string, error) {
+	blobClient := b.client.NewBlobClient(key)
+	start := time.Now().UTC().Add(-10 * time.Second)
+	expiry := time.Now().UTC().Add(expires)
+	if b.service == nil {
+		u, err := blobClient.GetSASURL(perms, expiry, &blob.GetSASURLOptions{StartTime: &start})
+		if errors.Is(err, bloberror.MissingSharedKeyCredential) {
+			return "", ErrNotSupported
+		}
+		return u, err
+	}
+
+	udc, err := b.service.GetUserDelegationCredential(ctx, azservice.KeyInfo{
+		Start:  strPtr(start.Format(sas.TimeFormat)),
+		Expiry: strPtr(expiry.Format(sas.TimeFormat)),
+	}, nil)
+	if err != nil {
+		// Usually a missing "Storage Blob Delegator" role; downloads
+		// still work when streamed.
+		return "", fmt.Errorf("%w: cannot get a user delegation key: %v", ErrNotSupported, err)
+	}
+	qp, err := sas.BlobSignatureValues{
+		StartTime:     start,
+		ExpiryTime:    expiry,
+		Permissions:   perms.String(),
+		ContainerName: b.container,
+		BlobName:      key,
+	}.SignWithUserDelegation(udc)
+	if err != nil {
+		return "", err
+	}
+	return blobClient.URL() + "?" + qp.Encode(), nil
+}
+
+// UpdateMetadata patches the blob in place (no copy-to-self needed).
+func (b *azureBackend) UpdateMetadata(ctx context.Context, key string, u MetadataUpdate) error {
+	blobClient := b.client.NewBlobClient(key)
+
+	headers := blob.HTTPHeaders{}
+	if u.ContentType != "" {
+		headers.BlobContentType = strPtr(u.ContentType)
+	}
+	if u.CacheControl != "" {
+		headers.BlobCacheControl = strPtr(u.CacheControl)
+	}
+	if _, err := blobClient.SetHTTPHeaders(ctx, headers, nil); err != nil {
+		return err
+	}
+	if u.Metadata != nil {
+		if _, err := blobClient.SetMetadata(ctx, toAzureMetadata(u.Metadata), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *azureBackend) Close() error { return nil }
+
+// CredentialsExpiry reports when the AAD access token expires; the SDK
+// renews it before then. Key and SAS connections report nothing.
+func (b *azureBackend) CredentialsExpiry(ctx context.Context) (time.Time, error) {
+	if b.token == nil {
+		return time.Time{}, nil
+	}
+	tok, err := b.token.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureStorageScope}})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return tok.ExpiresOn, nil
+}
+
+func toAzureMetadata(m map[string]string) map[string]*string {
+	result := make(map[string]*string, len(m))
+	for k, v := range m {
+		result[k] = strPtr(v)
+	}
+	return result
+}
+
+func fromAzureMetadata(m map[string]*string) map[string]string {
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		if v != nil {
+			result[k] = *v
+		}
+	}
+	return result
+}
+
+// azureCodes maps Blob service error codes to storage codes.
+var azureCodes = map[string]string{
+	"BlobNotFound":                    CodeNotFound,
+	"ContainerNotFound":               CodeNotFound,
+	"ResourceNotFound":                CodeNotFound,
+	"AuthenticationFailed":            CodeAccessDenied,
+	"AuthorizationFailure":            CodeAccessDenied,
+	"AuthorizationPermissionMismatch": CodeAccessDenied,
+	"AuthorizationSourceIPMismatch":   CodeAccessDenied,
+	"InsufficientAccountPermissions":  CodeAccessDenied,
+	"AccountIsDisabled":               CodeAccessDenied,
+	"BlobAlreadyExists":               CodeConflict,
+	"ContainerAlreadyExists":          CodeConflict,
+	"ContainerBeingDeleted":           CodeConflict,
+	"ConditionNotMet":                 CodeConflict,
+	"TargetConditionNotMet":           CodeConflict,
+	"LeaseIdMissing":                  CodeConflict,
+	"LeaseAlreadyPresent":             CodeConflict,
+	"PendingCopyOperation":            CodeConflict,
+	"ServerBusy":                      CodeThrottled,
+	"OperationTimedOut":               CodeTimeout,
+	"InternalError":                   CodeUnavailable,
+	"InvalidBlobOrBlock":              CodeInvalid,
+	"InvalidQueryParameterValue":      CodeInvalid,
+}
+
+func init() {
+	registerClassifier(func(err error) (string, map[string]any, bool) {
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) {
+			return "", nil, false
+		}
+		details := map[string]any{"status": respErr.StatusCode}
+		if respErr.ErrorCode != "" {
+			details["provider_code"] = respErr.ErrorCode
+		}
+		if respErr.RawResponse != nil {
+			if id := respErr.RawResponse.Header.Get("x-ms-request-id"); id != "" {
+				details["request_id"] = id
+			}
+		}
+		code, ok := azureCodes[respErr.ErrorCode]
+		if !ok {
+			code = codeForStatus(respErr.StatusCode)
+		}
+		return code, details, true
+	})
+}
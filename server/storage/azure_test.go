@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAzureCopy answers the blob requests of a server-side copy: the start
+// of the copy is pending, and each check of the destination reports the
+// next of statuses, the last one repeating.
+type fakeAzureCopy struct {
+	mu       sync.Mutex
+	statuses []string
+	checks   int
+	aborted  bool
+}
+
+func (f *fakeAzureCopy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := w.Header()
+	h.Set("x-ms-copy-id", "copy-1")
+	switch {
+	case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "copy":
+		f.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+		h.Set("x-ms-copy-status", "pending")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodHead:
+		status := f.statuses[min(f.checks, len(f.statuses)-1)]
+		f.checks++
+		h.Set("x-ms-copy-status", status)
+		if status == "failed" {
+			h.Set("x-ms-copy-status-description", "500 InternalError")
+		}
+		h.Set("Content-Length", "3")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func openAzureFake(t *testing.T, f *fakeAzureCopy) Backend {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	creds, _ := json.Marshal(map[string]string{"sas_url": srv.URL + "/?sv=2022-11-02&ss=b&srt=sco&sp=rwdlc&sig=x"})
+	b, err := Open(context.Background(), "azure", "files", string(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestAzureCopyWaits(t *testing.T) {
+	poll := azureCopyPoll
+	azureCopyPoll = [2]time.Duration{time.Millisecond, 5 * time.Millisecond}
+	t.Cleanup(func() { azureCopyPoll = poll })
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		f := &fakeAzureCopy{statuses: []string{"pending", "pending", "success"}}
+		if err := openAzureFake(t, f).Copy(ctx, "a.txt", "b.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if f.checks != 3 {
+			t.Errorf("checked the copy %d times, want 3", f.checks)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		f := &fakeAzureCopy{statuses: []string{"pending", "failed"}}
+		err := openAzureFake(t, f).Copy(ctx, "a.txt", "b.txt")
+		var e *Error
+		if !errors.As(err, &e) || e.Details["copy_status"] != "failed" {
+			t.Fatalf("Copy = %v, want a failed copy", err)
+		}
+	})
+
+	t.Run("aborted", func(t *testing.T) {
+		f := &fakeAzureCopy{statuses: []string{"aborted"}}
+		if err := openAzureFake(t, f).Copy(ctx, "a.txt", "b.txt"); err == nil {
+			t.Fatal("Copy succeeded, want an aborted copy")
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		f := &fakeAzureCopy{statuses: []string{"pending"}}
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if err := openAzureFake(t, f).Copy(ctx, "a.txt", "b.txt"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Copy = %v, want the deadline", err)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if !f.aborted {
+			t.Error("the pending copy was not aborted")
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// deleteConcurrency is how many deletes (or batches) DeleteMany sends at a
+// time.
+const deleteConcurrency = 8
+
+// DeleteMany deletes keys and calls done for each, with nil on success.
+// Backends that delete in batches get a request per DeleteBatchSize keys;
+// the others get one per key. done may be called from several goroutines at
+// once. DeleteMany returns early only when ctx is done; keys it did not get
+// to are not reported.
+func DeleteMany(ctx context.Context, b Backend, keys []string, done func(key string, err error)) {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, deleteConcurrency)
+	)
+	run := func(f func()) bool {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			f()
+		}()
+		return true
+	}
+	defer wg.Wait()
+
+	if bd, ok := b.(BatchDeleter); ok {
+		n := bd.DeleteBatchSize()
+		var unsupported bool
+		var mu sync.Mutex
+		for len(keys) > 0 {
+			batch := keys[:min(n, len(keys))]
+			keys = keys[len(batch):]
+			ok := run(func() {
+				failed, err := bd.DeleteBatch(ctx, batch)
+				if errors.Is(err, ErrNotSupported) {
+					mu.Lock()
+					unsupported = true
+					mu.Unlock()
+					for _, k := range batch {
+						done(k, b.Delete(ctx, k))
+					}
+					return
+				}
+				for _, k := range batch {
+					if err == nil {
+						done(k, failed[k])
+					} else {
+						done(k, err)
+					}
+				}
+			})
+			if !ok {
+				return
+			}
+			mu.Lock()
+			stop := unsupported
+			mu.Unlock()
+			if stop {
+				break
+			}
+		}
+	}
+	for _, k := range keys {
+		if !run(func() { done(k, b.Delete(ctx, k)) }) {
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// OpenRange reads length bytes of key starting at offset, or everything from
+// offset when length is -1. It asks the provider for just that range where
+// it can, seeks in files, and otherwise reads and discards the bytes before
+// offset.
+func OpenRange(ctx context.Context, b Backend, key string, offset, length int64) (io.ReadCloser, error) {
+	if r, ok := b.(RangeOpener); ok {
+		return r.OpenRange(ctx, key, offset, length)
+	}
+	rc, err := b.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return skipAndLimit(rc, offset, length)
+}
+
+// skipAndLimit positions rc at offset and cuts it off after length bytes.
+func skipAndLimit(rc io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		var err error
+		if s, ok := rc.(io.Seeker); ok {
+			_, err = s.Seek(offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, rc, offset)
+		}
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return rc, nil
+	}
+	return limitedReadCloser{io.LimitReader(rc, length), rc}, nil
+}
+
+// httpRange formats a Range header value.
+func httpRange(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
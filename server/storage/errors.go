@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Error codes shared by all providers. Handlers map them to HTTP statuses,
+// and clients can branch on them instead of parsing SDK messages.
+const (
+	CodeInvalid      = "invalid_request"
+	CodeAccessDenied = "access_denied"
+	CodeNotFound     = "not_found"
+	CodeConflict     = "conflict"
+	CodeThrottled    = "throttled"
+	CodeTimeout      = "timeout"
+	CodeCanceled     = "canceled"
+	CodeUnavailable  = "unavailable"
+	CodeNotSupported = "not_supported"
+	CodeInternal     = "internal"
+	// CodeInsufficientStorage is a store with no room left for the object.
+	CodeInsufficientStorage = "insufficient_storage"
+)
+
+// Error is a provider error translated to a shared code. Details carries
+// what the provider reported (its own error code, HTTP status, request ID).
+type Error struct {
+	Code      string
+	Provider  string
+	Retryable bool
+	Details   map[string]any
+	Err       error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// classifier recognises the errors of one SDK. It reports ok=false for
+// errors it does not know.
+type classifier func(err error) (code string, details map[string]any, ok bool)
+
+var classifiers []classifier
+
+func registerClassifier(c classifier) { classifiers = append(classifiers, c) }
+
+// Classify translates an error returned by a backend of the given provider.
+// Unknown errors are classified as CodeInternal.
+func Classify(provider string, err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		c := *e
+		if c.Provider == "" {
+			c.Provider = provider
+		}
+		c.Retryable = c.Retryable || retryable(c.Code)
+		return &c
+	}
+	code, details := CodeInternal, map[string]any(nil)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = CodeTimeout
+	case errors.Is(err, context.Canceled):
+		code = CodeCanceled
+	case errors.Is(err, ErrNotSupported):
+		code = CodeNotSupported
+	default:
+		matched := false
+		for _, c := range classifiers {
+			if cc, d, ok := c(err); ok {
+				code, details, matched = cc, d, true
+				break
+			}
+		}
+		var netErr net.Error
+		if !matched && errors.As(err, &netErr) {
+			code = CodeUnavailable
+		}
+	}
+	return &Error{Code: code, Provider: provider, Retryable: retryable(code), Details: details, Err: err}
+}
+
+// invalid marks an error caused by the request or connection settings
+// rather than by the provider.
+func invalid(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Code: CodeInvalid, Err: err}
+}
+
+func retryable(code string) bool {
+	switch code {
+	case CodeThrottled, CodeTimeout, CodeUnavailable:
+		return true
+	}
+	return false
+}
+
+// codeForStatus is the fallback when a provider's own error code is unknown.
+func codeForStatus(status int) string {
+	switch {
+	case status == 400 || status == 411 || status == 413 || status == 416:
+		return CodeInvalid
+	case status == 401 || status == 403:
+		return CodeAccessDenied
+	case status == 404:
+		return CodeNotFound
+	case status == 409 || status == 412:
+		return CodeConflict
+	case status == 408:
+		return CodeTimeout
+	case status == 429:
+		return CodeThrottled
+	case status >= 500:
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"strings"
+)
+
+// Helpers shared by the adapters for file-system-like services (local
+// disk, SFTP, WebDAV), where keys are paths and folders really exist.
+
+// checkKey rejects keys that could climb out of the connection's root.
+func checkKey(key string) error {
+	for _, seg := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if seg == ".." || seg == "." || strings.ContainsRune(seg, '\\') {
+			return &Error{Code: CodeInvalid, Err: fmt.Errorf("invalid object name %q", key)}
+		}
+	}
+	return nil
+}
+
+// splitPrefix splits a listing prefix into the directory key ("a/b/") and
+// the partial name inside it ("fo").
+func splitPrefix(prefix string) (dirKey, namePrefix string) {
+	i := strings.LastIndex(prefix, "/")
+	return prefix[:i+1], prefix[i+1:]
+}
+
+// parentKey returns the folder key holding key ("" at the root).
+func parentKey(key string) string {
+	dir, _ := splitPrefix(strings.TrimSuffix(key, "/"))
+	return dir
+}
+
+// guessContentType derives a content type from the key's extension.
+func guessContentType(key string) string {
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// listDir builds one page of a directory listing. names must be sorted;
+// the page token is the last name returned. stat is only called for the
+// entries that end up on the page, and entries it fails on are skipped.
+func listDir(names []string, dirKey, namePrefix, pageToken string, limit int,
+	stat func(name string) (fs.FileInfo, error), contentType func(key string) string) *Page {
+	page := &Page{Entries: []Entry{}}
+	last := ""
+	for _, name := range names {
+		if !strings.HasPrefix(name, namePrefix) || (pageToken != "" && name <= pageToken) {
+			continue
+		}
+		if len(page.Entries) == limit {
+			page.NextPageToken = last
+			break
+		}
+		info, err := stat(name)
+		if err != nil {
+			continue
+		}
+		key := dirKey + name
+		if info.IsDir() {
+			page.Entries = append(page.Entries, Entry{Type: "dir", Name: key + "/", Display: name})
+		} else {
+			page.Entries = append(page.Entries, Entry{
+				Type:        "file",
+				Name:        key,
+				Display:     name,
+				Size:        info.Size(),
+				Updated:     info.ModTime().UTC(),
+				ContentType: contentType(key),
+			})
+		}
+		last = name
+	}
+	return page
+}
+
+// fileETag is a cheap change detector for files without a stored hash.
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+}
+
+// fsError maps file-system errors to storage codes.
+func fsError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return &Error{Code: CodeNotFound, Err: err}
+	case errors.Is(err, fs.ErrPermission):
+		return &Error{Code: CodeAccessDenied, Err: err}
+	case errors.Is(err, fs.ErrExist):
+		return &Error{Code: CodeConflict, Err: err}
+	}
+	return err
+}
+
+// contextReader stops a copy once ctx is done, for sources that don't
+// watch the context themselves.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,487 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+func init() {
+	Register("gcp", newGCSBackend)
+}
+
+type gcsBackend struct {
+	client    *gcs.Client
+	bucket    string
+	anonymous bool
+	// With impersonation, signed URLs are signed as the target account by
+	// the IAM Credentials API, called with the base credentials.
+	signAs string
+	iam    *iamcredentials.Service
+	// keyless connections have no private key of their own; signing may
+	// fail for them, and downloads then stream through the server.
+	keyless bool
+	// opts authenticate the raw HTTP calls of resumable uploads, which the
+	// client library does not expose across processes.
+	opts []option.ClientOption
+	http *http.Client
+}
+
+// gcsScopes are requested for impersonated tokens.
+var gcsScopes = []string{gcs.ScopeFullControl}
+
+// newGCSBackend picks the credential mode from the credentials:
+//
+//   - empty: anonymous access to a public bucket
+//   - a Google credentials file, usually a service account key
+//   - {"credential_source": "default"}: Application Default Credentials
+//   - access_key_id / secret_access_key: HMAC keys, used through the S3
+//     XML API
+//
+// The second and third can add "impersonate_service_account" to act as
+// another service account.
+func newGCSBackend(ctx context.Context, bucket, credentials string) (Backend, error) {
+	if strings.TrimSpace(credentials) == "" {
+		opts := []option.ClientOption{option.WithoutAuthentication()}
+		client, err := gcs.NewClient(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &gcsBackend{client: client, bucket: bucket, anonymous: true, opts: opts}, nil
+	}
+
+	// Decode loosely: in external_account files "credential_source" is an
+	// object, not a string.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(credentials), &fields); err != nil {
+		return nil, invalid(err)
+	}
+	str := func(key string) string {
+		var v string
+		_ = json.Unmarshal(fields[key], &v)
+		return v
+	}
+	if str("access_key_id") != "" {
+		return s3Factory("gcs")(ctx, bucket, credentials)
+	}
+
+	var base []option.ClientOption
+	keyless := true
+	switch typ := str("type"); {
+	case typ == "" && str("credential_source") == "default":
+		if err := requireAmbient(`credential_source "default"`); err != nil {
+			return nil, err
+		}
+	case typ == "":
+		return nil, invalid(errors.New(`credentials must be a service account key, {"credential_source": "default"} or HMAC access_key_id / secret_access_key`))
+	case typ == "external_account":
+		// Workload identity federation reads tokens from files and URLs
+		// on the server host.
+		if err := requireAmbient(`credential type "external_account"`); err != nil {
+			return nil, err
+		}
+		base = append(base, option.WithCredentialsJSON([]byte(credentials)))
+	default:
+		base = append(base, option.WithCredentialsJSON([]byte(credentials)))
+		keyless = typ != "service_account"
+	}
+
+	b := &gcsBackend{bucket: bucket, keyless: keyless}
+	opts := base
+	if target := str("impersonate_service_account"); target != "" {
+		ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: target,
+			Scopes:          gcsScopes,
+		}, base...)
+		if err != nil {
+			return nil, err
+		}
+		iam, err := iamcredentials.NewService(ctx, base...)
+		if err != nil {
+			return nil, err
+		}
+		opts = []option.ClientOption{option.WithTokenSource(ts)}
+		b.signAs, b.iam, b.keyless = target, iam, true
+	}
+	client, err := gcs.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	b.client, b.opts = client, opts
+	return b, nil
+}
+
+func (b *gcsBackend) handle() *gcs.BucketHandle { return b.client.Bucket(b.bucket) }
+
+func (b *gcsBackend) Test(ctx context.Context) error {
+	// Public buckets rarely grant storage.buckets.get to allUsers, so
+	// anonymous connections go straight to listing.
+	if !b.anonymous {
+		if _, err := b.handle().Attrs(ctx); err == nil {
+			return nil
+		}
+	}
+	it := b.handle().Objects(ctx, &gcs.Query{})
+	_, err := it.Next()
+	if err == nil || err == iterator.Done {
+		return nil
+	}
+	return fmt.Errorf("bucket not accessible: %w", err)
+}
+
+func (b *gcsBackend) List(ctx context.Context, prefix, pageToken string, limit int) (*Page, error) {
+	it := b.handle().Objects(ctx, &gcs.Query{Prefix: prefix, Delimiter: "/"})
+	pager := iterator.NewPager(it, limit, pageToken)
+
+	var attrs []*gcs.ObjectAttrs
+	next, err := pager.NextPage(&attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Entries: []Entry{}, NextPageToken: next}
+	for _, a := range attrs {
+		if a.Prefix != "" {
+			page.Entries = append(page.Entries, Entry{Type: "dir", Name: a.Prefix, Display: display(a.Prefix, prefix)})
+		} else if a.Name != prefix {
+			page.Entries = append(page.Entries, Entry{
+				Type:        "file",
+				Name:        a.Name,
+				Display:     strings.TrimPrefix(a.Name, prefix),
+				Size:        a.Size,
+				Updated:     a.Updated,
+				ContentType: a.ContentType,
+			})
+		}
+	}
+	return page, nil
+}
+
+func (b *gcsBackend) Walk(ctx context.Context, prefix string, fn func(Object) error) error {
+	it := b.handle().Objects(ctx, &gcs.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(Object{Name: attrs.Name, Size: attrs.Size, Updated: attrs.Updated, ContentType: attrs.ContentType})
+		if err == StopWalk {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *gcsBackend) Stat(ctx context.Context, key string) (*Metadata, error) {
+	attrs, err := b.handle().Object(key).Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	md := attrs.Metadata
+	if md == nil {
+		md = map[string]string{}
+	}
+	return &Metadata{
+		ContentType:  attrs.ContentType,
+		CacheControl: attrs.CacheControl,
+		Metadata:     md,
+		Size:         attrs.Size,
+		Updated:      attrs.Updated,
+		ETag:         attrs.Etag,
+		MD5:          fmt.Sprintf("%x", attrs.MD5),
+	}, nil
+}
+
+func (b *gcsBackend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.handle().Object(key).NewReader(ctx)
+}
+
+func (b *gcsBackend) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return b.handle().Object(key).NewRangeReader(ctx, offset, length)
+}
+
+func (b *gcsBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	wc := b.handle().Object(key).NewWriter(ctx)
+	wc.ContentType = contentType
+	// Objects larger than one chunk go up as a resumable upload, one
+	// buffered chunk at a time.
+	wc.ChunkSize = int(uploadPartSize)
+	if _, err := io.Copy(wc, r); err != nil {
+		_ = wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+// ── resumable uploads ───────────────────────────────────────────
+
+// Resumable uploads use a GCS resumable session, whose URI is the upload
+// ID. Parts are sent as chunks of the session; GCS assembles the object as
+// they arrive and creates it when the last one is in.
+
+const gcsUploadBase = "https://storage.googleapis.com/upload/storage/v1/b/"
+
+// statusResumeIncomplete is GCS's reply to a chunk that is not the last.
+const statusResumeIncomplete = 308
+
+func (b *gcsBackend) httpClient(ctx context.Context) (*http.Client, error) {
+	if b.http == nil {
+		c, _, err := htransport.NewClient(ctx, append(b.opts, option.WithScopes(gcsScopes...))...)
+		if err != nil {
+			return nil, err
+		}
+		b.http = c
+	}
+	return b.http, nil
+}
+
+func (b *gcsBackend) StartUpload(ctx context.Context, u *Upload) error {
+	client, err := b.httpClient(ctx)
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(map[string]string{"name": u.Key, "contentType": u.ContentType})
+	q := url.Values{"uploadType": {"resumable"}, "name": {u.Key}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		gcsUploadBase+url.PathEscape(b.bucket)+"/o?"+q.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", u.ContentType)
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(u.Size, 10))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	u.ID = resp.Header.Get("Location")
+	if u.ID == "" {
+		return errors.New("GCS returned no resumable session URI")
+	}
+	return nil
+}
+
+// uploadProgress asks the session how many bytes GCS has persisted.
+func (b *gcsBackend) uploadProgress(ctx context.Context, client *http.Client, u Upload) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.ID, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Range", "bytes */"+strconv.FormatInt(u.Size, 10))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != statusResumeIncomplete {
+		// 200 or 201 once the object exists.
+		return u.Size, googleapi.CheckResponse(resp)
+	}
+	var last int64 = -1
+	if rng := resp.Header.Get("Range"); rng != "" {
+		if _, err := fmt.Sscanf(rng, "bytes=0-%d", &last); err != nil {
+			return 0, fmt.Errorf("unexpected Range %q from GCS", rng)
+		}
+	}
+	return last + 1, nil
+}
+
+func (b *gcsBackend) UploadPart(ctx context.Context, u Upload, n int, offset int64, r io.ReadSeeker, size int64) (string, error) {
+	client, err := b.httpClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	// An earlier attempt at this part may have got partway.
+	persisted, err := b.uploadProgress(ctx, client, u)
+	if err != nil {
+		return "", err
+	}
+	skip := max(persisted-offset, 0)
+	if skip >= size {
+		return "", nil
+	}
+	if _, err := r.Seek(skip, io.SeekStart); err != nil {
+		return "", err
+	}
+	total := "*"
+	if offset+size == u.Size {
+		total = strconv.FormatInt(u.Size, 10)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.ID, io.LimitReader(r, size-skip))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size - skip
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", offset+skip, offset+size-1, total))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == statusResumeIncomplete {
+		return "", nil
+	}
+	return "", googleapi.CheckResponse(resp)
+}
+
+// CompleteUpload checks that the last part created the object.
+func (b *gcsBackend) CompleteUpload(ctx context.Context, u Upload, tags []string) error {
+	client, err := b.httpClient(ctx)
+	if err != nil {
+		return err
+	}
+	persisted, err := b.uploadProgress(ctx, client, u)
+	if err != nil {
+		return err
+	}
+	if persisted < u.Size {
+		return fmt.Errorf("GCS has %d of %d bytes of the upload", persisted, u.Size)
+	}
+	return nil
+}
+
+func (b *gcsBackend) AbortUpload(ctx context.Context, u Upload) error {
+	client, err := b.httpClient(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.ID, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// GCS answers a cancelled session with 499.
+	if resp.StatusCode == 499 || resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return googleapi.CheckResponse(resp)
+}
+
+func (b *gcsBackend) Copy(ctx context.Context, src, dst string) error {
+	_, err := b.handle().Object(dst).CopierFrom(b.handle().Object(src)).Run(ctx)
+	return err
+}
+
+func (b *gcsBackend) Delete(ctx context.Context, key string) error {
+	return b.handle().Object(key).Delete(ctx)
+}
+
+// Presign returns a V4 signed URL, or the public URL for anonymous
+// (public bucket) connections, which have no key to sign with. Keyless
+// connections that cannot sign fall back to streamed downloads.
+func (b *gcsBackend) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if b.anonymous {
+		return (&url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + b.bucket + "/" + key}).String(), nil
+	}
+	return b.signedURL(ctx, key, &gcs.SignedURLOptions{
+		Scheme:  gcs.SigningSchemeV4,
+		Method:  "GET",
+		Expires: time.Now().Add(expires),
+	})
+}
+
+// PresignUpload signs a V4 PUT URL. The content type is part of the
+// signature and x-goog-content-length-range pins the size.
+func (b *gcsBackend) PresignUpload(ctx context.Context, u Upload, method string, expires time.Duration) (*UploadTarget, error) {
+	if b.anonymous || method != http.MethodPut {
+		return nil, fmt.Errorf("%w: %s uploads", ErrNotSupported, method)
+	}
+	lengthRange := fmt.Sprintf("%d,%d", u.Size, u.Size)
+	signed, err := b.signedURL(ctx, u.Key, &gcs.SignedURLOptions{
+		Scheme:      gcs.SigningSchemeV4,
+		Method:      http.MethodPut,
+		Expires:     time.Now().Add(expires),
+		ContentType: u.ContentType,
+		Headers:     []string{"x-goog-content-length-range:" + lengthRange},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &UploadTarget{Method: http.MethodPut, URL: signed, Headers: map[string]string{
+		"Content-Type":                u.ContentType,
+		"x-goog-content-length-range": lengthRange,
+	}}, nil
+}
+
+// signedURL signs opts for key, through the IAM Credentials API when
+// impersonating.
+func (b *gcsBackend) signedURL(ctx context.Context, key string, opts *gcs.SignedURLOptions) (string, error) {
+	if b.signAs != "" {
+		opts.GoogleAccessID = b.signAs
+		opts.SignBytes = func(payload []byte) ([]byte, error) {
+			resp, err := b.iam.Projects.ServiceAccounts.SignBlob("projects/-/serviceAccounts/"+b.signAs,
+				&iamcredentials.SignBlobRequest{Payload: base64.StdEncoding.EncodeToString(payload)}).Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return base64.StdEncoding.DecodeString(resp.SignedBlob)
+		}
+	}
+	u, err := b.handle().SignedURL(key, opts)
+	if err != nil && b.keyless {
+		return "", fmt.Errorf("%w: cannot sign URLs with these credentials: %v", ErrNotSupported, err)
+	}
+	return u, err
+}
+
+func (b *gcsBackend) UpdateMetadata(ctx context.Context, key string, u MetadataUpdate) error {
+	_, err := b.handle().Object(key).Update(ctx, gcs.ObjectAttrsToUpdate{
+		ContentType:  u.ContentType,
+		CacheControl: u.CacheControl,
+		Metadata:     u.Metadata,
+	})
+	return err
+}
+
+func (b *gcsBackend) Close() error { return b.client.Close() }
+
+func init() {
+	registerClassifier(func(err error) (string, map[string]any, bool) {
+		if errors.Is(err, gcs.ErrObjectNotExist) || errors.Is(err, gcs.ErrBucketNotExist) {
+			return CodeNotFound, nil, true
+		}
+		var gerr *googleapi.Error
+		if !errors.As(err, &gerr) {
+			return "", nil, false
+		}
+		details := map[string]any{"status": gerr.Code}
+		if len(gerr.Errors) > 0 && gerr.Errors[0].Reason != "" {
+			details["provider_code"] = gerr.Errors[0].Reason
+		}
+		code := codeForStatus(gerr.Code)
+		if gerr.Code == 403 && len(gerr.Errors) > 0 && strings.HasPrefix(gerr.Errors[0].Reason, "rateLimitExceeded") {
+			code = CodeThrottled
+		}
+		return code, details, true
+	})
+}
@@ -0,0 +1,479 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("local", newLocalBackend)
+}
+
+// localMetaDir is the hidden directory at the root of a local bucket that
+// holds metadata sidecars and in-progress uploads. It never shows up in
+// listings and cannot be addressed as an object.
+const localMetaDir = ".vestra"
+
+// localRoots lists the host directories a local connection may point into.
+// The provider is disabled while it is empty.
+var localRoots []string
+
+// SetLocalRoots sets the host directories that local connections may expose.
+// Roots that exist are recorded with their symlinks resolved, so they
+// compare against the real paths of connection directories.
+func SetLocalRoots(roots []string) {
+	localRoots = nil
+	for _, r := range roots {
+		abs, err := filepath.Abs(r)
+		if err != nil {
+			continue
+		}
+		if real, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = real
+		}
+		localRoots = append(localRoots, abs)
+	}
+}
+
+// localBackend exposes a directory on the server host. Keys map to paths
+// below the directory; content type, cache control and custom metadata live
+// in JSON sidecars under .vestra/meta. Symlinks inside the directory are
+// followed only as long as they lead to somewhere else inside it.
+type localBackend struct {
+	root string
+}
+
+// localMeta is the content of a sidecar file.
+type localMeta struct {
+	ContentType  string            `json:"content_type,omitempty"`
+	CacheControl string            `json:"cache_control,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// newLocalBackend takes the directory as the bucket; there are no credentials.
+func newLocalBackend(ctx context.Context, dir, credentials string) (Backend, error) {
+	if len(localRoots) == 0 {
+		return nil, invalid(errors.New("the local provider is disabled; set local_roots in the server configuration"))
+	}
+	if !filepath.IsAbs(dir) {
+		return nil, invalid(fmt.Errorf("directory must be an absolute path, got %q", dir))
+	}
+	root := filepath.Clean(dir)
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	allowed := false
+	for _, r := range localRoots {
+		if root == r || strings.HasPrefix(root, r+string(filepath.Separator)) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, invalid(fmt.Errorf("%s is outside the allowed local roots (%s)", root, strings.Join(localRoots, ", ")))
+	}
+	return &localBackend{root: root}, nil
+}
+
+// path resolves a key to a host path, rejecting keys that would escape the
+// root or reach into the metadata directory. The folders on the way must
+// stay inside the root once symlinks are resolved; the last component is
+// left alone, so a link itself can still be removed or renamed. Callers
+// that read through it use follow.
+func (b *localBackend) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	if key == localMetaDir || strings.HasPrefix(key, localMetaDir+"/") {
+		return "", &Error{Code: CodeInvalid, Err: fmt.Errorf("invalid object name %q", key)}
+	}
+	p := filepath.Join(b.root, filepath.FromSlash(key))
+	if p == b.root {
+		return p, nil
+	}
+	if err := b.inside(filepath.Dir(p), key); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// follow is path for callers that read what the key points to.
+func (b *localBackend) follow(key string) (string, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return "", err
+	}
+	return p, b.inside(p, key)
+}
+
+// inside fails unless p, with its symlinks resolved, is the root or below
+// it. Trailing components that don't exist yet are taken as they are.
+func (b *localBackend) inside(p, key string) error {
+	real, rest := p, ""
+	for {
+		r, err := filepath.EvalSymlinks(real)
+		if err == nil {
+			real = filepath.Join(r, rest)
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fsError(err)
+		}
+		if _, lerr := os.Lstat(real); lerr == nil {
+			// A dangling link: writing through it would create its target.
+			return &Error{Code: CodeAccessDenied, Err: fmt.Errorf("%s is a link to a missing target", key)}
+		}
+		parent := filepath.Dir(real)
+		if parent == real {
+			return fsError(err)
+		}
+		rest = filepath.Join(filepath.Base(real), rest)
+		real = parent
+	}
+	if real != b.root && !strings.HasPrefix(real, b.root+string(filepath.Separator)) {
+		return &Error{Code: CodeAccessDenied, Err: fmt.Errorf("%s leads outside %s", key, b.root)}
+	}
+	return nil
+}
+
+func (b *localBackend) metaPath(key string) string {
+	return filepath.Join(b.root, localMetaDir, "meta", filepath.FromSlash(key)+".json")
+}
+
+func (b *localBackend) readMeta(key string) localMeta {
+	var m localMeta
+	if data, err := os.ReadFile(b.metaPath(key)); err == nil {
+		_ = json.Unmarshal(data, &m)
+	}
+	return m
+}
+
+func (b *localBackend) writeMeta(key string, m localMeta) error {
+	p := b.metaPath(key)
+	if m.ContentType == "" && m.CacheControl == "" && len(m.Metadata) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+// contentType returns the stored content type, or one guessed from the
+// file extension.
+func (b *localBackend) contentType(key string, m localMeta) string {
+	if m.ContentType != "" {
+		return m.ContentType
+	}
+	return guessContentType(key)
+}
+
+func (b *localBackend) Test(ctx context.Context) error {
+	info, err := os.Stat(b.root)
+	if err != nil {
+		return fsError(err)
+	}
+	if !info.IsDir() {
+		return &Error{Code: CodeInvalid, Err: fmt.Errorf("%s is not a directory", b.root)}
+	}
+	_, err = os.ReadDir(b.root)
+	return fsError(err)
+}
+
+// List reads one directory. Entries come back sorted by name, so the page
+// token is simply the last name returned.
+func (b *localBackend) List(ctx context.Context, prefix, pageToken string, limit int) (*Page, error) {
+	dirKey, namePrefix := splitPrefix(prefix)
+	dir, err := b.follow(dirKey)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Page{Entries: []Entry{}}, nil
+	}
+	if err != nil {
+		return nil, fsError(err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, de := range entries {
+		if dirKey == "" && de.Name() == localMetaDir {
+			continue
+		}
+		names = append(names, de.Name())
+	}
+	// os.Stat rather than the DirEntry so symlinked files and folders show
+	// up as what they point to. Links that lead out of the root are left out.
+	stat := func(name string) (fs.FileInfo, error) {
+		p := filepath.Join(dir, name)
+		if err := b.inside(p, dirKey+name); err != nil {
+			return nil, err
+		}
+		return os.Stat(p)
+	}
+	contentType := func(key string) string { return b.contentType(key, b.readMeta(key)) }
+	return listDir(names, dirKey, namePrefix, pageToken, limit, stat, contentType), nil
+}
+
+func (b *localBackend) Walk(ctx context.Context, prefix string, fn func(Object) error) error {
+	dirKey, _ := splitPrefix(prefix)
+	start, err := b.follow(dirKey)
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == start && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(b.root, p)
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if key == localMetaDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 && b.inside(p, key) != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		err = fn(Object{
+			Name:        key,
+			Size:        info.Size(),
+			Updated:     info.ModTime().UTC(),
+			ContentType: b.contentType(key, b.readMeta(key)),
+		})
+		if err == StopWalk {
+			return filepath.SkipAll
+		}
+		return err
+	})
+	return fsError(err)
+}
+
+func (b *localBackend) Stat(ctx context.Context, key string) (*Metadata, error) {
+	p, err := b.follow(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	m := b.readMeta(key)
+	md := &Metadata{
+		ContentType:  b.contentType(key, m),
+		CacheControl: m.CacheControl,
+		Metadata:     m.Metadata,
+		Size:         info.Size(),
+		Updated:      info.ModTime().UTC(),
+		ETag:         fileETag(info),
+	}
+	if md.Metadata == nil {
+		md.Metadata = map[string]string{}
+	}
+	if info.IsDir() {
+		md.ContentType, md.Size = "", 0
+	}
+	return md, nil
+}
+
+func (b *localBackend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := b.follow(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.Close()
+		return nil, &Error{Code: CodeInvalid, Err: fmt.Errorf("%s is a folder", key)}
+	}
+	return f, nil
+}
+
+// writeFile stores r under key. The data goes to a temp file under the
+// metadata directory first and is renamed into place, so readers never see
+// a partial file.
+func (b *localBackend) writeFile(ctx context.Context, key string, r io.Reader) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(key, "/") {
+		return fsError(os.MkdirAll(p, 0o755))
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fsError(err)
+	}
+	tmpDir := filepath.Join(b.root, localMetaDir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return fsError(err)
+	}
+	tmp, err := os.CreateTemp(tmpDir, "upload-*")
+	if err != nil {
+		return fsError(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, contextReader{ctx, r}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return fsError(os.Rename(tmp.Name(), p))
+}
+
+func (b *localBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := b.writeFile(ctx, key, r); err != nil {
+		return err
+	}
+	// A new upload replaces the object's metadata, as on the cloud providers.
+	// Only a content type that differs from the extension's is stored;
+	// application/octet-stream is what the upload form sends when the
+	// browser doesn't know.
+	m := localMeta{}
+	if contentType != "" && contentType != "application/octet-stream" && contentType != b.contentType(key, localMeta{}) {
+		m.ContentType = contentType
+	}
+	return b.writeMeta(key, m)
+}
+
+func (b *localBackend) Copy(ctx context.Context, src, dst string) error {
+	rc, err := b.Open(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := b.writeFile(ctx, dst, rc); err != nil {
+		return err
+	}
+	return b.writeMeta(dst, b.readMeta(src))
+}
+
+// Rename moves a file or folder together with its sidecars.
+func (b *localBackend) Rename(ctx context.Context, src, dst string) error {
+	if strings.TrimSuffix(src, "/") == "" || strings.TrimSuffix(dst, "/") == "" {
+		return &Error{Code: CodeInvalid, Err: errors.New("object name is required")}
+	}
+	from, err := b.path(src)
+	if err != nil {
+		return err
+	}
+	to, err := b.path(dst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return fsError(err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		return fsError(err)
+	}
+	metaFrom, metaTo := b.metaPath(src), b.metaPath(dst)
+	if strings.HasSuffix(src, "/") {
+		metaFrom, metaTo = filepath.Dir(metaFrom), filepath.Dir(metaTo)
+	}
+	if _, err := os.Stat(metaFrom); err == nil {
+		if err := os.MkdirAll(filepath.Dir(metaTo), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(metaFrom, metaTo); err != nil {
+			return err
+		}
+	}
+	pruneEmpty(filepath.Dir(from), b.root)
+	pruneEmpty(filepath.Dir(metaFrom), filepath.Join(b.root, localMetaDir, "meta"))
+	return nil
+}
+
+// Delete removes a file, its sidecar and any folders left empty, so a folder
+// disappears with its last object as it does in a bucket. A key ending in
+// "/" removes an empty folder. The root itself is never removed.
+func (b *localBackend) Delete(ctx context.Context, key string) error {
+	if strings.TrimSuffix(key, "/") == "" {
+		return &Error{Code: CodeInvalid, Err: errors.New("object name is required")}
+	}
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil {
+		return fsError(err)
+	}
+	if err := os.Remove(b.metaPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	pruneEmpty(filepath.Dir(p), b.root)
+	pruneEmpty(filepath.Dir(b.metaPath(key)), filepath.Join(b.root, localMetaDir, "meta"))
+	return nil
+}
+
+// pruneEmpty removes dir and its parents up to (not including) stop for as
+// long as they are empty.
+func pruneEmpty(dir, stop string) {
+	for ; dir != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// Presign is not possible for files on the server host; downloads are
+// streamed through the server instead.
+func (b *localBackend) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (b *localBackend) UpdateMetadata(ctx context.Context, key string, u MetadataUpdate) error {
+	p, err := b.follow(key)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); err != nil {
+		return fsError(err)
+	}
+	m := b.readMeta(key)
+	if u.ContentType != "" {
+		m.ContentType = u.ContentType
+	}
+	if u.CacheControl != "" {
+		m.CacheControl = u.CacheControl
+	}
+	if u.Metadata != nil {
+		m.Metadata = u.Metadata
+	}
+	return b.writeMeta(key, m)
+}
+
+func (b *localBackend) Close() error { return nil }
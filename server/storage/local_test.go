@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalContainment(t *testing.T) {
+	base := t.TempDir()
+	root, outside := filepath.Join(base, "root"), filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "docs"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, content := range map[string]string{
+		filepath.Join(root, "docs", "a.txt"): "inside",
+		filepath.Join(outside, "secret.txt"): "secret",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"escape":     outside,
+		"secret.txt": filepath.Join(outside, "secret.txt"),
+		"dangling":   filepath.Join(outside, "new.txt"),
+		"alias":      filepath.Join(root, "docs"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skip("symlinks are not available:", err)
+		}
+	}
+	SetLocalRoots([]string{base})
+	defer SetLocalRoots(nil)
+	b, err := Open(context.Background(), "local", root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	page, err := b.List(ctx, "", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range page.Entries {
+		names = append(names, e.Name)
+	}
+	if got := strings.Join(names, ","); got != "alias/,docs/" {
+		t.Errorf("List = %s, want only the entries inside the root", got)
+	}
+
+	if rc, err := b.Open(ctx, "alias/a.txt"); err != nil {
+		t.Errorf("Open through a link inside the root: %v", err)
+	} else {
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != "inside" {
+			t.Errorf("Open(alias/a.txt) = %q", data)
+		}
+	}
+	for _, key := range []string{"secret.txt", "escape/secret.txt"} {
+		if _, err := b.Open(ctx, key); Classify("local", err).Code != CodeAccessDenied {
+			t.Errorf("Open(%s) = %v, want access denied", key, err)
+		}
+		if _, err := b.Stat(ctx, key); Classify("local", err).Code != CodeAccessDenied {
+			t.Errorf("Stat(%s) = %v, want access denied", key, err)
+		}
+	}
+	if _, err := b.List(ctx, "escape/", "", 100); Classify("local", err).Code != CodeAccessDenied {
+		t.Errorf("List(escape/) = %v, want access denied", err)
+	}
+	if err := b.Put(ctx, "escape/new.txt", strings.NewReader("x"), 1, ""); Classify("local", err).Code != CodeAccessDenied {
+		t.Errorf("Put(escape/new.txt) = %v, want access denied", err)
+	}
+	// An upload over a dangling link replaces the link rather than
+	// creating its target.
+	if err := b.Put(ctx, "dangling", strings.NewReader("x"), 1, ""); err != nil {
+		t.Errorf("Put(dangling): %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); err == nil {
+		t.Error("a write through a link created a file outside the root")
+	}
+
+	var walked []string
+	if err := b.Walk(ctx, "", func(o Object) error {
+		walked = append(walked, o.Name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range walked {
+		if name == "secret.txt" {
+			t.Error("Walk reported a link that leads outside the root")
+		}
+	}
+
+	// The link itself can still be removed; what it points to stays.
+	if err := b.Delete(ctx, "secret.txt"); err != nil {
+		t.Errorf("Delete(secret.txt): %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.txt")); err != nil {
+		t.Errorf("deleting the link removed its target: %v", err)
+	}
+	for _, key := range []string{"", "/"} {
+		if err := b.Delete(ctx, key); Classify("local", err).Code != CodeInvalid {
+			t.Errorf("Delete(%q) = %v, want invalid", key, err)
+		}
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("the root is gone: %v", err)
+	}
+}
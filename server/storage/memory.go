@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("memory", newMemoryBackend)
+}
+
+// The memory provider keeps buckets in the server's memory, for demos and
+// tests that should not need a cloud account. Buckets are created on first
+// use and live until the server stops; every connection naming the same
+// bucket sees the same objects.
+
+// memoryFixtures is a directory whose subdirectories seed the buckets of
+// the same name. Empty means buckets start empty.
+var memoryFixtures string
+
+// memoryLimit caps the bytes held across all memory buckets.
+var memoryLimit int64 = 256 << 20
+
+// SetMemoryFixtures sets the directory that seeds memory buckets.
+func SetMemoryFixtures(dir string) { memoryFixtures = dir }
+
+// SetMemoryLimit caps the total size of the objects in memory buckets.
+func SetMemoryLimit(n int64) { memoryLimit = n }
+
+type memoryObject struct {
+	data         []byte // never modified once stored
+	contentType  string
+	cacheControl string
+	metadata     map[string]string
+	updated      time.Time
+	md5          string
+}
+
+var memoryStore = struct {
+	sync.RWMutex
+	buckets map[string]map[string]*memoryObject
+	size    int64
+}{buckets: map[string]map[string]*memoryObject{}}
+
+type memoryBackend struct {
+	bucket string
+}
+
+// newMemoryBackend takes no credentials.
+func newMemoryBackend(ctx context.Context, bucket, credentials string) (Backend, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return nil, invalid(fmt.Errorf("invalid bucket name %q", bucket))
+	}
+	memoryStore.Lock()
+	defer memoryStore.Unlock()
+	if _, ok := memoryStore.buckets[bucket]; !ok {
+		objects, err := loadMemoryFixtures(bucket)
+		if err != nil {
+			return nil, err
+		}
+		memoryStore.buckets[bucket] = objects
+	}
+	return &memoryBackend{bucket: bucket}, nil
+}
+
+// loadMemoryFixtures reads the fixture directory for a bucket, if there is
+// one. The caller holds the store lock.
+func loadMemoryFixtures(bucket string) (map[string]*memoryObject, error) {
+	objects := map[string]*memoryObject{}
+	if memoryFixtures == "" {
+		return objects, nil
+	}
+	root := filepath.Join(memoryFixtures, bucket)
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return objects, nil
+	}
+	var size int64
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		key := filepath.ToSlash(rel)
+		objects[key] = newMemoryObject(data, guessContentType(key))
+		objects[key].updated = info.ModTime().UTC()
+		size += int64(len(data))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading fixtures for %s: %w", bucket, err)
+	}
+	if memoryStore.size+size > memoryLimit {
+		return nil, memoryFull()
+	}
+	memoryStore.size += size
+	return objects, nil
+}
+
+func newMemoryObject(data []byte, contentType string) *memoryObject {
+	sum := md5.Sum(data)
+	return &memoryObject{
+		data:        data,
+		contentType: contentType,
+		metadata:    map[string]string{},
+		updated:     time.Now().UTC(),
+		md5:         hex.EncodeToString(sum[:]),
+	}
+}
+
+func memoryFull() error {
+	return &Error{Code: CodeInsufficientStorage, Err: fmt.Errorf("the memory provider is full (limit %d bytes)", memoryLimit)}
+}
+
+func memoryNotFound(key string) error {
+	return &Error{Code: CodeNotFound, Err: fmt.Errorf("object %q not found", key)}
+}
+
+// objects returns the bucket's map. The caller holds the store lock.
+func (b *memoryBackend) objects() map[string]*memoryObject {
+	return memoryStore.buckets[b.bucket]
+}
+
+// sortedKeys returns the keys under prefix in order. The caller holds the
+// store lock.
+func (b *memoryBackend) sortedKeys(prefix string) []string {
+	var keys []string
+	for k := range b.objects() {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *memoryBackend) Test(ctx context.Context) error { return nil }
+
+// List pages through keys and folder prefixes in name order; the page token
+// is the last name returned.
+func (b *memoryBackend) List(ctx context.Context, prefix, pageToken string, limit int) (*Page, error) {
+	memoryStore.RLock()
+	defer memoryStore.RUnlock()
+
+	page := &Page{Entries: []Entry{}}
+	lastDir := ""
+	for _, key := range b.sortedKeys(prefix) {
+		name := key
+		rest := key[len(prefix):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name = prefix + rest[:i+1]
+			if name == lastDir {
+				continue
+			}
+			lastDir = name
+		}
+		if rest == "" || (pageToken != "" && name <= pageToken) {
+			continue
+		}
+		if len(page.Entries) == limit {
+			page.NextPageToken = page.Entries[limit-1].Name
+			break
+		}
+		if strings.HasSuffix(name, "/") {
+			page.Entries = append(page.Entries, Entry{Type: "dir", Name: name, Display: display(name, prefix)})
+			continue
+		}
+		o := b.objects()[key]
+		page.Entries = append(page.Entries, Entry{
+			Type:        "file",
+			Name:        key,
+			Display:     rest,
+			Size:        int64(len(o.data)),
+			Updated:     o.updated,
+			ContentType: o.contentType,
+		})
+	}
+	return page, nil
+}
+
+func (b *memoryBackend) Walk(ctx context.Context, prefix string, fn func(Object) error) error {
+	memoryStore.RLock()
+	keys := b.sortedKeys(prefix)
+	objs := make([]Object, 0, len(keys))
+	for _, k := range keys {
+		o := b.objects()[k]
+		objs = append(objs, Object{Name: k, Size: int64(len(o.data)), Updated: o.updated, ContentType: o.contentType})
+	}
+	memoryStore.RUnlock()
+
+	for _, obj := range objs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn(obj)
+		if err == StopWalk {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) Stat(ctx context.Context, key string) (*Metadata, error) {
+	memoryStore.RLock()
+	defer memoryStore.RUnlock()
+	o, ok := b.objects()[key]
+	if !ok {
+		return nil, memoryNotFound(key)
+	}
+	return &Metadata{
+		ContentType:  o.contentType,
+		CacheControl: o.cacheControl,
+		Metadata:     maps.Clone(o.metadata),
+		Size:         int64(len(o.data)),
+		Updated:      o.updated,
+		ETag:         o.md5,
+		MD5:          o.md5,
+	}, nil
+}
+
+func (b *memoryBackend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	memoryStore.RLock()
+	defer memoryStore.RUnlock()
+	o, ok := b.objects()[key]
+	if !ok {
+		return nil, memoryNotFound(key)
+	}
+	return io.NopCloser(bytes.NewReader(o.data)), nil
+}
+
+func (b *memoryBackend) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	memoryStore.RLock()
+	defer memoryStore.RUnlock()
+	o, ok := b.objects()[key]
+	if !ok {
+		return nil, memoryNotFound(key)
+	}
+	data := o.data[min(offset, int64(len(o.data))):]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *memoryBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return &Error{Code: CodeInvalid, Err: fmt.Errorf("invalid object name %q", key)}
+	}
+	// Never read more than could fit; store checks the actual room.
+	data, err := io.ReadAll(io.LimitReader(contextReader{ctx, r}, memoryLimit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > memoryLimit {
+		return memoryFull()
+	}
+	if contentType == "" {
+		contentType = guessContentType(key)
+	}
+	return b.store(key, newMemoryObject(data, contentType))
+}
+
+// store puts o under key, replacing any object there, if it fits.
+func (b *memoryBackend) store(key string, o *memoryObject) error {
+	memoryStore.Lock()
+	defer memoryStore.Unlock()
+	delta := int64(len(o.data))
+	if old, ok := b.objects()[key]; ok {
+		delta -= int64(len(old.data))
+	}
+	if memoryStore.size+delta > memoryLimit {
+		return memoryFull()
+	}
+	memoryStore.size += delta
+	b.objects()[key] = o
+	return nil
+}
+
+func (b *memoryBackend) Copy(ctx context.Context, src, dst string) error {
+	memoryStore.RLock()
+	o, ok := b.objects()[src]
+	memoryStore.RUnlock()
+	if !ok {
+		return memoryNotFound(src)
+	}
+	c := *o
+	c.metadata = maps.Clone(o.metadata)
+	c.updated = time.Now().UTC()
+	return b.store(dst, &c)
+}
+
+// Rename moves an object without copying its data.
+func (b *memoryBackend) Rename(ctx context.Context, src, dst string) error {
+	memoryStore.Lock()
+	defer memoryStore.Unlock()
+	objects := b.objects()
+	o, ok := objects[src]
+	if !ok {
+		return memoryNotFound(src)
+	}
+	if src == dst {
+		return nil
+	}
+	if old, ok := objects[dst]; ok {
+		memoryStore.size -= int64(len(old.data))
+	}
+	objects[dst] = o
+	delete(objects, src)
+	return nil
+}
+
+func (b *memoryBackend) Delete(ctx context.Context, key string) error {
+	memoryStore.Lock()
+	defer memoryStore.Unlock()
+	o, ok := b.objects()[key]
+	if !ok {
+		return memoryNotFound(key)
+	}
+	memoryStore.size -= int64(len(o.data))
+	delete(b.objects(), key)
+	return nil
+}
+
+// Presign is not supported; downloads are streamed by the server through
+// signed links of its own.
+func (b *memoryBackend) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (b *memoryBackend) UpdateMetadata(ctx context.Context, key string, u MetadataUpdate) error {
+	memoryStore.Lock()
+	defer memoryStore.Unlock()
+	o, ok := b.objects()[key]
+	if !ok {
+		return memoryNotFound(key)
+	}
+	// Objects are shared with readers that hold no lock, so replace
+	// rather than modify.
+	c := *o
+	if u.ContentType != "" {
+		c.contentType = u.ContentType
+	}
+	if u.CacheControl != "" {
+		c.cacheControl = u.CacheControl
+	}
+	if u.Metadata != nil {
+		c.metadata = maps.Clone(u.Metadata)
+	}
+	c.updated = time.Now().UTC()
+	b.objects()[key] = &c
+	return nil
+}
+
+func (b *memoryBackend) Close() error { return nil }
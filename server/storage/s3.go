@@ -0,0 +1,508 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+func init() {
+	// "aws" serves every preset; the connection picks one with "preset".
+	Register("aws", s3Factory(""))
+	Register("huawei", s3Factory("huawei"))
+	Register("alibaba", s3Factory("alibaba"))
+}
+
+type s3Backend struct {
+	client *s3.Client
+	bucket string
+	preset S3Preset
+}
+
+// s3Factory builds backends for a provider. A fixed preset ID binds the
+// provider to that preset; otherwise the "preset" credential chooses,
+// defaulting to plain AWS.
+func s3Factory(fixed string) Factory {
+	return func(ctx context.Context, bucket, credentials string) (Backend, error) {
+		var creds map[string]string
+		if err := json.Unmarshal([]byte(credentials), &creds); err != nil {
+			return nil, invalid(err)
+		}
+		id := fixed
+		if id == "" {
+			id = creds["preset"]
+		}
+		if id == "" {
+			id = "aws"
+		}
+		preset, ok := s3Preset(id)
+		if !ok {
+			return nil, invalid(fmt.Errorf("unknown S3 preset %q", id))
+		}
+		client, err := newS3Client(ctx, creds, preset)
+		if err != nil {
+			return nil, err
+		}
+		return &s3Backend{client: client, bucket: bucket, preset: preset}, nil
+	}
+}
+
+// newS3Client builds an S3 client from parsed credentials. An explicit
+// "endpoint" overrides the preset's template; see s3Credentials for how the
+// client authenticates.
+func newS3Client(ctx context.Context, creds map[string]string, preset S3Preset) (*s3.Client, error) {
+	region := creds["region"]
+	if region == "" {
+		region = preset.Region
+	}
+	endpoint := creds["endpoint"]
+	if endpoint == "" {
+		var err error
+		if endpoint, err = preset.endpoint(region, creds); err != nil {
+			return nil, invalid(err)
+		}
+	}
+	opts, err := parseS3Options(creds, preset)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	provider, err := s3Credentials(ctx, creds, region, preset)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(region),
+		awsconfig.WithCredentialsProvider(provider),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pathStyle := preset.PathStyle
+	if preset.ID == "aws" && endpoint != "" {
+		// Connections made before presets existed put R2 and MinIO under
+		// plain AWS with a custom endpoint; those need path-style.
+		pathStyle = true
+	}
+	if opts.pathStyle != nil {
+		pathStyle = *opts.pathStyle
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		// LoadDefaultConfig wraps the provider in a cache of its own; use
+		// it directly so CredentialsExpiry can see an s3Session.
+		o.Credentials = provider
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = pathStyle
+		o.RequestChecksumCalculation = opts.checksum
+		if opts.checksum == aws.RequestChecksumCalculationWhenRequired {
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+		if opts.legacy {
+			o.DisableS3ExpressSessionAuth = aws.Bool(true)
+		}
+		if opts.unsignedPayload {
+			o.APIOptions = append(o.APIOptions, v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware)
+		}
+	}), nil
+}
+
+// copySource builds the URL-encoded "bucket/key" value CopyObject expects.
+func (b *s3Backend) copySource(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return b.bucket + "/" + strings.Join(segments, "/")
+}
+
+func (b *s3Backend) Test(ctx context.Context) error {
+	if b.preset.testWithList {
+		_, err := b.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(b.bucket),
+			MaxKeys: aws.Int32(1),
+		})
+		return err
+	}
+	_, err := b.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(b.bucket)})
+	return err
+}
+
+func (b *s3Backend) List(ctx context.Context, prefix, pageToken string, limit int) (*Page, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(int32(limit)),
+	}
+	if pageToken != "" {
+		input.ContinuationToken = aws.String(pageToken)
+	}
+	result, err := b.client.ListObjectsV2(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Entries: []Entry{}}
+	for _, p := range result.CommonPrefixes {
+		if p.Prefix == nil {
+			continue
+		}
+		page.Entries = append(page.Entries, Entry{Type: "dir", Name: *p.Prefix, Display: display(*p.Prefix, prefix)})
+	}
+	for _, obj := range result.Contents {
+		if obj.Key == nil || *obj.Key == prefix {
+			continue
+		}
+		page.Entries = append(page.Entries, Entry{
+			Type:    "file",
+			Name:    *obj.Key,
+			Display: strings.TrimPrefix(*obj.Key, prefix),
+			Size:    aws.ToInt64(obj.Size),
+			Updated: aws.ToTime(obj.LastModified),
+		})
+	}
+	page.NextPageToken = aws.ToString(result.NextContinuationToken)
+	return page, nil
+}
+
+func (b *s3Backend) Walk(ctx context.Context, prefix string, fn func(Object) error) error {
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(b.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(1000),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			err := fn(Object{
+				Name:    aws.ToString(obj.Key),
+				Size:    aws.ToInt64(obj.Size),
+				Updated: aws.ToTime(obj.LastModified),
+			})
+			if err == StopWalk {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *s3Backend) Stat(ctx context.Context, key string) (*Metadata, error) {
+	head, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	md := head.Metadata
+	if md == nil {
+		md = map[string]string{}
+	}
+	return &Metadata{
+		ContentType:  aws.ToString(head.ContentType),
+		CacheControl: aws.ToString(head.CacheControl),
+		Metadata:     md,
+		Size:         aws.ToInt64(head.ContentLength),
+		Updated:      aws.ToTime(head.LastModified),
+		ETag:         strings.Trim(aws.ToString(head.ETag), `"`),
+	}, nil
+}
+
+func (b *s3Backend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (b *s3Backend) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(httpRange(offset, length)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Put streams r to the bucket: a single PutObject when it fits in one part,
+// otherwise a multipart upload that is aborted if anything fails.
+func (b *s3Backend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	uploader := manager.NewUploader(b.client, func(u *manager.Uploader) {
+		u.PartSize = uploadPartSize
+		u.Concurrency = uploadConcurrency
+	})
+	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+// StartUpload, UploadPart, CompleteUpload and AbortUpload map resumable
+// uploads onto an S3 multipart upload.
+func (b *s3Backend) StartUpload(ctx context.Context, u *Upload) error {
+	out, err := b.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(u.Key),
+		ContentType: aws.String(u.ContentType),
+	})
+	if err != nil {
+		return err
+	}
+	u.ID = aws.ToString(out.UploadId)
+	return nil
+}
+
+func (b *s3Backend) UploadPart(ctx context.Context, u Upload, n int, offset int64, r io.ReadSeeker, size int64) (string, error) {
+	out, err := b.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(u.Key),
+		UploadId:      aws.String(u.ID),
+		PartNumber:    aws.Int32(int32(n)),
+		Body:          r,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+func (b *s3Backend) CompleteUpload(ctx context.Context, u Upload, tags []string) error {
+	parts := make([]types.CompletedPart, len(tags))
+	for i, tag := range tags {
+		parts[i] = types.CompletedPart{ETag: aws.String(tag), PartNumber: aws.Int32(int32(i + 1))}
+	}
+	_, err := b.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.bucket),
+		Key:             aws.String(u.Key),
+		UploadId:        aws.String(u.ID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+func (b *s3Backend) AbortUpload(ctx context.Context, u Upload) error {
+	_, err := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(b.bucket),
+		Key:      aws.String(u.Key),
+		UploadId: aws.String(u.ID),
+	})
+	return err
+}
+
+func (b *s3Backend) Copy(ctx context.Context, src, dst string) error {
+	_, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(b.bucket),
+		CopySource: aws.String(b.copySource(src)),
+		Key:        aws.String(dst),
+	})
+	return err
+}
+
+func (b *s3Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (b *s3Backend) DeleteBatchSize() int { return 1000 }
+
+// DeleteBatch uses DeleteObjects. Services that lack it (some S3-compatible
+// ones answer NotImplemented) get ErrNotSupported, and single deletes.
+func (b *s3Backend) DeleteBatch(ctx context.Context, keys []string) (map[string]error, error) {
+	objects := make([]types.ObjectIdentifier, len(keys))
+	for i, k := range keys {
+		objects[i] = types.ObjectIdentifier{Key: aws.String(k)}
+	}
+	out, err := b.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(b.bucket),
+		Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented" {
+			return nil, fmt.Errorf("%w: %v", ErrNotSupported, err)
+		}
+		return nil, err
+	}
+	failed := map[string]error{}
+	for _, e := range out.Errors {
+		failed[aws.ToString(e.Key)] = &smithy.GenericAPIError{Code: aws.ToString(e.Code), Message: aws.ToString(e.Message)}
+	}
+	return failed, nil
+}
+
+func (b *s3Backend) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	presigned, err := s3.NewPresignClient(b.client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	}, func(o *s3.PresignOptions) { o.Expires = expires })
+	if err != nil {
+		return "", err
+	}
+	return presigned.URL, nil
+}
+
+// PresignUpload signs a PutObject request whose length and content type
+// are part of the signature, or a POST policy limiting both.
+func (b *s3Backend) PresignUpload(ctx context.Context, u Upload, method string, expires time.Duration) (*UploadTarget, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(u.Key),
+		ContentType: aws.String(u.ContentType),
+	}
+	presigner := s3.NewPresignClient(b.client)
+	switch method {
+	case http.MethodPut:
+		input.ContentLength = aws.Int64(u.Size)
+		req, err := presigner.PresignPutObject(ctx, input, func(o *s3.PresignOptions) { o.Expires = expires })
+		if err != nil {
+			return nil, err
+		}
+		// Browsers set Host and Content-Length themselves.
+		headers := map[string]string{}
+		for name := range req.SignedHeader {
+			if name != "Host" && name != "Content-Length" {
+				headers[name] = req.SignedHeader.Get(name)
+			}
+		}
+		return &UploadTarget{Method: req.Method, URL: req.URL, Headers: headers}, nil
+	case http.MethodPost:
+		req, err := presigner.PresignPostObject(ctx, input, func(o *s3.PresignPostOptions) {
+			o.Expires = expires
+			o.Conditions = []any{
+				[]any{"content-length-range", 0, u.Size},
+				map[string]string{"Content-Type": u.ContentType},
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		req.Values["Content-Type"] = u.ContentType
+		return &UploadTarget{Method: http.MethodPost, URL: req.URL, Fields: req.Values}, nil
+	}
+	return nil, fmt.Errorf("%w: %s uploads", ErrNotSupported, method)
+}
+
+// UpdateMetadata patches metadata via copy-to-self with the REPLACE directive.
+func (b *s3Backend) UpdateMetadata(ctx context.Context, key string, u MetadataUpdate) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(b.bucket),
+		CopySource:        aws.String(b.copySource(key)),
+		Key:               aws.String(key),
+		MetadataDirective: types.MetadataDirectiveReplace,
+		Metadata:          u.Metadata,
+	}
+	if u.ContentType != "" {
+		input.ContentType = aws.String(u.ContentType)
+	}
+	if u.CacheControl != "" {
+		input.CacheControl = aws.String(u.CacheControl)
+	}
+	_, err := b.client.CopyObject(ctx, input)
+	return err
+}
+
+func (b *s3Backend) Close() error { return nil }
+
+// s3Codes maps S3 error codes (shared by OBS and OSS) to storage codes.
+var s3Codes = map[string]string{
+	"NoSuchKey":               CodeNotFound,
+	"NoSuchBucket":            CodeNotFound,
+	"NoSuchUpload":            CodeNotFound,
+	"NoSuchVersion":           CodeNotFound,
+	"NotFound":                CodeNotFound,
+	"AccessDenied":            CodeAccessDenied,
+	"AllAccessDisabled":       CodeAccessDenied,
+	"InvalidAccessKeyId":      CodeAccessDenied,
+	"SignatureDoesNotMatch":   CodeAccessDenied,
+	"ExpiredToken":            CodeAccessDenied,
+	"InvalidToken":            CodeAccessDenied,
+	"AccountProblem":          CodeAccessDenied,
+	"Forbidden":               CodeAccessDenied,
+	"BucketAlreadyExists":     CodeConflict,
+	"BucketAlreadyOwnedByYou": CodeConflict,
+	"BucketNotEmpty":          CodeConflict,
+	"OperationAborted":        CodeConflict,
+	"PreconditionFailed":      CodeConflict,
+	"InvalidObjectState":      CodeConflict,
+	"SlowDown":                CodeThrottled,
+	"Throttling":              CodeThrottled,
+	"ThrottlingException":     CodeThrottled,
+	"TooManyRequests":         CodeThrottled,
+	"RequestLimitExceeded":    CodeThrottled,
+	"RequestTimeout":          CodeTimeout,
+	"InternalError":           CodeUnavailable,
+	"ServiceUnavailable":      CodeUnavailable,
+	"InvalidArgument":         CodeInvalid,
+	"InvalidRequest":          CodeInvalid,
+	"InvalidBucketName":       CodeInvalid,
+	"KeyTooLongError":         CodeInvalid,
+	"EntityTooLarge":          CodeInvalid,
+}
+
+func init() {
+	registerClassifier(func(err error) (string, map[string]any, bool) {
+		var apiErr smithy.APIError
+		var respErr *awshttp.ResponseError
+		hasAPI, hasResp := errors.As(err, &apiErr), errors.As(err, &respErr)
+		if !hasAPI && !hasResp {
+			return "", nil, false
+		}
+		details := map[string]any{}
+		code := ""
+		if hasAPI {
+			details["provider_code"] = apiErr.ErrorCode()
+			code = s3Codes[apiErr.ErrorCode()]
+		}
+		if hasResp {
+			details["status"] = respErr.HTTPStatusCode()
+			if id := respErr.ServiceRequestID(); id != "" {
+				details["request_id"] = id
+			}
+			if code == "" {
+				code = codeForStatus(respErr.HTTPStatusCode())
+			}
+		}
+		if code == "" {
+			code = CodeInternal
+		}
+		return code, details, true
+	})
+}
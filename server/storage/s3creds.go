@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	awsauth "github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// S3 connections authenticate in one of three ways, chosen by the
+// "credential_source" credential:
+//
+//	static        access_key_id / secret_access_key (/ session_token)
+//	default       the SDK's default chain on the server host, optionally
+//	              with a shared config "profile"
+//	web_identity  an OIDC token file exchanged for a role's credentials
+//
+// Any of them can assume a "role_arn" on top. Temporary credentials are
+// cached per connection setting for the life of the process and refreshed
+// before they expire, so backends opened per request share one STS session.
+
+// s3CredentialsRefresh is how long before expiry cached credentials are
+// replaced. It is generous because a signed upload can run for minutes.
+const s3CredentialsRefresh = 5 * time.Minute
+
+// s3CredentialsIdle is how long an unused cache entry is kept.
+const s3CredentialsIdle = time.Hour
+
+type s3CredentialsEntry struct {
+	provider aws.CredentialsProvider
+	used     time.Time
+}
+
+var s3CredentialsCache = struct {
+	sync.Mutex
+	m map[[sha256.Size]byte]*s3CredentialsEntry
+}{m: map[[sha256.Size]byte]*s3CredentialsEntry{}}
+
+// s3CredentialSettings are the credentials that decide how a connection
+// authenticates. They double as the cache key.
+type s3CredentialSettings struct {
+	Source       string        `json:"source"`
+	AccessKey    string        `json:"access_key"`
+	SecretKey    string        `json:"secret_key"`
+	SessionToken string        `json:"session_token"`
+	Profile      string        `json:"profile"`
+	TokenFile    string        `json:"token_file"`
+	RoleARN      string        `json:"role_arn"`
+	ExternalID   string        `json:"external_id"`
+	SessionName  string        `json:"session_name"`
+	Duration     time.Duration `json:"duration"`
+	STSEndpoint  string        `json:"sts_endpoint"`
+	Region       string        `json:"region"`
+}
+
+func parseS3CredentialSettings(creds map[string]string, region string, preset S3Preset) (s3CredentialSettings, error) {
+	s := s3CredentialSettings{
+		Source:      creds["credential_source"],
+		AccessKey:   creds["access_key_id"],
+		SecretKey:   creds["secret_access_key"],
+		Profile:     creds["profile"],
+		TokenFile:   creds["web_identity_token_file"],
+		RoleARN:     creds["role_arn"],
+		ExternalID:  creds["external_id"],
+		SessionName: creds["role_session_name"],
+		STSEndpoint: creds["sts_endpoint"],
+		Region:      region,
+	}
+	if preset.SessionToken {
+		s.SessionToken = creds["session_token"]
+	}
+	if s.Source == "" {
+		switch {
+		case s.Profile != "":
+			s.Source = "default"
+		case s.TokenFile != "":
+			s.Source = "web_identity"
+		default:
+			s.Source = "static"
+		}
+	}
+	switch s.Source {
+	case "static":
+		if s.AccessKey == "" || s.SecretKey == "" {
+			return s, errors.New("missing access_key_id or secret_access_key")
+		}
+	case "default":
+		if err := requireAmbient(`credential_source "default"`); err != nil {
+			return s, err
+		}
+	case "web_identity":
+		if err := requireAmbient(`credential_source "web_identity"`); err != nil {
+			return s, err
+		}
+		if s.TokenFile == "" || s.RoleARN == "" {
+			return s, errors.New("web_identity needs web_identity_token_file and role_arn")
+		}
+	default:
+		return s, fmt.Errorf(`credential_source must be "static", "default" or "web_identity", got %q`, s.Source)
+	}
+	if s.Profile != "" && s.Source != "default" {
+		return s, errors.New(`profile is only used with credential_source "default"`)
+	}
+	if s.RoleARN == "" && (s.ExternalID != "" || creds["duration"] != "") {
+		return s, errors.New("external_id and duration need a role_arn")
+	}
+	if v := creds["duration"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 15*time.Minute || d > 12*time.Hour {
+			return s, fmt.Errorf("duration must be between 15m and 12h, got %q", v)
+		}
+		s.Duration = d
+	}
+	if s.SessionName == "" {
+		s.SessionName = "vestra"
+	}
+	return s, nil
+}
+
+// s3Credentials returns the credentials provider for a connection, shared
+// with earlier backends of the same connection when they are temporary.
+func s3Credentials(ctx context.Context, creds map[string]string, region string, preset S3Preset) (aws.CredentialsProvider, error) {
+	s, err := parseS3CredentialSettings(creds, region, preset)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	if s.Source == "static" && s.RoleARN == "" {
+		return awsauth.NewStaticCredentialsProvider(s.AccessKey, s.SecretKey, s.SessionToken), nil
+	}
+
+	raw, _ := json.Marshal(s)
+	key := sha256.Sum256(raw)
+	now := time.Now()
+	s3CredentialsCache.Lock()
+	defer s3CredentialsCache.Unlock()
+	if e, ok := s3CredentialsCache.m[key]; ok {
+		e.used = now
+		return e.provider, nil
+	}
+	provider, err := s.provider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for k, e := range s3CredentialsCache.m {
+		if now.Sub(e.used) > s3CredentialsIdle {
+			delete(s3CredentialsCache.m, k)
+		}
+	}
+	s3CredentialsCache.m[key] = &s3CredentialsEntry{provider: provider, used: now}
+	return provider, nil
+}
+
+// provider builds a caching provider for s. Nothing is fetched yet; the
+// first request using the provider calls STS.
+func (s s3CredentialSettings) provider(ctx context.Context) (aws.CredentialsProvider, error) {
+	var base aws.CredentialsProvider
+	switch s.Source {
+	case "static":
+		base = awsauth.NewStaticCredentialsProvider(s.AccessKey, s.SecretKey, s.SessionToken)
+	case "default":
+		opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(s.Region)}
+		if s.Profile != "" {
+			opts = append(opts, awsconfig.WithSharedConfigProfile(s.Profile))
+		}
+		cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Credentials == nil {
+			return nil, &Error{Code: CodeAccessDenied, Err: errors.New("no credentials found in the server's default chain")}
+		}
+		base = cfg.Credentials
+	}
+
+	var p aws.CredentialsProvider
+	switch {
+	case s.Source == "web_identity":
+		p = stscreds.NewWebIdentityRoleProvider(s.stsClient(nil), s.RoleARN, stscreds.IdentityTokenFile(s.TokenFile),
+			func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = s.SessionName
+				o.Duration = s.Duration
+			})
+	case s.RoleARN != "":
+		p = stscreds.NewAssumeRoleProvider(s.stsClient(base), s.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = s.SessionName
+			if s.ExternalID != "" {
+				o.ExternalID = aws.String(s.ExternalID)
+			}
+			if s.Duration > 0 {
+				o.Duration = s.Duration
+			}
+		})
+	default:
+		// The default chain comes with its own cache.
+		p = base
+	}
+	if c, ok := p.(*aws.CredentialsCache); ok {
+		return c, nil
+	}
+	return newS3Session(p), nil
+}
+
+// s3Session caches temporary credentials. It remembers when the current
+// ones really expire, since aws.CredentialsCache reports that time early by
+// the refresh window.
+type s3Session struct {
+	cache *aws.CredentialsCache
+
+	mu      sync.Mutex
+	expires time.Time
+}
+
+func newS3Session(p aws.CredentialsProvider) *s3Session {
+	s := &s3Session{}
+	s.cache = aws.NewCredentialsCache(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		c, err := p.Retrieve(ctx)
+		if err == nil {
+			s.mu.Lock()
+			s.expires = c.Expires
+			s.mu.Unlock()
+		}
+		return c, err
+	}), func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = s3CredentialsRefresh
+	})
+	return s
+}
+
+func (s *s3Session) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return s.cache.Retrieve(ctx)
+}
+
+// stsClient calls STS in the connection's region, or at "sts_endpoint" when
+// set (regional endpoints, private links, test stand-ins).
+func (s s3CredentialSettings) stsClient(creds aws.CredentialsProvider) *sts.Client {
+	return sts.New(sts.Options{Region: s.Region, Credentials: creds}, func(o *sts.Options) {
+		if s.STSEndpoint != "" {
+			o.BaseEndpoint = aws.String(s.STSEndpoint)
+		}
+	})
+}
+
+// CredentialsExpiry reports when the connection's temporary credentials
+// expire (they are refreshed shortly before), or the zero time for
+// long-lived keys.
+func (b *s3Backend) CredentialsExpiry(ctx context.Context) (time.Time, error) {
+	provider := b.client.Options().Credentials
+	c, err := provider.Retrieve(ctx)
+	if err != nil || !c.CanExpire {
+		return time.Time{}, err
+	}
+	if s, ok := provider.(*s3Session); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.expires, nil
+	}
+	return c.Expires, nil
+}
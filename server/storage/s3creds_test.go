@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stsServer answers AssumeRole with credentials that expire after the
+// next lifetime taken from lifetimes, numbering the keys it hands out.
+type stsServer struct {
+	mu        sync.Mutex
+	calls     int
+	lifetimes []time.Duration
+	form      map[string]string
+}
+
+// last returns the number of calls so far and the parameters of the last one.
+func (s *stsServer) last() (int, map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls, s.form
+}
+
+func (s *stsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "AssumeRole" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.calls++
+	n := s.calls
+	lifetime := s.lifetimes[min(n, len(s.lifetimes))-1]
+	s.form = map[string]string{}
+	for k := range r.Form {
+		s.form[k] = r.Form.Get(k)
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIA%d</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/reader/vestra</Arn>
+      <AssumedRoleId>AROA:vestra</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>req-%d</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`, n, time.Now().Add(lifetime).UTC().Format(time.RFC3339), n)
+}
+
+func TestS3AssumeRoleRefresh(t *testing.T) {
+	sts := &stsServer{lifetimes: []time.Duration{
+		// Inside the refresh window: still valid, but due for renewal.
+		s3CredentialsRefresh - time.Minute,
+		time.Hour,
+	}}
+	srv := httptest.NewServer(sts)
+	defer srv.Close()
+
+	creds := map[string]string{
+		"access_key_id":     "AKIA",
+		"secret_access_key": "base-secret",
+		"role_arn":          "arn:aws:iam::123456789012:role/reader",
+		"external_id":       "ext-" + t.Name(),
+		"sts_endpoint":      srv.URL,
+	}
+	ctx := context.Background()
+	provider, err := s3Credentials(ctx, creds, "us-east-1", S3Preset{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retrieve := func() string {
+		t.Helper()
+		c, err := provider.Retrieve(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.CanExpire {
+			t.Error("assumed-role credentials are not marked as expiring")
+		}
+		return c.AccessKeyID
+	}
+
+	if got := retrieve(); got != "ASIA1" {
+		t.Fatalf("first Retrieve = %s, want ASIA1", got)
+	}
+	if _, form := sts.last(); form["RoleArn"] != creds["role_arn"] || form["ExternalId"] != creds["external_id"] || form["RoleSessionName"] != "vestra" {
+		t.Errorf("AssumeRole parameters = %v", form)
+	}
+	// ASIA1 expires within the refresh window, so the next request renews
+	// it before it runs out.
+	if got := retrieve(); got != "ASIA2" {
+		t.Errorf("Retrieve inside the refresh window = %s, want renewed ASIA2", got)
+	}
+	if got := retrieve(); got != "ASIA2" {
+		t.Errorf("Retrieve of fresh credentials = %s, want cached ASIA2", got)
+	}
+	if calls, _ := sts.last(); calls != 2 {
+		t.Errorf("STS was called %d times, want 2", calls)
+	}
+
+	// Backends opened later for the same connection share the session and
+	// report the real expiry rather than the early refresh time.
+	again, err := s3Credentials(ctx, creds, "us-east-1", S3Preset{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != provider {
+		t.Error("a second backend of the connection got its own provider")
+	}
+	session, ok := provider.(*s3Session)
+	if !ok {
+		t.Fatalf("provider is %T, want *s3Session", provider)
+	}
+	session.mu.Lock()
+	expires := session.expires
+	session.mu.Unlock()
+	if left := time.Until(expires); left < 55*time.Minute || left > time.Hour {
+		t.Errorf("recorded expiry is %s away, want about an hour", left)
+	}
+}
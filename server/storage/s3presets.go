@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// S3Preset describes an S3-compatible service: where its endpoint is and
+// which S3 features it handles. A connection picks a preset with the
+// "preset" credential and can override the defaults with the options in
+// s3Options.
+type S3Preset struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Endpoint is a URL template. {region} is replaced by the connection's
+	// region and any other {param} by the credential of the same name. An
+	// empty template means the SDK's default (AWS) or, with EndpointExample
+	// set, that the connection must give its own endpoint.
+	Endpoint        string   `json:"endpoint,omitempty"`
+	EndpointExample string   `json:"endpoint_example,omitempty"`
+	Params          []string `json:"params,omitempty"`
+	Region          string   `json:"region"`
+	PathStyle       bool     `json:"path_style"`
+	// ChecksumWhenRequired turns off the CRC checksums the SDK adds to
+	// uploads by default, which many gateways reject.
+	ChecksumWhenRequired bool `json:"checksum_when_required"`
+	// SessionToken enables the optional "session_token" credential.
+	SessionToken bool `json:"session_token"`
+	// testWithList verifies access with ListObjectsV2 instead of HeadBucket,
+	// because list permission is more commonly granted in some IAM setups.
+	testWithList bool
+}
+
+// s3Presets is the catalog, in the order the UI shows it.
+var s3Presets = []S3Preset{
+	{ID: "aws", Name: "Amazon S3", Region: "us-east-1", SessionToken: true},
+	{ID: "r2", Name: "Cloudflare R2", Endpoint: "https://{account_id}.r2.cloudflarestorage.com", Region: "auto", PathStyle: true, ChecksumWhenRequired: true},
+	{ID: "minio", Name: "MinIO", EndpointExample: "http://minio.internal:9000", Region: "us-east-1", PathStyle: true},
+	{ID: "wasabi", Name: "Wasabi", Endpoint: "https://s3.{region}.wasabisys.com", Region: "us-east-1", ChecksumWhenRequired: true},
+	{ID: "digitalocean", Name: "DigitalOcean Spaces", Endpoint: "https://{region}.digitaloceanspaces.com", Region: "nyc3", ChecksumWhenRequired: true},
+	{ID: "backblaze", Name: "Backblaze B2", Endpoint: "https://s3.{region}.backblazeb2.com", Region: "us-west-004", ChecksumWhenRequired: true},
+	{ID: "ceph", Name: "Ceph RGW", EndpointExample: "https://rgw.example.com", Region: "us-east-1", PathStyle: true, ChecksumWhenRequired: true},
+	{ID: "tencent", Name: "Tencent COS", Endpoint: "https://cos.{region}.myqcloud.com", Region: "ap-guangzhou", ChecksumWhenRequired: true},
+	{ID: "oci", Name: "Oracle OCI", Endpoint: "https://{namespace}.compat.objectstorage.{region}.oraclecloud.com", Region: "us-ashburn-1", PathStyle: true, ChecksumWhenRequired: true},
+	// The ones below are reached through providers of their own; GCS only
+	// for connections with HMAC keys.
+	{ID: "gcs", Name: "Google Cloud Storage (HMAC)", Endpoint: "https://storage.googleapis.com", Region: "auto", PathStyle: true, ChecksumWhenRequired: true, testWithList: true},
+	{ID: "huawei", Name: "Huawei OBS", Endpoint: "https://obs.{region}.myhuaweicloud.com", Region: "cn-north-4", ChecksumWhenRequired: true, testWithList: true},
+	// Alibaba Cloud OSS uses path-style for its S3-compatible API.
+	{ID: "alibaba", Name: "Alibaba Cloud OSS", Endpoint: "https://oss-{region}.aliyuncs.com", Region: "cn-hangzhou", PathStyle: true, ChecksumWhenRequired: true, testWithList: true},
+}
+
+var templateParam = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+func init() {
+	for i := range s3Presets {
+		p := &s3Presets[i]
+		for _, m := range templateParam.FindAllStringSubmatch(p.Endpoint, -1) {
+			if m[1] != "region" {
+				p.Params = append(p.Params, m[1])
+			}
+		}
+	}
+}
+
+// S3Presets returns the catalog of S3-compatible presets.
+func S3Presets() []S3Preset {
+	return s3Presets
+}
+
+func s3Preset(id string) (S3Preset, bool) {
+	for _, p := range s3Presets {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return S3Preset{}, false
+}
+
+// endpoint expands the preset's template for a connection.
+func (p S3Preset) endpoint(region string, creds map[string]string) (string, error) {
+	if p.Endpoint == "" {
+		if p.EndpointExample != "" {
+			return "", fmt.Errorf("missing endpoint (e.g. %s)", p.EndpointExample)
+		}
+		return "", nil
+	}
+	var missing []string
+	url := templateParam.ReplaceAllStringFunc(p.Endpoint, func(m string) string {
+		name := m[1 : len(m)-1]
+		if name == "region" {
+			return region
+		}
+		if creds[name] == "" {
+			missing = append(missing, name)
+		}
+		return creds[name]
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing %s (needed for the %s endpoint %s)", strings.Join(missing, ", "), p.Name, p.Endpoint)
+	}
+	return url, nil
+}
+
+// s3Options are the per-connection switches read from the credentials. Empty
+// values fall back to the preset.
+type s3Options struct {
+	// pathStyle is nil unless "addressing_style" is "path" or "virtual".
+	pathStyle *bool
+	checksum  aws.RequestChecksumCalculation
+	// unsignedPayload sends UNSIGNED-PAYLOAD instead of hashing the body,
+	// for gateways that mishandle signed streaming uploads.
+	unsignedPayload bool
+	// legacy disables SDK features newer than most gateways: flexible
+	// checksums (unless "checksum" is set) and S3 Express session auth.
+	legacy bool
+}
+
+func parseS3Options(creds map[string]string, preset S3Preset) (s3Options, error) {
+	var o s3Options
+	switch v := creds["addressing_style"]; v {
+	case "":
+	case "path", "virtual":
+		o.pathStyle = aws.Bool(v == "path")
+	default:
+		return o, fmt.Errorf(`addressing_style must be "path" or "virtual", got %q`, v)
+	}
+	switch v := creds["payload_signing"]; v {
+	case "", "signed":
+	case "unsigned":
+		o.unsignedPayload = true
+	default:
+		return o, fmt.Errorf(`payload_signing must be "signed" or "unsigned", got %q`, v)
+	}
+	if v := creds["legacy_gateway"]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return o, fmt.Errorf("legacy_gateway must be true or false, got %q", v)
+		}
+		o.legacy = b
+	}
+	switch v := creds["checksum"]; v {
+	case "":
+		if preset.ChecksumWhenRequired || o.legacy {
+			o.checksum = aws.RequestChecksumCalculationWhenRequired
+		} else {
+			o.checksum = aws.RequestChecksumCalculationWhenSupported
+		}
+	case "when_supported":
+		o.checksum = aws.RequestChecksumCalculationWhenSupported
+	case "when_required":
+		o.checksum = aws.RequestChecksumCalculationWhenRequired
+	default:
+		return o, fmt.Errorf(`checksum must be "when_supported" or "when_required", got %q`, v)
+	}
+	return o, nil
+}
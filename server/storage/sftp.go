@@ -0,0 +1,463 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	Register("sftp", newSFTPBackend)
+	registerClassifier(func(err error) (string, map[string]any, bool) {
+		var se *sftp.StatusError
+		if errors.As(err, &se) {
+			details := map[string]any{"provider_code": se.FxCode().Error()}
+			if se.FxCode() == sftp.ErrSSHFxOpUnsupported {
+				return CodeNotSupported, details, true
+			}
+			return CodeInternal, details, true
+		}
+		if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, sftp.ErrSSHFxNoConnection) {
+			return CodeUnavailable, nil, true
+		}
+		return "", nil, false
+	})
+}
+
+// sftpTempPrefix names the files uploads are written to before being renamed
+// into place. They are hidden from listings.
+const sftpTempPrefix = ".vestra-upload-"
+
+// errHostKeyMismatch is returned when the server's key does not match the
+// host_key saved on the connection.
+var errHostKeyMismatch = errors.New("ssh: host key does not match the configured host_key")
+
+// sftpUnpinned records the user@host pairs already warned about connecting
+// with insecure_ignore_host_key, so the log gets one line per server rather
+// than one per request.
+var sftpUnpinned sync.Map
+
+// sftpBackend exposes a directory on an SFTP server. The bucket is the
+// directory, absolute or relative to the login directory. SFTP has no
+// object metadata beyond size and modification time, so content types are
+// guessed from the extension and the metadata editor is unavailable.
+type sftpBackend struct {
+	ssh    *ssh.Client
+	client *sftp.Client
+	root   string
+	// stop detaches the close-on-cancel hook registered when dialing.
+	stop func() bool
+}
+
+type sftpCredentials struct {
+	Host       string `json:"host"`
+	Port       string `json:"port"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	PrivateKey string `json:"private_key"`
+	Passphrase string `json:"passphrase"`
+	// HostKey pins the server's key, as a SHA256 fingerprint or an
+	// authorized_keys line.
+	HostKey string `json:"host_key"`
+	// InsecureIgnoreHostKey connects without a HostKey, to whoever answers.
+	InsecureIgnoreHostKey bool `json:"insecure_ignore_host_key"`
+}
+
+// newSFTPBackend connects to the server. The credentials hold "host",
+// optional "port" (22), "username", and "password" and/or "private_key"
+// (PEM, with an optional "passphrase"), and "host_key". Without host_key
+// anyone in the network path could pose as the server, so it is required
+// unless "insecure_ignore_host_key" is set, which is logged.
+func newSFTPBackend(ctx context.Context, dir, credentials string) (Backend, error) {
+	var creds sftpCredentials
+	if err := json.Unmarshal([]byte(credentials), &creds); err != nil {
+		return nil, invalid(err)
+	}
+	host, user := creds.Host, creds.Username
+	if host == "" || user == "" {
+		return nil, invalid(errors.New("missing host or username"))
+	}
+	port := creds.Port
+	if port == "" {
+		port = "22"
+	}
+	if dir == "" {
+		return nil, invalid(errors.New("missing directory (e.g. /srv/files)"))
+	}
+
+	var auth []ssh.AuthMethod
+	if pemKey := creds.PrivateKey; pemKey != "" {
+		var signer ssh.Signer
+		var err error
+		if pass := creds.Passphrase; pass != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(pemKey), []byte(pass))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(pemKey))
+		}
+		if err != nil {
+			return nil, invalid(fmt.Errorf("invalid private_key: %w", err))
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if pass := creds.Password; pass != "" {
+		auth = append(auth, ssh.Password(pass), ssh.KeyboardInteractive(
+			func(_, _ string, questions []string, _ []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = pass
+				}
+				return answers, nil
+			}))
+	}
+	if len(auth) == 0 {
+		return nil, invalid(errors.New("missing password or private_key"))
+	}
+	addr := net.JoinHostPort(host, port)
+	var hostKey ssh.HostKeyCallback
+	switch {
+	case strings.TrimSpace(creds.HostKey) != "":
+		var err error
+		if hostKey, err = sftpHostKeyCallback(creds.HostKey); err != nil {
+			return nil, invalid(err)
+		}
+	case creds.InsecureIgnoreHostKey:
+		if _, warned := sftpUnpinned.LoadOrStore(user+"@"+addr, true); !warned {
+			log.Printf("sftp %s@%s: insecure_ignore_host_key is set; the server's identity is not verified", user, addr)
+		}
+		hostKey = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, invalid(errors.New("host_key is required: set it to the server's SHA256 fingerprint " +
+			"(ssh-keyscan HOST | ssh-keygen -lf -), or set insecure_ignore_host_key to skip the check"))
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &Error{Code: CodeUnavailable, Err: err}
+	}
+	// The handshake does not take a context, so bound it with a deadline.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, sftpDialError(ctx, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	sshClient := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, &Error{Code: CodeUnavailable, Err: fmt.Errorf("starting the sftp subsystem: %w", err)}
+	}
+	root, err := client.RealPath(dir)
+	if err != nil {
+		client.Close()
+		sshClient.Close()
+		return nil, fsError(err)
+	}
+	b := &sftpBackend{ssh: sshClient, client: client, root: root}
+	// Requests on the client do not take a context either; closing the
+	// connection is the only way to abort them.
+	b.stop = context.AfterFunc(ctx, func() { b.Close() })
+	return b, nil
+}
+
+// sftpHostKeyCallback checks the server's key against the pinned host_key.
+func sftpHostKeyCallback(pinned string) (ssh.HostKeyCallback, error) {
+	pinned = strings.TrimSpace(pinned)
+	if !strings.HasPrefix(pinned, "SHA256:") {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned))
+		if err != nil {
+			return nil, fmt.Errorf("invalid host_key: expected a SHA256 fingerprint or an authorized_keys line")
+		}
+		pinned = ssh.FingerprintSHA256(key)
+	}
+	return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		if got := ssh.FingerprintSHA256(key); got != pinned {
+			return fmt.Errorf("%w (server presented %s)", errHostKeyMismatch, got)
+		}
+		return nil
+	}, nil
+}
+
+// sftpDialError classifies a failed SSH handshake.
+func sftpDialError(ctx context.Context, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return &Error{Code: CodeTimeout, Err: err}
+	case errors.Is(err, errHostKeyMismatch):
+		return &Error{Code: CodeAccessDenied, Err: err}
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		return &Error{Code: CodeAccessDenied, Err: err}
+	}
+	return &Error{Code: CodeUnavailable, Err: err}
+}
+
+// path resolves a key to a remote path below the root.
+func (b *sftpBackend) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return path.Join(b.root, key), nil
+}
+
+func (b *sftpBackend) Test(ctx context.Context) error {
+	info, err := b.client.Stat(b.root)
+	if err != nil {
+		return fsError(err)
+	}
+	if !info.IsDir() {
+		return &Error{Code: CodeInvalid, Err: fmt.Errorf("%s is not a directory", b.root)}
+	}
+	_, err = b.client.ReadDirContext(ctx, b.root)
+	return fsError(err)
+}
+
+// List reads one directory, sorted by name like the local provider.
+func (b *sftpBackend) List(ctx context.Context, prefix, pageToken string, limit int) (*Page, error) {
+	dirKey, namePrefix := splitPrefix(prefix)
+	dir, err := b.path(dirKey)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := b.client.ReadDirContext(ctx, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Page{Entries: []Entry{}}, nil
+	}
+	if err != nil {
+		return nil, fsError(err)
+	}
+	byName := make(map[string]fs.FileInfo, len(infos))
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), sftpTempPrefix) {
+			continue
+		}
+		byName[info.Name()] = info
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	stat := func(name string) (fs.FileInfo, error) {
+		info := byName[name]
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return b.client.Stat(path.Join(dir, name))
+		}
+		return info, nil
+	}
+	return listDir(names, dirKey, namePrefix, pageToken, limit, stat, guessContentType), nil
+}
+
+func (b *sftpBackend) Walk(ctx context.Context, prefix string, fn func(Object) error) error {
+	dirKey, _ := splitPrefix(prefix)
+	start, err := b.path(dirKey)
+	if err != nil {
+		return err
+	}
+	walker := b.client.Walk(start)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if walker.Path() == start && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return fsError(err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info := walker.Stat()
+		if info.IsDir() || strings.HasPrefix(info.Name(), sftpTempPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), b.root), "/")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		err := fn(Object{
+			Name:        key,
+			Size:        info.Size(),
+			Updated:     info.ModTime().UTC(),
+			ContentType: guessContentType(key),
+		})
+		if err == StopWalk {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *sftpBackend) Stat(ctx context.Context, key string) (*Metadata, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.client.Stat(p)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	md := &Metadata{
+		ContentType: guessContentType(key),
+		Metadata:    map[string]string{},
+		Size:        info.Size(),
+		Updated:     info.ModTime().UTC(),
+		ETag:        fileETag(info),
+	}
+	if info.IsDir() {
+		md.ContentType, md.Size = "", 0
+	}
+	return md, nil
+}
+
+func (b *sftpBackend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := b.client.Open(p)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.Close()
+		return nil, &Error{Code: CodeInvalid, Err: fmt.Errorf("%s is a folder", key)}
+	}
+	return f, nil
+}
+
+// writeFile stores r under key through a temp file in the same directory,
+// renamed into place once complete.
+func (b *sftpBackend) writeFile(ctx context.Context, key string, r io.Reader) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(key, "/") {
+		return fsError(b.client.MkdirAll(p))
+	}
+	if err := b.client.MkdirAll(path.Dir(p)); err != nil {
+		return fsError(err)
+	}
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+	tmp := path.Join(path.Dir(p), sftpTempPrefix+hex.EncodeToString(suffix))
+	f, err := b.client.Create(tmp)
+	if err != nil {
+		return fsError(err)
+	}
+	defer b.client.Remove(tmp)
+	if _, err := io.Copy(f, contextReader{ctx, r}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return b.replace(tmp, p)
+}
+
+// replace renames from over to. Plain SFTP rename fails when the target
+// exists, so the OpenSSH extension is used where the server has it.
+func (b *sftpBackend) replace(from, to string) error {
+	if _, ok := b.client.HasExtension("posix-rename@openssh.com"); ok {
+		return fsError(b.client.PosixRename(from, to))
+	}
+	if err := b.client.Remove(to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fsError(err)
+	}
+	return fsError(b.client.Rename(from, to))
+}
+
+// Put ignores contentType: there is nowhere to store it.
+func (b *sftpBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	return b.writeFile(ctx, key, r)
+}
+
+// Copy streams the object through the server; SFTP has no server-side copy.
+func (b *sftpBackend) Copy(ctx context.Context, src, dst string) error {
+	rc, err := b.Open(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return b.writeFile(ctx, dst, rc)
+}
+
+func (b *sftpBackend) Rename(ctx context.Context, src, dst string) error {
+	from, err := b.path(src)
+	if err != nil {
+		return err
+	}
+	to, err := b.path(dst)
+	if err != nil {
+		return err
+	}
+	if err := b.client.MkdirAll(path.Dir(to)); err != nil {
+		return fsError(err)
+	}
+	if err := b.replace(from, to); err != nil {
+		return err
+	}
+	b.pruneEmpty(path.Dir(from))
+	return nil
+}
+
+// Delete removes a file and any folders left empty, like the local provider.
+func (b *sftpBackend) Delete(ctx context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := b.client.Remove(p); err != nil {
+		return fsError(err)
+	}
+	b.pruneEmpty(path.Dir(p))
+	return nil
+}
+
+// pruneEmpty removes dir and its parents below the root while they are
+// empty. RemoveDirectory fails on non-empty folders, which ends the loop.
+func (b *sftpBackend) pruneEmpty(dir string) {
+	for ; dir != b.root && strings.HasPrefix(dir, b.root+"/"); dir = path.Dir(dir) {
+		if b.client.RemoveDirectory(dir) != nil {
+			return
+		}
+	}
+}
+
+// Presign is not possible over SFTP; downloads are streamed through the
+// server instead.
+func (b *sftpBackend) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (b *sftpBackend) UpdateMetadata(ctx context.Context, key string, u MetadataUpdate) error {
+	return ErrNotSupported
+}
+
+func (b *sftpBackend) Close() error {
+	b.stop()
+	b.client.Close()
+	return b.ssh.Close()
+}
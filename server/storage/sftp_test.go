@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSFTPServer serves dir over SFTP on a random local port for user
+// "vestra" with password "secret". It returns the address and the host key
+// fingerprint.
+func startSFTPServer(t *testing.T, dir string) (addr, fingerprint string) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "vestra" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTPConn(conn, config, dir)
+		}
+	}()
+	return ln.Addr().String(), ssh.FingerprintSHA256(signer.PublicKey())
+}
+
+func serveSFTPConn(conn net.Conn, config *ssh.ServerConfig, dir string) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				// The payload is the length-prefixed subsystem name.
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(ch, sftp.WithServerWorkingDirectory(dir))
+				if err != nil {
+					ch.Close()
+					return
+				}
+				go func() {
+					server.Serve()
+					server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+func openSFTP(t *testing.T, addr, dir string, creds map[string]any) (Backend, error) {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(addr)
+	creds["host"], creds["port"], creds["username"] = host, port, "vestra"
+	data, _ := json.Marshal(creds)
+	return Open(context.Background(), "sftp", dir, string(data))
+}
+
+func TestSFTPBackend(t *testing.T) {
+	dir := t.TempDir()
+	addr, fingerprint := startSFTPServer(t, dir)
+	b, err := openSFTP(t, addr, dir, map[string]any{"password": "secret", "host_key": fingerprint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	ctx := context.Background()
+
+	if err := b.Put(ctx, "docs/a.txt", strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, "b.txt", strings.NewReader("bee"), 3, ""); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "docs", "a.txt")); err != nil || string(data) != "hello" {
+		t.Fatalf("uploaded file = %q, %v", data, err)
+	}
+
+	page, err := b.List(ctx, "", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range page.Entries {
+		names = append(names, e.Type+":"+e.Name)
+	}
+	if got := strings.Join(names, ","); got != "file:b.txt,dir:docs/" {
+		t.Errorf("List = %s", got)
+	}
+
+	rc, err := b.Open(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Open = %q, %v", data, err)
+	}
+
+	r, ok := b.(Renamer)
+	if !ok {
+		t.Fatal("the sftp backend is not a Renamer")
+	}
+	if err := r.Rename(ctx, "docs/a.txt", "moved/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat(ctx, "docs/a.txt"); Classify("sftp", err).Code != CodeNotFound {
+		t.Errorf("Stat of the renamed source = %v, want not found", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs")); err == nil {
+		t.Error("Rename left the emptied folder behind")
+	}
+	if md, err := b.Stat(ctx, "moved/a.txt"); err != nil || md.Size != 5 {
+		t.Errorf("Stat(moved/a.txt) = %+v, %v", md, err)
+	}
+
+	if err := b.Delete(ctx, "moved/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "moved")); err == nil {
+		t.Error("Delete left the emptied folder behind")
+	}
+	if err := b.Delete(ctx, "moved/a.txt"); Classify("sftp", err).Code != CodeNotFound {
+		t.Errorf("second Delete = %v, want not found", err)
+	}
+}
+
+func TestSFTPHostKey(t *testing.T) {
+	dir := t.TempDir()
+	addr, _ := startSFTPServer(t, dir)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := string(pem.EncodeToMemory(block))
+	for _, c := range []struct {
+		name  string
+		creds map[string]any
+		code  string
+	}{
+		{"password without host_key", map[string]any{"password": "secret"}, CodeInvalid},
+		{"private_key without host_key", map[string]any{"private_key": pemKey}, CodeInvalid},
+		{"insecure_ignore_host_key off", map[string]any{"password": "secret", "insecure_ignore_host_key": false}, CodeInvalid},
+		{"wrong host_key", map[string]any{"password": "secret",
+			"host_key": "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}, CodeAccessDenied},
+		{"wrong host_key despite insecure_ignore_host_key", map[string]any{"password": "secret", "insecure_ignore_host_key": true,
+			"host_key": "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}, CodeAccessDenied},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			b, err := openSFTP(t, addr, dir, c.creds)
+			if err == nil {
+				b.Close()
+				t.Fatal("Open succeeded")
+			}
+			if got := Classify("sftp", err).Code; got != c.code {
+				t.Errorf("code %s, want %s (%v)", got, c.code, err)
+			}
+		})
+	}
+
+	// Unpinned connections need the explicit opt-in.
+	b, err := openSFTP(t, addr, dir, map[string]any{"password": "secret", "insecure_ignore_host_key": true})
+	if err != nil {
+		t.Fatalf("Open with insecure_ignore_host_key: %v", err)
+	}
+	b.Close()
+}
@@ -0,0 +1,223 @@
+// Package storage defines the provider-agnostic Backend interface used by the
+// HTTP handlers, plus a registry of provider adapters (S3-compatible, GCS,
+// Azure Blob). Adding a provider means writing one adapter and registering it.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is one row of a hierarchical (delimiter) listing.
+type Entry struct {
+	Type        string    `json:"type"` // "dir" | "file"
+	Name        string    `json:"name"`
+	Display     string    `json:"display"`
+	Size        int64     `json:"size,omitempty"`
+	Updated     time.Time `json:"updated,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+// Page is a single page of a hierarchical listing.
+type Page struct {
+	Entries       []Entry
+	NextPageToken string
+}
+
+// Object is one row of a flat (recursive) listing.
+type Object struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	Updated     time.Time `json:"updated"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+// Metadata is the full set of attributes returned for a single object.
+type Metadata struct {
+	ContentType  string            `json:"content_type"`
+	CacheControl string            `json:"cache_control"`
+	Metadata     map[string]string `json:"metadata"`
+	Size         int64             `json:"size"`
+	Updated      time.Time         `json:"updated"`
+	ETag         string            `json:"etag"`
+	MD5          string            `json:"md5,omitempty"`
+}
+
+// MetadataUpdate is the patch applied by Backend.UpdateMetadata.
+// Empty ContentType / CacheControl leave the current value untouched where
+// the provider allows it; a nil Metadata map leaves custom metadata as is.
+type MetadataUpdate struct {
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
+}
+
+// ErrNotSupported is returned by operations a provider cannot perform, such
+// as Presign on backends without signed URLs.
+var ErrNotSupported = errors.New("operation not supported by this provider")
+
+// StopWalk can be returned from a Walk callback to end the walk early
+// without Walk itself returning an error.
+var StopWalk = errors.New("stop walk")
+
+// Backend is implemented once per provider. A Backend is bound to a single
+// bucket (or container) and set of credentials; callers must Close it.
+type Backend interface {
+	// Test verifies that the bucket is reachable with the given credentials.
+	Test(ctx context.Context) error
+	// List returns files and virtual folders directly under prefix.
+	List(ctx context.Context, prefix, pageToken string, limit int) (*Page, error)
+	// Walk calls fn for every object under prefix, recursively.
+	Walk(ctx context.Context, prefix string, fn func(Object) error) error
+	// Stat returns the metadata of a single object.
+	Stat(ctx context.Context, key string) (*Metadata, error)
+	// Open returns a reader over the object's contents.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put writes an object. size is -1 when unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Copy duplicates src to dst within the same bucket.
+	Copy(ctx context.Context, src, dst string) error
+	// Delete removes a single object.
+	Delete(ctx context.Context, key string) error
+	// Presign returns a time-limited download URL for the object, or
+	// ErrNotSupported when the provider has no such URLs; the server then
+	// streams the download itself.
+	Presign(ctx context.Context, key string, expires time.Duration) (string, error)
+	// UpdateMetadata patches content-type, cache-control and custom metadata.
+	UpdateMetadata(ctx context.Context, key string, u MetadataUpdate) error
+	// Close releases any resources held by the backend.
+	Close() error
+}
+
+// Renamer is implemented by backends that can move an object in one step.
+// Rename/move requests use it instead of Copy followed by Delete.
+type Renamer interface {
+	Rename(ctx context.Context, src, dst string) error
+}
+
+// BatchDeleter is implemented by backends that can delete several objects
+// in one request. Use DeleteMany rather than calling it directly.
+type BatchDeleter interface {
+	// DeleteBatchSize is the most keys DeleteBatch takes at once.
+	DeleteBatchSize() int
+	// DeleteBatch deletes keys and returns the errors of those it could not
+	// delete, by key. An error means the request as a whole failed.
+	DeleteBatch(ctx context.Context, keys []string) (map[string]error, error)
+}
+
+// CredentialsExpirer is implemented by backends that can authenticate with
+// temporary credentials. CredentialsExpiry returns when the current ones
+// expire, or the zero time if they don't; they are renewed before then.
+type CredentialsExpirer interface {
+	CredentialsExpiry(ctx context.Context) (time.Time, error)
+}
+
+// RangeOpener is implemented by backends that can read part of an object
+// without fetching what comes before it. Use OpenRange rather than calling
+// it directly.
+type RangeOpener interface {
+	// OpenRange reads length bytes of key starting at offset, or everything
+	// from offset when length is -1.
+	OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Upload is a multipart upload in progress. ID is the provider's handle for
+// it, set by StartUpload and kept by the caller between requests.
+type Upload struct {
+	Key         string
+	ContentType string
+	Size        int64
+	ID          string
+}
+
+// MultipartUploader is implemented by backends that can assemble an object
+// from parts sent over time, possibly by different requests or processes.
+// Parts are numbered from 1 and sent in order; every part but the last is
+// the same size, a multiple of 256 KiB and at least 5 MiB. Sending a part
+// again replaces it.
+type MultipartUploader interface {
+	StartUpload(ctx context.Context, u *Upload) error
+	// UploadPart sends part n, which starts at offset in the object, and
+	// returns the tag CompleteUpload needs for it.
+	UploadPart(ctx context.Context, u Upload, n int, offset int64, r io.ReadSeeker, size int64) (string, error)
+	CompleteUpload(ctx context.Context, u Upload, tags []string) error
+	AbortUpload(ctx context.Context, u Upload) error
+}
+
+// UploadTarget is where a client sends an object straight to the provider.
+// A PUT target takes the object as the request body, sent with Headers. A
+// POST target takes a multipart form of Fields followed by the object in a
+// field named "file".
+type UploadTarget struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// UploadPresigner is implemented by backends that can sign a direct upload
+// of u.Key, so the bytes do not pass through the server. method is "PUT" or
+// "POST"; a backend returns ErrNotSupported for one it cannot sign. Where the
+// provider can enforce it, a PUT target accepts exactly u.Size bytes, a POST
+// target up to u.Size, and both only u.ContentType.
+type UploadPresigner interface {
+	PresignUpload(ctx context.Context, u Upload, method string, expires time.Duration) (*UploadTarget, error)
+}
+
+// Factory builds a Backend for a bucket from the raw credentials string
+// stored on a connection.
+type Factory func(ctx context.Context, bucket, credentials string) (Backend, error)
+
+var registry = map[string]Factory{}
+
+// Register makes a provider available under name. It panics on duplicates
+// so that a copy-pasted adapter fails loudly at startup.
+func Register(name string, f Factory) {
+	if _, dup := registry[name]; dup {
+		panic("storage: provider registered twice: " + name)
+	}
+	registry[name] = f
+}
+
+// Providers returns the registered provider names in sorted order.
+func Providers() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Known reports whether name is a registered provider.
+func Known(name string) bool {
+	_, ok := registry[name]
+	return ok
+}
+
+// Open builds a Backend for the given provider. Its errors are classified
+// with Classify: settings a factory rejects are CodeInvalid, while a factory
+// that connects or looks up credentials (SFTP, assumed roles, ambient
+// identities) can also fail with a network, access or other provider code.
+func Open(ctx context.Context, provider, bucket, credentials string) (Backend, error) {
+	f, ok := registry[provider]
+	if !ok {
+		return nil, invalid(fmt.Errorf("unknown provider %q", provider))
+	}
+	b, err := f(ctx, bucket, credentials)
+	if err != nil {
+		return nil, Classify(provider, err)
+	}
+	return b, nil
+}
+
+// display strips the listing prefix (and a trailing slash for folders) from
+// an object name so the UI can show it relative to the current folder.
+func display(name, prefix string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, prefix), "/")
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenErrors(t *testing.T) {
+	SetAmbientCredentials(true)
+	defer SetAmbientCredentials(false)
+	// Application default credentials that point nowhere: the lookup fails
+	// on the server host, not because of the connection's settings.
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+
+	for _, c := range []struct {
+		name, provider, bucket, credentials string
+		code                                string
+	}{
+		{"unknown provider", "nope", "b", "{}", CodeInvalid},
+		{"malformed credentials", "aws", "b", "{", CodeInvalid},
+		{"missing keys", "aws", "b", `{"access_key_id": "AK"}`, CodeInvalid},
+		{"bad azure setting", "azure", "c", `{"credential_source": "cli"}`, CodeInvalid},
+		{"bad memory bucket", "memory", "a/b", "", CodeInvalid},
+		{"default credentials missing", "gcp", "b", `{"credential_source": "default"}`, CodeInternal},
+		{"sftp server down", "sftp", "/srv", `{"host": "127.0.0.1", "port": "1", "username": "u", "password": "p",
+			"host_key": "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}`, CodeUnavailable},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			b, err := Open(context.Background(), c.provider, c.bucket, c.credentials)
+			if err == nil {
+				b.Close()
+				t.Fatal("Open succeeded")
+			}
+			if got := Classify(c.provider, err); got.Code != c.code {
+				t.Errorf("code %s, want %s (%v)", got.Code, c.code, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package storage
+
+// Uploads are streamed to the providers in parts. An upload holds at most
+// uploadPartSize × uploadConcurrency bytes in memory, however large the
+// file is.
+
+// uploadPartSize is the size of one multipart part (S3), resumable chunk
+// (GCS) or staged block (Azure).
+var uploadPartSize int64 = 16 << 20
+
+// uploadConcurrency is how many parts of one upload are sent at once.
+var uploadConcurrency = 4
+
+// minUploadPartSize is the smallest part S3 accepts.
+const minUploadPartSize = 5 << 20
+
+// uploadPartAlign is the granularity of GCS resumable upload chunks.
+const uploadPartAlign = 256 << 10
+
+// SetUploadParts sets the part size and the number of parts sent in
+// parallel for streamed uploads. Part sizes below the S3 minimum of 5 MiB
+// are raised to it, and others rounded down to a multiple of 256 KiB.
+func SetUploadParts(partSize int64, concurrency int) {
+	partSize -= partSize % uploadPartAlign
+	uploadPartSize = max(partSize, minUploadPartSize)
+	uploadConcurrency = max(concurrency, 1)
+}
+
+// UploadPartSize is the size of the parts of resumable uploads.
+func UploadPartSize() int64 { return uploadPartSize }
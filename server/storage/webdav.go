@@ -0,0 +1,618 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("webdav", newWebDAVBackend)
+}
+
+// Namespaces of the dead properties that back the metadata editor. Custom
+// metadata keys become properties in davMetaNS; content type and cache
+// control overrides live in davObjectNS, because DAV:getcontenttype is
+// read-only on most servers.
+const (
+	davMetaNS   = "urn:x-vestra:meta"
+	davObjectNS = "urn:x-vestra:object"
+)
+
+// davMetaKey limits custom metadata keys to names that are valid XML
+// element names.
+var davMetaKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+// webdavBackend exposes a collection on a WebDAV server (Nextcloud, ownCloud,
+// Apache mod_dav, nginx, …). The bucket is the collection's path below the
+// configured URL; "" or "/" is the URL itself.
+type webdavBackend struct {
+	client *http.Client
+	base   *url.URL
+	// root is the decoded URL path of the collection, with a trailing slash.
+	root string
+	auth func(*http.Request)
+}
+
+// newWebDAVBackend reads "url" and either "username" and "password" (basic
+// auth) or "token" (bearer auth) from the credentials. Without either the
+// server is accessed anonymously.
+func newWebDAVBackend(ctx context.Context, dir, credentials string) (Backend, error) {
+	var creds map[string]string
+	if err := json.Unmarshal([]byte(credentials), &creds); err != nil {
+		return nil, invalid(err)
+	}
+	if creds["url"] == "" {
+		return nil, invalid(fmt.Errorf("missing url (e.g. https://cloud.example.com/remote.php/dav/files/alice/)"))
+	}
+	base, err := url.Parse(creds["url"])
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, invalid(fmt.Errorf("url must be an http(s) URL, got %q", creds["url"]))
+	}
+	if err := checkKey(strings.Trim(dir, "/")); err != nil {
+		return nil, err
+	}
+	b := &webdavBackend{
+		client: &http.Client{},
+		base:   base,
+		root:   strings.TrimSuffix(path.Join("/", base.Path, dir), "/") + "/",
+		auth:   func(*http.Request) {},
+	}
+	switch user, pass, token := creds["username"], creds["password"], creds["token"]; {
+	case token != "":
+		b.auth = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	case user != "":
+		b.auth = func(r *http.Request) { r.SetBasicAuth(user, pass) }
+	}
+	return b, nil
+}
+
+// url returns the address of key; keys ending in "/" are collections.
+func (b *webdavBackend) url(key string) string {
+	u := *b.base
+	u.Path = b.root + key
+	u.RawPath = ""
+	u.RawQuery = ""
+	return u.String()
+}
+
+func (b *webdavBackend) do(ctx context.Context, method, key string, body io.Reader, header http.Header) (*http.Response, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	b.auth(req)
+	return b.client.Do(req)
+}
+
+// davStatusError turns an unexpected response into a storage error.
+func davStatusError(method, key string, resp *http.Response) error {
+	return &Error{
+		Code:    codeForStatus(resp.StatusCode),
+		Details: map[string]any{"status": resp.StatusCode},
+		Err:     fmt.Errorf("webdav %s %q: %s", method, key, resp.Status),
+	}
+}
+
+// davExpect checks the response status and discards the body.
+func davExpect(method, key string, resp *http.Response, ok ...int) error {
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	for _, s := range ok {
+		if resp.StatusCode == s {
+			return nil
+		}
+	}
+	return davStatusError(method, key, resp)
+}
+
+// ── PROPFIND ────────────────────────────────────────────────────
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop struct {
+		Props []davProp `xml:",any"`
+	} `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+// davProp is any property: its name, text and the names of its children.
+type davProp struct {
+	XMLName  xml.Name
+	Value    string
+	Children []xml.Name
+}
+
+// UnmarshalXML keeps only the names of child elements, which is all
+// DAV:resourcetype needs.
+func (p *davProp) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	p.XMLName = start.Name
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			p.Value += string(t)
+		case xml.StartElement:
+			p.Children = append(p.Children, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			p.Value = strings.TrimSpace(p.Value)
+			return nil
+		}
+	}
+}
+
+// davEntry is one resource of a PROPFIND response. It implements
+// fs.FileInfo so listings can share listDir with the other file-system-like
+// providers.
+type davEntry struct {
+	key          string
+	dir          bool
+	size         int64
+	modified     time.Time
+	etag         string
+	contentType  string
+	cacheControl string
+	metadata     map[string]string
+}
+
+func (e *davEntry) Name() string       { return path.Base(e.key) }
+func (e *davEntry) Size() int64        { return e.size }
+func (e *davEntry) ModTime() time.Time { return e.modified }
+func (e *davEntry) IsDir() bool        { return e.dir }
+func (e *davEntry) Sys() any           { return nil }
+func (e *davEntry) Mode() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+const davPropfindBody = `<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:allprop/></d:propfind>`
+
+// propfind returns key and, with depth "1", its members. Keys of
+// collections come back without the trailing slash.
+func (b *webdavBackend) propfind(ctx context.Context, key, depth string) ([]*davEntry, error) {
+	resp, err := b.do(ctx, "PROPFIND", key, strings.NewReader(davPropfindBody), http.Header{
+		"Depth":        {depth},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, davStatusError("PROPFIND", key, resp)
+	}
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav PROPFIND %q: invalid response: %w", key, err)
+	}
+	entries := make([]*davEntry, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		if e := b.entry(r); e != nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// entry converts one response, skipping resources outside the root.
+func (b *webdavBackend) entry(r davResponse) *davEntry {
+	href, err := url.Parse(r.Href)
+	if err != nil {
+		return nil
+	}
+	p := href.Path
+	if !strings.HasSuffix(p, "/") && p+"/" == b.root {
+		p += "/"
+	}
+	if !strings.HasPrefix(p, b.root) {
+		return nil
+	}
+	e := &davEntry{key: strings.Trim(strings.TrimPrefix(p, b.root), "/"), metadata: map[string]string{}}
+	for _, ps := range r.Propstats {
+		if !strings.Contains(ps.Status, " 200") {
+			continue
+		}
+		for _, prop := range ps.Prop.Props {
+			switch prop.XMLName {
+			case xml.Name{Space: "DAV:", Local: "resourcetype"}:
+				for _, c := range prop.Children {
+					e.dir = e.dir || c == xml.Name{Space: "DAV:", Local: "collection"}
+				}
+			case xml.Name{Space: "DAV:", Local: "getcontentlength"}:
+				e.size, _ = strconv.ParseInt(prop.Value, 10, 64)
+			case xml.Name{Space: "DAV:", Local: "getlastmodified"}:
+				e.modified, _ = http.ParseTime(prop.Value)
+			case xml.Name{Space: "DAV:", Local: "getetag"}:
+				e.etag = strings.Trim(prop.Value, `"`)
+			case xml.Name{Space: "DAV:", Local: "getcontenttype"}:
+				if e.contentType == "" {
+					e.contentType = prop.Value
+				}
+			case xml.Name{Space: davObjectNS, Local: "content-type"}:
+				e.contentType = prop.Value
+			case xml.Name{Space: davObjectNS, Local: "cache-control"}:
+				e.cacheControl = prop.Value
+			default:
+				if prop.XMLName.Space == davMetaNS {
+					e.metadata[prop.XMLName.Local] = prop.Value
+				}
+			}
+		}
+	}
+	if e.contentType == "" && !e.dir {
+		e.contentType = guessContentType(e.key)
+	}
+	e.modified = e.modified.UTC()
+	return e
+}
+
+// ── Backend ─────────────────────────────────────────────────────
+
+func (b *webdavBackend) Test(ctx context.Context) error {
+	entries, err := b.propfind(ctx, "", "0")
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 || !entries[0].dir {
+		return &Error{Code: CodeInvalid, Err: fmt.Errorf("%s is not a collection", b.url(""))}
+	}
+	return nil
+}
+
+// List runs a Depth: 1 PROPFIND on the folder and pages through the sorted
+// result like the local provider.
+func (b *webdavBackend) List(ctx context.Context, prefix, pageToken string, limit int) (*Page, error) {
+	dirKey, namePrefix := splitPrefix(prefix)
+	entries, err := b.propfind(ctx, dirKey, "1")
+	if Classify("webdav", err).Code == CodeNotFound {
+		return &Page{Entries: []Entry{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*davEntry{}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.key+"/" == dirKey || e.key == dirKey {
+			continue // the folder itself
+		}
+		byName[e.Name()] = e
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	stat := func(name string) (fs.FileInfo, error) { return byName[name], nil }
+	contentType := func(key string) string { return byName[path.Base(key)].contentType }
+	return listDir(names, dirKey, namePrefix, pageToken, limit, stat, contentType), nil
+}
+
+// Walk descends one Depth: 1 PROPFIND at a time; many servers refuse
+// Depth: infinity.
+func (b *webdavBackend) Walk(ctx context.Context, prefix string, fn func(Object) error) error {
+	dirKey, _ := splitPrefix(prefix)
+	dirs := []string{dirKey}
+	for len(dirs) > 0 {
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		entries, err := b.propfind(ctx, dir, "1")
+		if err != nil {
+			if dir == dirKey && Classify("webdav", err).Code == CodeNotFound {
+				return nil
+			}
+			return err
+		}
+		for _, e := range entries {
+			if e.key+"/" == dir || e.key == dir {
+				continue
+			}
+			if e.dir {
+				if strings.HasPrefix(e.key+"/", prefix) || strings.HasPrefix(prefix, e.key+"/") {
+					dirs = append(dirs, e.key+"/")
+				}
+				continue
+			}
+			if !strings.HasPrefix(e.key, prefix) {
+				continue
+			}
+			err := fn(Object{Name: e.key, Size: e.size, Updated: e.modified, ContentType: e.contentType})
+			if err == StopWalk {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *webdavBackend) Stat(ctx context.Context, key string) (*Metadata, error) {
+	entries, err := b.propfind(ctx, key, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, &Error{Code: CodeNotFound, Err: fmt.Errorf("%s not found", key)}
+	}
+	e := entries[0]
+	md := &Metadata{
+		ContentType:  e.contentType,
+		CacheControl: e.cacheControl,
+		Metadata:     e.metadata,
+		Size:         e.size,
+		Updated:      e.modified,
+		ETag:         e.etag,
+	}
+	if e.dir {
+		md.Size = 0
+	}
+	return md, nil
+}
+
+func (b *webdavBackend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, davStatusError("GET", key, resp)
+	}
+	return resp.Body, nil
+}
+
+// OpenRange sends a Range request. Servers that ignore it and return the
+// whole file are read from the start and skipped forward.
+func (b *webdavBackend) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, http.Header{"Range": {httpRange(offset, length)}})
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		return skipAndLimit(resp.Body, offset, length)
+	}
+	resp.Body.Close()
+	return nil, davStatusError("GET", key, resp)
+}
+
+// mkcolAll creates the collection dirKey and its missing parents.
+func (b *webdavBackend) mkcolAll(ctx context.Context, dirKey string) error {
+	if dirKey == "" {
+		return nil
+	}
+	if _, err := b.propfind(ctx, dirKey, "0"); err == nil {
+		return nil
+	}
+	if err := b.mkcolAll(ctx, parentKey(dirKey)); err != nil {
+		return err
+	}
+	resp, err := b.do(ctx, "MKCOL", dirKey, nil, nil)
+	if err != nil {
+		return err
+	}
+	// 405 means the collection already exists.
+	return davExpect("MKCOL", dirKey, resp, http.StatusCreated, http.StatusMethodNotAllowed)
+}
+
+func (b *webdavBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if strings.HasSuffix(key, "/") {
+		return b.mkcolAll(ctx, key)
+	}
+	if err := b.mkcolAll(ctx, parentKey(key)); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, b.url(key), r)
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.auth(req)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return davExpect("PUT", key, resp, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+// transfer runs COPY or MOVE; the server carries dead properties along.
+func (b *webdavBackend) transfer(ctx context.Context, method, src, dst string) error {
+	if err := checkKey(dst); err != nil {
+		return err
+	}
+	if err := b.mkcolAll(ctx, parentKey(dst)); err != nil {
+		return err
+	}
+	resp, err := b.do(ctx, method, src, nil, http.Header{
+		"Destination": {b.url(dst)},
+		"Overwrite":   {"T"},
+	})
+	if err != nil {
+		return err
+	}
+	return davExpect(method, src, resp, http.StatusCreated, http.StatusNoContent)
+}
+
+func (b *webdavBackend) Copy(ctx context.Context, src, dst string) error {
+	return b.transfer(ctx, "COPY", src, dst)
+}
+
+func (b *webdavBackend) Rename(ctx context.Context, src, dst string) error {
+	if err := b.transfer(ctx, "MOVE", src, dst); err != nil {
+		return err
+	}
+	b.pruneEmpty(ctx, parentKey(src))
+	return nil
+}
+
+// Delete removes a file and any folders left empty, like the local provider.
+// A key ending in "/" removes a folder only if it is empty: DELETE on a
+// collection is recursive in WebDAV.
+func (b *webdavBackend) Delete(ctx context.Context, key string) error {
+	if strings.HasSuffix(key, "/") {
+		entries, err := b.propfind(ctx, key, "1")
+		if err != nil {
+			return err
+		}
+		if len(entries) > 1 {
+			return &Error{Code: CodeConflict, Err: fmt.Errorf("folder %s is not empty", key)}
+		}
+	}
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := davExpect("DELETE", key, resp, http.StatusOK, http.StatusNoContent); err != nil {
+		return err
+	}
+	b.pruneEmpty(ctx, parentKey(key))
+	return nil
+}
+
+// pruneEmpty removes dirKey and its parents below the root while they are
+// empty. Failures are ignored; the object operation already succeeded.
+func (b *webdavBackend) pruneEmpty(ctx context.Context, dirKey string) {
+	for ; dirKey != ""; dirKey = parentKey(dirKey) {
+		entries, err := b.propfind(ctx, dirKey, "1")
+		if err != nil || len(entries) > 1 {
+			return
+		}
+		resp, err := b.do(ctx, http.MethodDelete, dirKey, nil, nil)
+		if err != nil || davExpect("DELETE", dirKey, resp, http.StatusOK, http.StatusNoContent) != nil {
+			return
+		}
+	}
+}
+
+// Presign is not possible with WebDAV credentials; downloads are streamed
+// through the server instead.
+func (b *webdavBackend) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+// UpdateMetadata stores the values as dead properties with PROPPATCH. A new
+// Metadata map replaces the old one, so keys missing from it are removed.
+func (b *webdavBackend) UpdateMetadata(ctx context.Context, key string, u MetadataUpdate) error {
+	var set, remove bytes.Buffer
+	prop := func(buf *bytes.Buffer, ns, name, value string) {
+		fmt.Fprintf(buf, `<%s xmlns="%s">`, name, ns)
+		_ = xml.EscapeText(buf, []byte(value))
+		fmt.Fprintf(buf, `</%s>`, name)
+	}
+	if u.ContentType != "" {
+		prop(&set, davObjectNS, "content-type", u.ContentType)
+	}
+	if u.CacheControl != "" {
+		prop(&set, davObjectNS, "cache-control", u.CacheControl)
+	}
+	if u.Metadata != nil {
+		current, err := b.Stat(ctx, key)
+		if err != nil {
+			return err
+		}
+		for k, v := range u.Metadata {
+			if !davMetaKey.MatchString(k) {
+				return &Error{Code: CodeInvalid, Err: fmt.Errorf("metadata key %q is not allowed on WebDAV: use letters, digits, '-', '_' and '.'", k)}
+			}
+			prop(&set, davMetaNS, k, v)
+		}
+		for k := range current.Metadata {
+			if _, keep := u.Metadata[k]; !keep {
+				fmt.Fprintf(&remove, `<%s xmlns="%s"/>`, k, davMetaNS)
+			}
+		}
+	}
+	if set.Len() == 0 && remove.Len() == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><d:propertyupdate xmlns:d="DAV:">`)
+	if set.Len() > 0 {
+		fmt.Fprintf(&body, `<d:set><d:prop>%s</d:prop></d:set>`, set.String())
+	}
+	if remove.Len() > 0 {
+		fmt.Fprintf(&body, `<d:remove><d:prop>%s</d:prop></d:remove>`, remove.String())
+	}
+	body.WriteString(`</d:propertyupdate>`)
+
+	resp, err := b.do(ctx, "PROPPATCH", key, &body, http.Header{"Content-Type": {"application/xml; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return davStatusError("PROPPATCH", key, resp)
+	}
+	// The update is atomic; any failed property is reported with its status
+	// and the others with 424 Failed Dependency.
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return fmt.Errorf("webdav PROPPATCH %q: invalid response: %w", key, err)
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			fields := strings.Fields(ps.Status)
+			if len(fields) < 2 {
+				continue
+			}
+			status, _ := strconv.Atoi(fields[1])
+			if status >= 300 && status != http.StatusFailedDependency {
+				return &Error{
+					Code:    codeForStatus(status),
+					Details: map[string]any{"status": status},
+					Err:     fmt.Errorf("webdav PROPPATCH %q: %s", key, ps.Status),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (b *webdavBackend) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
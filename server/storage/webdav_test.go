@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// startWebDAVServer serves an in-memory WebDAV tree below /dav/. Requests
+// for which override returns true are answered by it instead.
+func startWebDAVServer(t *testing.T, override func(w http.ResponseWriter, r *http.Request) bool) *httptest.Server {
+	t.Helper()
+	dav := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if override != nil && override(w, r) {
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func openWebDAV(t *testing.T, srv *httptest.Server, dir string) *webdavBackend {
+	t.Helper()
+	creds, _ := json.Marshal(map[string]string{"url": srv.URL + "/dav/"})
+	b, err := Open(context.Background(), "webdav", dir, string(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b.(*webdavBackend)
+}
+
+func TestWebDAVEntry(t *testing.T) {
+	b := &webdavBackend{root: "/dav/files/"}
+	for _, c := range []struct {
+		href, key string
+	}{
+		{"/dav/files/", ""},
+		{"/dav/files", ""}, // the root without its trailing slash
+		{"http://cloud.example.com/dav/files/a.txt", "a.txt"},
+		{"/dav/files/My%20Docs/", "My Docs"},
+		{"/dav/files/r%C3%A9sum%C3%A9.pdf", "résumé.pdf"},
+		{"/dav/filesystem/a.txt", "-"}, // shares a prefix with the root
+		{"/dav/", "-"},
+		{"%zz", "-"},
+	} {
+		e := b.entry(davResponse{Href: c.href})
+		switch {
+		case c.key == "-" && e != nil:
+			t.Errorf("entry(%q) = %q, want it skipped", c.href, e.key)
+		case c.key != "-" && e == nil:
+			t.Errorf("entry(%q) was skipped, want %q", c.href, c.key)
+		case c.key != "-" && e.key != c.key:
+			t.Errorf("entry(%q) = %q, want %q", c.href, e.key, c.key)
+		}
+	}
+}
+
+func TestWebDAVBackend(t *testing.T) {
+	srv := startWebDAVServer(t, nil)
+	b := openWebDAV(t, srv, "files")
+	ctx := context.Background()
+	if err := b.Put(ctx, "/", nil, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Test(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for key, body := range map[string]string{
+		"My Docs/a b.txt": "hello",
+		"top.txt":         "top",
+	} {
+		if err := b.Put(ctx, key, strings.NewReader(body), int64(len(body)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := b.List(ctx, "", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range page.Entries {
+		names = append(names, e.Type+":"+e.Name)
+	}
+	if got := strings.Join(names, ","); got != "dir:My Docs/,file:top.txt" {
+		t.Errorf("List = %s", got)
+	}
+	page, err = b.List(ctx, "My Docs/", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Name != "My Docs/a b.txt" || page.Entries[0].Size != 5 {
+		t.Errorf("List(My Docs/) = %+v", page.Entries)
+	}
+	rc, err := b.Open(ctx, "My Docs/a b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello" {
+		t.Errorf("Open = %q", data)
+	}
+
+	// A folder with content is refused; DELETE on it would be recursive.
+	if err := b.Delete(ctx, "My Docs/"); Classify("webdav", err).Code != CodeConflict {
+		t.Errorf("Delete of a non-empty folder = %v, want conflict", err)
+	}
+	if _, err := b.Stat(ctx, "My Docs/a b.txt"); err != nil {
+		t.Errorf("the file is gone after the refused Delete: %v", err)
+	}
+	if err := b.Put(ctx, "empty/", nil, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "empty/"); err != nil {
+		t.Errorf("Delete of an empty folder: %v", err)
+	}
+	if err := b.Delete(ctx, "My Docs/a b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat(ctx, "My Docs/"); Classify("webdav", err).Code != CodeNotFound {
+		t.Errorf("Stat of the emptied folder = %v, want not found", err)
+	}
+}
+
+func TestWebDAVUpdateMetadata(t *testing.T) {
+	srv := startWebDAVServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != "PROPPATCH" || !strings.HasSuffix(r.URL.Path, "/locked.txt") {
+			return false
+		}
+		// A server that refuses one property fails the whole update.
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>`+r.URL.Path+`</d:href>
+<d:propstat><d:prop><content-type xmlns="urn:x-vestra:object"/></d:prop><d:status>HTTP/1.1 424 Failed Dependency</d:status></d:propstat>
+<d:propstat><d:prop><owner xmlns="urn:x-vestra:meta"/></d:prop><d:status>HTTP/1.1 403 Forbidden</d:status></d:propstat>
+</d:response></d:multistatus>`)
+		return true
+	})
+	b := openWebDAV(t, srv, "")
+	ctx := context.Background()
+	for _, key := range []string{"a.txt", "locked.txt"} {
+		if err := b.Put(ctx, key, strings.NewReader("x"), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.UpdateMetadata(ctx, "a.txt", MetadataUpdate{
+		ContentType:  "text/markdown",
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"owner": "ops", "team": "a & b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	md, err := b.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.ContentType != "text/markdown" || md.CacheControl != "no-cache" ||
+		md.Metadata["owner"] != "ops" || md.Metadata["team"] != "a & b" {
+		t.Errorf("Stat after UpdateMetadata = %+v", md)
+	}
+
+	// A new map replaces the old one.
+	if err := b.UpdateMetadata(ctx, "a.txt", MetadataUpdate{Metadata: map[string]string{"owner": "dev"}}); err != nil {
+		t.Fatal(err)
+	}
+	if md, err := b.Stat(ctx, "a.txt"); err != nil || len(md.Metadata) != 1 || md.Metadata["owner"] != "dev" {
+		t.Errorf("Stat after replacing the metadata = %+v, %v", md, err)
+	}
+
+	if err := b.UpdateMetadata(ctx, "a.txt", MetadataUpdate{Metadata: map[string]string{"bad key": "x"}}); Classify("webdav", err).Code != CodeInvalid {
+		t.Errorf("UpdateMetadata with an invalid key = %v, want invalid", err)
+	}
+	err = b.UpdateMetadata(ctx, "locked.txt", MetadataUpdate{ContentType: "text/plain", Metadata: map[string]string{"owner": "ops"}})
+	if got := Classify("webdav", err); got.Code != CodeAccessDenied || got.Details["status"] != http.StatusForbidden {
+		t.Errorf("UpdateMetadata refused by the server = %v (%s), want access denied with status 403", err, got.Code)
+	}
+}
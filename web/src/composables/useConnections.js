@@ -1,5 +1,138 @@
 import { ref } from 'vue'
 
+// apiError turns an error response into an Error carrying the server's
+// { code, message, provider, retryable, details } envelope, so callers can
+// branch on err.code instead of parsing the message.
+export async function apiError(res) {
+  const text = await res.text()
+  let body = {}
+  try { body = JSON.parse(text) } catch { }
+  const err = new Error(body.message || text || res.statusText)
+  err.status    = res.status
+  err.code      = body.code ?? 'unknown'
+  err.provider  = body.provider ?? ''
+  err.retryable = body.retryable ?? false
+  err.details   = body.details ?? {}
+  return err
+}
+
+// ── resumable uploads ──────────────────────────────────────────
+// Files above TUS_THRESHOLD are sent with the tus protocol in TUS_CHUNK
+// slices. The upload URL is remembered in localStorage, so choosing the
+// same file again after a dropped connection or a reload carries on from
+// the offset the server reports instead of starting over.
+
+const TUS_THRESHOLD = 32 * 1024 * 1024
+const TUS_CHUNK     = 8 * 1024 * 1024
+const TUS_RETRIES   = 5
+const TUS_HEADERS   = { 'Tus-Resumable': '1.0.0' }
+
+function tusMetadata(fields) {
+  const b64 = s => btoa(String.fromCharCode(...new TextEncoder().encode(String(s))))
+  return Object.entries(fields).map(([k, v]) => `${k} ${b64(v)}`).join(',')
+}
+
+async function tusOffset(url) {
+  const res = await fetch(url, { method: 'HEAD', headers: TUS_HEADERS, cache: 'no-store' })
+  if (!res.ok) return null
+  return Number(res.headers.get('Upload-Offset'))
+}
+
+async function tusUpload(base, provider, connectionId, prefix, file) {
+  const key = ['vestra-tus', provider, connectionId, prefix, file.name, file.size, file.lastModified].join(':')
+  let url    = localStorage.getItem(key)
+  let offset = url ? await tusOffset(url) : null
+  if (offset === null) {
+    const res = await fetch(base + '/bucket/tus/', {
+      method:  'POST',
+      headers: {
+        ...TUS_HEADERS,
+        'Upload-Length':   String(file.size),
+        'Upload-Metadata': tusMetadata({
+          connection_id: connectionId,
+          prefix,
+          filename:      file.name,
+          filetype:      file.type || 'application/octet-stream',
+        }),
+      },
+    })
+    if (!res.ok) throw await apiError(res)
+    url    = res.headers.get('Location')
+    offset = 0
+    localStorage.setItem(key, url)
+  }
+
+  let failures = 0
+  while (offset < file.size) {
+    try {
+      const res = await fetch(url, {
+        method:  'PATCH',
+        headers: {
+          ...TUS_HEADERS,
+          'Upload-Offset': String(offset),
+          'Content-Type':  'application/offset+octet-stream',
+        },
+        body: file.slice(offset, offset + TUS_CHUNK),
+      })
+      if (!res.ok) throw await apiError(res)
+      offset   = Number(res.headers.get('Upload-Offset'))
+      failures = 0
+    } catch (err) {
+      if (++failures > TUS_RETRIES || (err.status && err.status < 500 && err.code !== 'conflict')) throw err
+      await new Promise(r => setTimeout(r, 1000 * 2 ** failures))
+      const current = await tusOffset(url).catch(() => null)
+      if (current === null) throw err
+      offset = current
+    }
+  }
+  localStorage.removeItem(key)
+}
+
+// ── direct uploads ─────────────────────────────────────────────
+// Where the provider can sign uploads, files go from the browser straight
+// to the bucket and the server only checks the result. The bucket needs a
+// CORS rule allowing PUT from this origin; a connection whose bucket
+// refuses goes through the server for the rest of the session.
+
+const directUnavailable = new Set()
+
+// directUpload returns false when the file should be uploaded another way.
+async function directUpload(base, provider, connectionId, prefix, file) {
+  const key = provider + ':' + connectionId
+  if (directUnavailable.has(key)) return false
+  const res = await fetch(base + '/bucket/upload/presign', {
+    method:  'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body:    JSON.stringify({
+      connection_id: connectionId,
+      object:        prefix + file.name,
+      size:          file.size,
+      content_type:  file.type || 'application/octet-stream',
+    }),
+  })
+  if (!res.ok) {
+    const err = await apiError(res)
+    if (err.code !== 'not_supported') throw err
+    directUnavailable.add(key)
+    return false
+  }
+  const { upload, token } = await res.json()
+  try {
+    const put = await fetch(upload.url, { method: upload.method, headers: upload.headers, body: file })
+    if (!put.ok) throw new Error(put.statusText)
+  } catch {
+    directUnavailable.add(key)
+    return false
+  }
+  const done = await fetch(base + '/bucket/upload/complete', {
+    method:  'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body:    JSON.stringify({ token }),
+  })
+  if (!done.ok) throw await apiError(done)
+  return true
+}
+
 export function useConnections() {
   const connections = ref([])
   const loading     = ref(false)
@@ -16,6 +149,10 @@ export function useConnections() {
     huawei:  '/api/huawei',
     alibaba: '/api/alibaba',
     azure:   '/api/azure',
+    local:   '/api/local',
+    sftp:    '/api/sftp',
+    webdav:  '/api/webdav',
+    memory:  '/api/memory',
   }
 
   // ── connection list ──────────────────────────────────────────
@@ -24,19 +161,9 @@ export function useConnections() {
     loading.value = true
     clearMessages()
     try {
-      const [gcpRes, awsRes, huaweiRes, alibabaRes, azureRes] = await Promise.all([
-        fetch('/api/gcp/connections').then(r => r.ok ? r.json() : []),
-        fetch('/api/aws/connections').then(r => r.ok ? r.json() : []),
-        fetch('/api/huawei/connections').then(r => r.ok ? r.json() : []),
-        fetch('/api/alibaba/connections').then(r => r.ok ? r.json() : []),
-        fetch('/api/azure/connections').then(r => r.ok ? r.json() : []),
-      ])
-      const gcpList     = (gcpRes     || []).map(c => ({ ...c, provider: 'gcp' }))
-      const awsList     = (awsRes     || []).map(c => ({ ...c, provider: 'aws' }))
-      const huaweiList  = (huaweiRes  || []).map(c => ({ ...c, provider: 'huawei' }))
-      const alibabaList = (alibabaRes || []).map(c => ({ ...c, provider: 'alibaba' }))
-      const azureList   = (azureRes   || []).map(c => ({ ...c, provider: 'azure' }))
-      connections.value = [...gcpList, ...awsList, ...huaweiList, ...alibabaList, ...azureList]
+      const res = await fetch('/api/connections')
+      if (!res.ok) throw await apiError(res)
+      connections.value = (await res.json()).connections ?? []
     } catch (err) {
       error.value = 'Failed to load connections.'
     } finally {
@@ -44,17 +171,22 @@ export function useConnections() {
     }
   }
 
-  async function testConnection(provider, bucket, credentials) {
+  // connectionId lets an edit form test with blank (i.e. stored) credentials.
+  async function testConnection(provider, bucket, credentials, connectionId = null) {
     testing.value = true
     clearMessages()
     try {
       const res = await fetch(BASE[provider] + '/test', {
         method:  'POST',
         headers: { 'Content-Type': 'application/json' },
-        body:    JSON.stringify({ bucket, credentials }),
+        body:    JSON.stringify({ bucket, credentials, connection_id: connectionId }),
       })
-      if (!res.ok) error.value = 'Test failed: ' + await res.text()
-      else notice.value = 'Connection test succeeded ✓'
+      if (!res.ok) { error.value = 'Test failed: ' + (await apiError(res)).message; return }
+      const body = await res.json().catch(() => ({}))
+      notice.value = 'Connection test succeeded ✓'
+      if (body.credentials_expire_at) {
+        notice.value += ' Temporary credentials valid until ' + new Date(body.credentials_expire_at).toLocaleTimeString() + ', renewed automatically.'
+      }
     } catch (err) {
       error.value = 'Error: ' + err.message
     } finally {
@@ -71,7 +203,7 @@ export function useConnections() {
         headers: { 'Content-Type': 'application/json' },
         body:    JSON.stringify(form),
       })
-      if (!res.ok) { error.value = 'Save failed: ' + await res.text(); return false }
+      if (!res.ok) { error.value = 'Save failed: ' + (await apiError(res)).message; return false }
       notice.value = 'Connection saved ✓'
       await fetchConnections()
       return true
@@ -92,7 +224,7 @@ export function useConnections() {
         headers: { 'Content-Type': 'application/json' },
         body:    JSON.stringify(form),
       })
-      if (!res.ok) { error.value = 'Update failed: ' + await res.text(); return false }
+      if (!res.ok) { error.value = 'Update failed: ' + (await apiError(res)).message; return false }
       notice.value = 'Connection updated ✓'
       await fetchConnections()
       return true
@@ -108,7 +240,8 @@ export function useConnections() {
     clearMessages()
     try {
       const res = await fetch(`${BASE[provider]}/connection/${id}`, { method: 'DELETE' })
-      if (res.ok) await fetchConnections()
+      if (!res.ok) throw await apiError(res)
+      await fetchConnections()
     } catch (err) {
       error.value = 'Delete failed: ' + err.message
     }
@@ -116,107 +249,187 @@ export function useConnections() {
 
   // ── bucket browsing ──────────────────────────────────────────
 
-  async function browseObjects(provider, bucket, credentials, prefix = '', pageToken = '') {
+  async function browseObjects(provider, connectionId, prefix = '', pageToken = '') {
     const res = await fetch(BASE[provider] + '/bucket/browse', {
       method:  'POST',
       headers: { 'Content-Type': 'application/json' },
-      body:    JSON.stringify({ bucket, credentials, prefix, page_token: pageToken }),
+      body:    JSON.stringify({ connection_id: connectionId, prefix, page_token: pageToken }),
     })
-    if (!res.ok) throw new Error(await res.text())
+    if (!res.ok) throw await apiError(res)
     return res.json() // { prefix, entries, next_page_token }
   }
 
-  async function getDownloadURL(provider, bucket, credentials, object) {
+  async function getDownloadURL(provider, connectionId, object) {
     const res = await fetch(BASE[provider] + '/bucket/download', {
       method:  'POST',
       headers: { 'Content-Type': 'application/json' },
-      body:    JSON.stringify({ bucket, credentials, object }),
+      body:    JSON.stringify({ connection_id: connectionId, object }),
     })
-    if (!res.ok) throw new Error(await res.text())
+    if (!res.ok) throw await apiError(res)
     return (await res.json()).url
   }
 
-  async function deleteObject(provider, bucket, credentials, object) {
+  // Returns { url, name, files, size, missing } for a ZIP or tar.gz of a
+  // folder (prefix) or of keys, where a key ending in "/" is a folder.
+  async function getArchiveURL(provider, connectionId, selection, format = 'zip') {
+    const res = await fetch(BASE[provider] + '/bucket/archive', {
+      method:  'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body:    JSON.stringify({ connection_id: connectionId, ...selection, format }),
+    })
+    if (!res.ok) throw await apiError(res)
+    return res.json()
+  }
+
+  async function deleteObject(provider, connectionId, object) {
     const res = await fetch(BASE[provider] + '/bucket/delete', {
       method:  'POST',
       headers: { 'Content-Type': 'application/json' },
-      body:    JSON.stringify({ bucket, credentials, object }),
+      body:    JSON.stringify({ connection_id: connectionId, object }),
     })
-    if (!res.ok) throw new Error(await res.text())
+    if (!res.ok) throw await apiError(res)
   }
 
-  async function copyObject(provider, bucket, credentials, source, destination, deleteSource = true) {
+  // Deletes { keys } or { prefix, recursive } as a job; resolves with the
+  // finished job, calling onProgress while it runs.
+  async function deleteObjects(provider, connectionId, selection, onProgress) {
+    const res = await fetch(BASE[provider] + '/bucket/delete/batch', {
+      method:  'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body:    JSON.stringify({ connection_id: connectionId, ...selection }),
+    })
+    if (!res.ok) throw await apiError(res)
+    return waitForJob(await res.json(), onProgress)
+  }
+
+  async function copyObject(provider, connectionId, source, destination, deleteSource = true) {
     const res = await fetch(BASE[provider] + '/bucket/copy', {
       method:  'POST',
       headers: { 'Content-Type': 'application/json' },
-      body:    JSON.stringify({ bucket, credentials, source, destination, delete_source: deleteSource }),
+      body:    JSON.stringify({ connection_id: connectionId, source, destination, delete_source: deleteSource }),
+    })
+    if (!res.ok) throw await apiError(res)
+  }
+
+  // Moves every object under source (a folder ending in "/") to the same
+  // path under destination as a job; resolves with the finished job.
+  async function moveFolder(provider, connectionId, source, destination, overwrite, onProgress) {
+    const res = await fetch(BASE[provider] + '/bucket/move', {
+      method:  'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body:    JSON.stringify({ connection_id: connectionId, source, destination, overwrite }),
     })
-    if (!res.ok) throw new Error(await res.text())
+    if (!res.ok) throw await apiError(res)
+    return waitForJob(await res.json(), onProgress)
   }
 
-  async function uploadObjects(provider, bucket, credentials, prefix, files) {
-    await Promise.all(Array.from(files).map(file => {
+  async function uploadObjects(provider, connectionId, prefix, files) {
+    await Promise.all(Array.from(files).map(async file => {
+      if (await directUpload(BASE[provider], provider, connectionId, prefix, file)) return
+      if (file.size > TUS_THRESHOLD) return tusUpload(BASE[provider], provider, connectionId, prefix, file)
       const form = new FormData()
-      form.append('bucket',      bucket)
-      form.append('credentials', credentials)
-      form.append('prefix',      prefix)
-      form.append('file',        file)
+      form.append('connection_id', connectionId)
+      form.append('prefix',        prefix)
+      form.append('size',          file.size)
+      form.append('file',          file)
       return fetch(BASE[provider] + '/bucket/upload', { method: 'POST', body: form }).then(r => {
-        if (!r.ok) return r.text().then(t => { throw new Error(t) })
+        if (!r.ok) return apiError(r).then(err => { throw err })
       })
     }))
   }
 
-  async function getBucketStats(provider, bucket, credentials) {
+  // Uploads a ZIP, tar or tar.gz that the server expands into prefix.
+  async function extractArchive(provider, connectionId, prefix, file) {
+    const form = new FormData()
+    form.append('connection_id', connectionId)
+    form.append('prefix',        prefix)
+    form.append('file',          file)
+    const res = await fetch(BASE[provider] + '/bucket/upload/extract', { method: 'POST', body: form })
+    if (!res.ok) throw await apiError(res)
+    return res.json() // { prefix, format, created: [{ key, size, content_type }], failed: [{ entry, key?, error }] }
+  }
+
+  async function getBucketStats(provider, connectionId) {
     const res = await fetch(BASE[provider] + '/bucket/stats', {
       method:  'POST',
       headers: { 'Content-Type': 'application/json' },
-      body:    JSON.stringify({ bucket, credentials }),
+      body:    JSON.stringify({ connection_id: connectionId }),
     })
-    if (!res.ok) throw new Error(await res.text())
+    if (!res.ok) throw await apiError(res)
     return res.json() // { object_count, total_size, truncated }
   }
 
   // ── metadata ─────────────────────────────────────────────────
 
-  async function getObjectMetadata(provider, bucket, credentials, object) {
+  async function getObjectMetadata(provider, connectionId, object) {
     const res = await fetch(BASE[provider] + '/bucket/metadata', {
       method:  'POST',
       headers: { 'Content-Type': 'application/json' },
-      body:    JSON.stringify({ bucket, credentials, object }),
+      body:    JSON.stringify({ connection_id: connectionId, object }),
     })
-    if (!res.ok) throw new Error(await res.text())
+    if (!res.ok) throw await apiError(res)
     return res.json() // { content_type, cache_control, metadata, size, updated, etag, md5? }
   }
 
-  async function updateObjectMetadata(provider, bucket, credentials, object, patch) {
+  async function updateObjectMetadata(provider, connectionId, object, patch) {
     const res = await fetch(BASE[provider] + '/bucket/metadata/update', {
       method:  'POST',
       headers: { 'Content-Type': 'application/json' },
-      body:    JSON.stringify({ bucket, credentials, object, ...patch }),
+      body:    JSON.stringify({ connection_id: connectionId, object, ...patch }),
     })
-    if (!res.ok) throw new Error(await res.text())
+    if (!res.ok) throw await apiError(res)
+  }
+
+  // ── background jobs ──────────────────────────────────────────
+
+  // Polls a job until it is no longer running. The job is
+  // { id, state, total, done, failed_count, failures, ... }.
+  async function waitForJob(job, onProgress) {
+    while (job.state === 'running') {
+      onProgress?.(job)
+      await new Promise(r => setTimeout(r, 1000))
+      const res = await fetch('/api/jobs/' + job.id)
+      if (!res.ok) throw await apiError(res)
+      job = await res.json()
+    }
+    return job
+  }
+
+  async function cancelJob(id) {
+    const res = await fetch('/api/jobs/' + id, { method: 'DELETE' })
+    if (!res.ok) throw await apiError(res)
+    return res.json()
   }
 
   // ── compat (flat listing) ────────────────────────────────────
 
-  async function listObjects(provider, bucket, credentials) {
+  async function listObjects(provider, connectionId) {
     const res = await fetch(BASE[provider] + '/bucket/objects', {
       method:  'POST',
       headers: { 'Content-Type': 'application/json' },
-      body:    JSON.stringify({ bucket, credentials }),
+      body:    JSON.stringify({ connection_id: connectionId }),
     })
-    if (!res.ok) throw new Error(await res.text())
+    if (!res.ok) throw await apiError(res)
     const data = await res.json()
     return { objects: data.objects ?? [], truncated: data.truncated ?? false }
   }
 
+  // ── S3-compatible presets ────────────────────────────────────
+
+  async function fetchS3Presets() {
+    const res = await fetch('/api/aws/presets')
+    if (!res.ok) throw await apiError(res)
+    return await res.json()
+  }
+
   return {
     connections, loading, testing, saving, error, notice,
     fetchConnections, testConnection, saveConnection, updateConnection,
     removeConnection, clearMessages,
-    browseObjects, getDownloadURL, deleteObject, copyObject,
-    uploadObjects, getBucketStats, listObjects,
+    browseObjects, getDownloadURL, getArchiveURL, deleteObject, deleteObjects, copyObject, moveFolder,
+    waitForJob, cancelJob,
+    uploadObjects, extractArchive, getBucketStats, listObjects,
     getObjectMetadata, updateObjectMetadata,
+    fetchS3Presets,
   }
 }